@@ -4,10 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
-	"strings"
 	"time"
 
+	"github.com/ngenohkevin/veilsupport/internal/config"
 	"github.com/ngenohkevin/veilsupport/internal/xmpp"
 )
 
@@ -19,34 +18,17 @@ func main() {
 	fmt.Println()
 	
 	// Load configuration
-	botJID := os.Getenv("XMPP_CONNECTION_JID")
-	if botJID == "" {
-		log.Fatal("XMPP_CONNECTION_JID not set")
+	if err := config.RequireEnv("XMPP_CONNECTION_JID", "XMPP_CONNECTION_PASSWORD", "XMPP_ADMIN_JID"); err != nil {
+		log.Fatal(err)
 	}
-	
-	botPassword := os.Getenv("XMPP_CONNECTION_PASSWORD")
-	if botPassword == "" {
-		log.Fatal("XMPP_CONNECTION_PASSWORD not set")
-	}
-	
-	xmppServer := os.Getenv("XMPP_SERVER")
-	if xmppServer == "" {
-		xmppServer = "xmpp.jp:5222"
-	}
-	
-	adminJID := os.Getenv("XMPP_ADMIN_JID")
-	if adminJID == "" {
-		log.Fatal("XMPP_ADMIN_JID not set")
+	cfg, err := config.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
+	botJID, botPassword, xmppServer := cfg.XMPPConnectionJID, cfg.XMPPConnectionPassword, cfg.XMPPServer
 	
-	// Multiple admins can be separated by comma
-	adminJIDs := []string{adminJID}
-	if strings.Contains(adminJID, ",") {
-		adminJIDs = strings.Split(adminJID, ",")
-		for i := range adminJIDs {
-			adminJIDs[i] = strings.TrimSpace(adminJIDs[i])
-		}
-	}
+	// Multiple admins can be separated by comma, or listed in XMPP_ADMIN_JIDS
+	adminJIDs := cfg.XMPPAdminJIDs
 	
 	fmt.Printf("📋 Gateway Configuration:\n")
 	fmt.Printf("  Bot JID: %s\n", botJID)
@@ -60,7 +42,7 @@ func main() {
 	// Connect
 	fmt.Println("🔌 Connecting gateway to XMPP server...")
 	ctx := context.Background()
-	err := gateway.Connect(ctx)
+	err = gateway.Connect(ctx)
 	if err != nil {
 		log.Fatalf("Failed to connect gateway: %v", err)
 	}
@@ -0,0 +1,52 @@
+// Command grant-admin gives an existing account access to the admin API,
+// or takes it away with -revoke.
+//
+//	go run ./cmd/grant-admin [-revoke] <email>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ngenohkevin/veilsupport/internal/config"
+	"github.com/ngenohkevin/veilsupport/internal/db"
+)
+
+func main() {
+	revoke := flag.Bool("revoke", false, "revoke admin rights instead of granting them")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-revoke] <email>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	email := flag.Arg(0)
+
+	cfg, err := config.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	database, err := db.New(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	found, err := database.SetUserAdmin(email, !*revoke)
+	if err != nil {
+		log.Fatalf("Failed to update %s: %v", email, err)
+	}
+	if !found {
+		log.Fatalf("No user with email %s", email)
+	}
+	if *revoke {
+		fmt.Printf("Revoked admin rights from %s\n", email)
+	} else {
+		fmt.Printf("Granted admin rights to %s\n", email)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ngenohkevin/veilsupport/internal/config"
 	"github.com/ngenohkevin/veilsupport/internal/xmpp"
 )
 
@@ -23,25 +24,15 @@ func main() {
 	fmt.Println()
 	
 	// Load configuration
-	botJID := os.Getenv("XMPP_CONNECTION_JID")
-	if botJID == "" {
-		log.Fatal("XMPP_CONNECTION_JID not set")
+	if err := config.RequireEnv("XMPP_CONNECTION_JID", "XMPP_CONNECTION_PASSWORD", "XMPP_ADMIN_JID"); err != nil {
+		log.Fatal(err)
 	}
-	
-	botPassword := os.Getenv("XMPP_CONNECTION_PASSWORD")
-	if botPassword == "" {
-		log.Fatal("XMPP_CONNECTION_PASSWORD not set")
-	}
-	
-	xmppServer := os.Getenv("XMPP_SERVER")
-	if xmppServer == "" {
-		xmppServer = "xmpp.jp:5222"
-	}
-	
-	adminJID := os.Getenv("XMPP_ADMIN_JID")
-	if adminJID == "" {
-		log.Fatal("XMPP_ADMIN_JID not set")
+	cfg, err := config.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
+	botJID, botPassword, xmppServer := cfg.XMPPConnectionJID, cfg.XMPPConnectionPassword, cfg.XMPPServer
+	adminJID := cfg.XMPPAdminJID
 	
 	fmt.Printf("📋 Configuration:\n")
 	fmt.Printf("  Bot JID: %s\n", botJID)
@@ -52,10 +43,20 @@ func main() {
 	// Create better bot
 	bot := xmpp.NewBetterBotClient(botJID, botPassword, xmppServer, adminJID)
 	
+	// Simulate delivering replies to the website users
+	bot.SetReplyHandler(func(userID int, reply string) error {
+		fmt.Printf("💬 Reply would be sent to User #%d via WebSocket: %s\n", userID, reply)
+		return nil
+	})
+	bot.SetVIPHandler(func(userID int, vip bool) error {
+		fmt.Printf("⭐ User #%d VIP status would be set to %v\n", userID, vip)
+		return nil
+	})
+	
 	// Connect
 	fmt.Println("🔌 Connecting to XMPP server...")
 	ctx := context.Background()
-	err := bot.Connect(ctx)
+	err = bot.Connect(ctx)
 	if err != nil {
 		log.Fatalf("Failed to connect: %v", err)
 	}
@@ -139,6 +140,7 @@ func main() {
 	fmt.Println("  /info USER_ID - Get user details")
 	fmt.Println("  /help - Show available commands")
 	fmt.Println("  @USER_ID message - Reply to a user")
+	fmt.Println("  /reply-multi ID1,ID2 message - Reply to several users")
 	fmt.Println("  quit - Exit program")
 	fmt.Println("══════════════════════════════════════════════════")
 	fmt.Println()
@@ -165,15 +167,6 @@ func main() {
 		if err != nil {
 			fmt.Printf("Error: %v\n", err)
 		}
-		
-		// If it's a reply, also simulate sending it to the user
-		if strings.HasPrefix(input, "@") {
-			userID, reply, err := bot.ParseAdminReply(input)
-			if err == nil {
-				fmt.Printf("💬 Reply would be sent to User #%d via WebSocket: %s\n", 
-					userID, reply)
-			}
-		}
 	}
 	
 	fmt.Println()
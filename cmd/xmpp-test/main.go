@@ -4,33 +4,23 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
 	"time"
 
+	"github.com/ngenohkevin/veilsupport/internal/config"
 	"github.com/ngenohkevin/veilsupport/internal/xmpp"
 )
 
 func main() {
 	// Load XMPP configuration from environment
-	xmppServer := os.Getenv("XMPP_SERVER")
-	if xmppServer == "" {
-		xmppServer = "xmpp.jp:5222"
+	if err := config.RequireEnv("XMPP_CONNECTION_JID", "XMPP_CONNECTION_PASSWORD", "XMPP_ADMIN_JID"); err != nil {
+		log.Fatal(err)
 	}
-	
-	xmppJID := os.Getenv("XMPP_CONNECTION_JID")
-	if xmppJID == "" {
-		log.Fatal("XMPP_CONNECTION_JID not set")
-	}
-	
-	xmppPassword := os.Getenv("XMPP_CONNECTION_PASSWORD")
-	if xmppPassword == "" {
-		log.Fatal("XMPP_CONNECTION_PASSWORD not set")
-	}
-	
-	adminJID := os.Getenv("XMPP_ADMIN_JID")
-	if adminJID == "" {
-		log.Fatal("XMPP_ADMIN_JID not set")
+	cfg, err := config.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
+	xmppServer, xmppJID, xmppPassword := cfg.XMPPServer, cfg.XMPPConnectionJID, cfg.XMPPConnectionPassword
+	adminJID := cfg.XMPPAdminJID
 	
 	fmt.Printf("Testing XMPP connection:\n")
 	fmt.Printf("  Server: %s\n", xmppServer)
@@ -44,7 +34,7 @@ func main() {
 	// Connect
 	fmt.Println("Connecting to XMPP server...")
 	ctx := context.Background()
-	err := client.ConnectWithContext(ctx)
+	err = client.ConnectWithContext(ctx)
 	if err != nil {
 		log.Fatalf("Failed to connect: %v", err)
 	}
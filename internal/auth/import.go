@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/ngenohkevin/veilsupport/internal/db"
+)
+
+// ErrInvalidResetToken is returned by ResetPassword for unknown, used or expired tokens
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// Import row outcomes
+const (
+	ImportCreated   = "created"
+	ImportDuplicate = "duplicate"
+	ImportInvalid   = "invalid"
+)
+
+// DefaultResetTTL is how long reset links stay valid unless configured otherwise
+const DefaultResetTTL = 7 * 24 * time.Hour
+
+// unusablePasswordHash is stored for imported users until they choose a
+// password. It isn't a bcrypt hash, so no password ever matches it.
+const unusablePasswordHash = "!"
+
+// ImportRow is one user to pre-provision
+type ImportRow struct {
+	Line        int // Position in the source file, for reporting
+	Email       string
+	DisplayName string // Stored in metadata as display_name
+	Metadata    db.Metadata
+}
+
+// ImportResult reports what happened to one row. Created users get a
+// one-time reset token, and a link when a reset URL is configured.
+type ImportResult struct {
+	Line       int    `json:"line"`
+	Email      string `json:"email"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	UserID     int    `json:"user_id,omitempty"`
+	ResetToken string `json:"reset_token,omitempty"`
+	ResetURL   string `json:"reset_url,omitempty"`
+}
+
+// SetPasswordResets configures reset links. urlTemplate may contain {token},
+// e.g. "https://support.example.com/reset?token={token}"; empty means only
+// the token is handed out. ttl is how long links stay valid.
+func (a *AuthService) SetPasswordResets(urlTemplate string, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultResetTTL
+	}
+	a.resetURL = urlTemplate
+	a.resetTTL = ttl
+}
+
+// ImportUsers creates a user who can't log in yet and a reset token for
+// each valid row. Rows whose email is malformed, or whose metadata is, are
+// reported as invalid; emails that already exist or repeat an earlier row
+// are reported as duplicates. The users are created in one transaction, so
+// an error means nobody was created.
+func (a *AuthService) ImportUsers(rows []ImportRow) ([]ImportResult, error) {
+	results := make([]ImportResult, len(rows))
+	var pending []db.NewUser
+	var pendingRows []int
+	seen := make(map[string]bool)
+
+	for i, row := range rows {
+		email := strings.TrimSpace(row.Email)
+		results[i] = ImportResult{Line: row.Line, Email: email}
+
+		metadata, err := importMetadata(row)
+		if err == nil {
+			err = validateEmail(email)
+		}
+		if err != nil {
+			results[i].Status = ImportInvalid
+			results[i].Error = err.Error()
+			continue
+		}
+
+		key := strings.ToLower(email)
+		existing, err := a.db.GetUserByEmail(email)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check existing user: %w", err)
+		}
+		if existing != nil || seen[key] {
+			results[i].Status = ImportDuplicate
+			results[i].Error = "email already registered"
+			continue
+		}
+		seen[key] = true
+
+		token, tokenHash, err := newResetToken()
+		if err != nil {
+			return nil, err
+		}
+		results[i].ResetToken = token
+		pending = append(pending, db.NewUser{
+			Email:          email,
+			PasswordHash:   unusablePasswordHash,
+			Metadata:       metadata,
+			ResetTokenHash: tokenHash,
+			ResetTTL:       a.resetTTL,
+		})
+		pendingRows = append(pendingRows, i)
+	}
+
+	if a.maxUsers > 0 && len(pending) > 0 {
+		count, err := a.db.CountUsers()
+		if err != nil {
+			return nil, fmt.Errorf("failed to count users: %w", err)
+		}
+		if count+len(pending) > a.maxUsers {
+			return nil, ErrRegistrationClosed
+		}
+	}
+
+	created, err := a.db.CreateUsers(pending)
+	if err != nil {
+		return nil, err
+	}
+	for n, user := range created {
+		result := &results[pendingRows[n]]
+		result.Status = ImportCreated
+		result.UserID = user.ID
+		if a.resetURL != "" {
+			result.ResetURL = strings.ReplaceAll(a.resetURL, "{token}", result.ResetToken)
+		}
+	}
+	return results, nil
+}
+
+// ResetPassword sets a new password using a reset token and logs the user
+// in, revoking every token issued before
+func (a *AuthService) ResetPassword(token, password string) (*db.User, string, error) {
+	hash, err := a.HashPassword(password)
+	if err != nil {
+		return nil, "", err
+	}
+
+	user, err := a.db.ConsumePasswordReset(hashResetToken(token), hash)
+	if err != nil {
+		return nil, "", err
+	}
+	if user == nil {
+		return nil, "", ErrInvalidResetToken
+	}
+
+	jwtToken, err := a.GenerateToken(user.ID, user.Email)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return user, jwtToken, nil
+}
+
+// newResetToken generates a reset token along with the hash that is stored for it
+func newResetToken() (token, tokenHash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("failed to generate reset token: %w", err)
+	}
+	token = hex.EncodeToString(secret)
+	return token, hashResetToken(token), nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// importMetadata merges a row's display name into its metadata
+func importMetadata(row ImportRow) (db.Metadata, error) {
+	metadata := db.Metadata{}
+	for key, value := range row.Metadata {
+		metadata[key] = value
+	}
+	if name := strings.TrimSpace(row.DisplayName); name != "" {
+		metadata["display_name"] = name
+	}
+	if err := validateMetadata(metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return errors.New("invalid email address")
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ngenohkevin/veilsupport/internal/db"
+)
+
+var (
+	// ErrTokenRevoked is returned by ValidateToken for revoked or unknown tokens
+	ErrTokenRevoked = errors.New("token revoked")
+	// ErrTokenNotFound is returned when revoking a token the user doesn't have
+	ErrTokenNotFound = errors.New("session not found")
+)
+
+// trackToken records a token about to be issued and returns its ID
+func (a *AuthService) trackToken(userID int, ttl time.Duration) (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate token ID: %w", err)
+	}
+	id := hex.EncodeToString(raw)
+
+	if err := a.db.CreateAuthToken(id, userID, ttl); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// checkRevoked rejects tokens that were revoked or never recorded. Without a
+// database tokens aren't tracked, so every validly signed one is accepted.
+// Tokens without an ID were issued before tokens were tracked; they are
+// accepted until they expire rather than logging everybody out, but can't
+// be revoked.
+func (a *AuthService) checkRevoked(claims *Claims) error {
+	if a.db == nil || claims.ID == "" {
+		return nil
+	}
+
+	token, err := a.db.GetAuthToken(claims.ID)
+	if err != nil {
+		return err
+	}
+	if token == nil || token.RevokedAt != nil || token.UserID != claims.UserID {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// TouchToken records the device a token was just used from
+func (a *AuthService) TouchToken(id, userAgent, ip string) error {
+	if a.db == nil || id == "" {
+		return nil
+	}
+	return a.db.TouchAuthToken(id, userAgent, ip)
+}
+
+// ListTokens returns a page of the user's active tokens, one per login, and
+// whether more remain
+func (a *AuthService) ListTokens(userID int, page db.Page) ([]db.AuthToken, bool, error) {
+	return a.db.GetActiveAuthTokens(userID, page)
+}
+
+// RevokeToken revokes one of the user's active tokens
+func (a *AuthService) RevokeToken(userID int, id string) error {
+	revoked, err := a.db.RevokeAuthToken(userID, id)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+// RevokeOtherTokens revokes every active token of the user's except keepID,
+// logging them out everywhere else, and returns how many were revoked
+func (a *AuthService) RevokeOtherTokens(userID int, keepID string) (int, error) {
+	return a.db.RevokeOtherAuthTokens(userID, keepID)
+}
+
+// PruneExpiredTokens forgets tokens past their expiry and returns how many
+func (a *AuthService) PruneExpiredTokens() (int, error) {
+	return a.db.DeleteExpiredAuthTokens()
+}
+
+// StartTokenCleanup prunes expired tokens every interval until ctx is cancelled
+func (a *AuthService) StartTokenCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			pruned, err := a.PruneExpiredTokens()
+			if err != nil {
+				log.Printf("Token cleanup failed: %v", err)
+			}
+			if pruned > 0 {
+				log.Printf("Token cleanup: %d expired", pruned)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
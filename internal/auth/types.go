@@ -1,18 +1,46 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/ngenohkevin/veilsupport/internal/db"
+	"github.com/ngenohkevin/veilsupport/internal/i18n"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var (
+	// ErrRegistrationClosed is returned by Register once the user cap is reached
+	ErrRegistrationClosed = errors.New("registration closed: user capacity reached")
+	// ErrInvalidMetadata is returned when user metadata has too many or malformed keys
+	ErrInvalidMetadata = errors.New("invalid metadata")
+	// ErrUnknownUser is returned by FindOrCreateUser when the user doesn't exist and may not be created
+	ErrUnknownUser = errors.New("unknown user")
+)
+
+// Limits on integrator-supplied user metadata
+const (
+	maxMetadataKeys     = 20
+	maxMetadataKeyLen   = 64
+	maxMetadataValueLen = 255 // Characters of a string, or bytes of any other value as JSON
+)
+
 type AuthService struct {
-	db        *db.DB
-	jwtSecret string
+	db              *db.DB
+	jwtSecret       string
+	previousSecrets []string // Accepted for validation only, to allow graceful rotation
+	maxUsers        int      // 0 means unlimited
+	resetURL        string   // Reset link template with {token}; see SetPasswordResets
+	resetTTL        time.Duration
+	bcryptCost      int // Hashes below this cost are upgraded on login
 }
 
 type Claims struct {
@@ -23,9 +51,39 @@ type Claims struct {
 
 func NewAuthService(database *db.DB, jwtSecret string) *AuthService {
 	return &AuthService{
-		db:        database,
-		jwtSecret: jwtSecret,
+		db:         database,
+		jwtSecret:  jwtSecret,
+		resetTTL:   DefaultResetTTL,
+		bcryptCost: bcrypt.DefaultCost,
+	}
+}
+
+// SetPreviousSecrets configures retired JWT secrets that are still accepted when
+// validating tokens. New tokens are always signed with the primary secret.
+// Surrounding whitespace is trimmed and blank entries are ignored.
+func (a *AuthService) SetPreviousSecrets(secrets []string) {
+	a.previousSecrets = nil
+	for _, secret := range secrets {
+		secret = strings.TrimSpace(secret)
+		if secret != "" && secret != a.jwtSecret {
+			a.previousSecrets = append(a.previousSecrets, secret)
+		}
+	}
+}
+
+// SetMaxUsers caps the total number of registered users; 0 removes the cap
+func (a *AuthService) SetMaxUsers(max int) {
+	a.maxUsers = max
+}
+
+// SetBcryptCost sets the cost new password hashes are made with. Users whose
+// hash has a lower cost get it rehashed the next time they log in. 0 uses
+// bcrypt.DefaultCost.
+func (a *AuthService) SetBcryptCost(cost int) {
+	if cost <= 0 {
+		cost = bcrypt.DefaultCost
 	}
+	a.bcryptCost = cost
 }
 
 func (a *AuthService) HashPassword(password string) (string, error) {
@@ -33,7 +91,7 @@ func (a *AuthService) HashPassword(password string) (string, error) {
 		return "", errors.New("password cannot be empty")
 	}
 	
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), a.bcryptCost)
 	if err != nil {
 		return "", fmt.Errorf("failed to hash password: %w", err)
 	}
@@ -47,16 +105,27 @@ func (a *AuthService) CheckPassword(password, hash string) bool {
 }
 
 func (a *AuthService) GenerateToken(userID int, email string) (string, error) {
+	ttl := 24 * time.Hour
+	expires := time.Now().Add(ttl)
 	claims := Claims{
 		UserID: userID,
 		Email:  email,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(expires),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 		},
 	}
 	
+	// With a database, tokens are recorded so they can be listed and revoked
+	if a.db != nil {
+		id, err := a.trackToken(userID, ttl)
+		if err != nil {
+			return "", err
+		}
+		claims.ID = id
+	}
+	
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString([]byte(a.jwtSecret))
 	if err != nil {
@@ -71,11 +140,31 @@ func (a *AuthService) ValidateToken(tokenString string) (*Claims, error) {
 		return nil, errors.New("token cannot be empty")
 	}
 	
+	// Try the primary secret first, then any previous secrets still in rotation
+	claims, err := a.parseToken(tokenString, a.jwtSecret)
+	for _, secret := range a.previousSecrets {
+		if err == nil || !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+			break
+		}
+		claims, err = a.parseToken(tokenString, secret)
+	}
+	if err != nil {
+		return nil, err
+	}
+	
+	if err := a.checkRevoked(claims); err != nil {
+		return nil, err
+	}
+	
+	return claims, nil
+}
+
+func (a *AuthService) parseToken(tokenString, secret string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(a.jwtSecret), nil
+		return []byte(secret), nil
 	})
 	
 	if err != nil {
@@ -95,6 +184,17 @@ func (a *AuthService) ValidateToken(tokenString string) (*Claims, error) {
 }
 
 func (a *AuthService) Register(email, password string) (*db.User, string, error) {
+	return a.RegisterWithMetadata(email, password, nil)
+}
+
+// RegisterWithMetadata registers a user with integrator metadata. Metadata
+// is shown to agents as trusted context, so it must only come from trusted
+// callers such as the signed inbound webhook, never from self-registration.
+func (a *AuthService) RegisterWithMetadata(email, password string, metadata db.Metadata) (*db.User, string, error) {
+	if err := validateMetadata(metadata); err != nil {
+		return nil, "", err
+	}
+	
 	// Check if user already exists
 	existing, err := a.db.GetUserByEmail(email)
 	if err != nil {
@@ -110,11 +210,19 @@ func (a *AuthService) Register(email, password string) (*db.User, string, error)
 		return nil, "", err
 	}
 	
-	// Create user
-	user, err := a.db.CreateUser(email, hash)
+	// Create user, within the registration cap when there is one
+	var user *db.User
+	if a.maxUsers > 0 {
+		user, err = a.db.CreateUserWithinLimit(email, hash, metadata, a.maxUsers)
+	} else {
+		user, err = a.db.CreateUserWithMetadata(email, hash, metadata)
+	}
 	if err != nil {
 		return nil, "", fmt.Errorf("failed to create user: %w", err)
 	}
+	if user == nil {
+		return nil, "", ErrRegistrationClosed
+	}
 	
 	// Generate token
 	token, err := a.GenerateToken(user.ID, user.Email)
@@ -140,6 +248,11 @@ func (a *AuthService) Login(email, password string) (*db.User, string, error) {
 		return nil, "", errors.New("invalid credentials")
 	}
 	
+	// Only now is the plain password at hand to upgrade an outdated hash
+	if err := a.upgradeHash(user, password); err != nil {
+		log.Printf("Failed to upgrade password hash for user %d: %v", user.ID, err)
+	}
+	
 	// Generate token
 	token, err := a.GenerateToken(user.ID, user.Email)
 	if err != nil {
@@ -147,4 +260,150 @@ func (a *AuthService) Login(email, password string) (*db.User, string, error) {
 	}
 	
 	return user, token, nil
-}
\ No newline at end of file
+}
+
+// upgradeHash rehashes the user's password if their hash has a lower cost than
+// the configured one
+func (a *AuthService) upgradeHash(user *db.User, password string) error {
+	cost, err := bcrypt.Cost([]byte(user.PasswordHash))
+	if err != nil {
+		return fmt.Errorf("failed to read hash cost: %w", err)
+	}
+	if cost >= a.bcryptCost {
+		return nil
+	}
+	
+	hash, err := a.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := a.db.SetUserPasswordHash(user.ID, hash); err != nil {
+		return err
+	}
+	user.PasswordHash = hash
+	return nil
+}
+
+// SetLocale updates the user's preferred locale for system messages.
+// An empty locale resets it to the server default.
+func (a *AuthService) SetLocale(userID int, locale string) (*db.User, error) {
+	if locale != "" && !i18n.Valid(locale) {
+		return nil, errors.New("invalid locale")
+	}
+	
+	if err := a.db.SetUserLocale(userID, i18n.Normalize(locale)); err != nil {
+		return nil, err
+	}
+	
+	user, err := a.db.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+	
+	return user, nil
+}
+
+// FindOrCreateUser looks up a user by email. When they don't exist and create
+// is set they are registered with the given metadata and a random password,
+// so they can't log in until it is reset; otherwise ErrUnknownUser is
+// returned. The bool reports whether the user was created.
+func (a *AuthService) FindOrCreateUser(email string, create bool, metadata db.Metadata) (*db.User, bool, error) {
+	user, err := a.db.GetUserByEmail(email)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user != nil {
+		return user, false, nil
+	}
+	if !create {
+		return nil, false, ErrUnknownUser
+	}
+	
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, false, fmt.Errorf("failed to generate password: %w", err)
+	}
+	
+	user, _, err = a.RegisterWithMetadata(email, hex.EncodeToString(secret), metadata)
+	if err != nil {
+		return nil, false, err
+	}
+	return user, true, nil
+}
+
+// GetUser looks up a user by ID, returning nil if they don't exist
+func (a *AuthService) GetUser(userID int) (*db.User, error) {
+	user, err := a.db.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+// SetMetadata replaces the user's integrator metadata
+func (a *AuthService) SetMetadata(userID int, metadata db.Metadata) (*db.User, error) {
+	if err := validateMetadata(metadata); err != nil {
+		return nil, err
+	}
+	
+	user, err := a.db.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+	
+	if err := a.db.SetUserMetadata(userID, metadata); err != nil {
+		return nil, err
+	}
+	user.Metadata = metadata
+	return user, nil
+}
+
+// SetVIP marks or unmarks the user as a VIP
+func (a *AuthService) SetVIP(userID int, vip bool) (*db.User, error) {
+	user, err := a.db.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+	
+	if err := a.db.SetUserVIP(userID, vip); err != nil {
+		return nil, err
+	}
+	user.VIP = vip
+	return user, nil
+}
+
+func validateMetadata(metadata db.Metadata) error {
+	if len(metadata) > maxMetadataKeys {
+		return fmt.Errorf("%w: at most %d keys allowed", ErrInvalidMetadata, maxMetadataKeys)
+	}
+	for key, value := range metadata {
+		if key == "" || len(key) > maxMetadataKeyLen {
+			return fmt.Errorf("%w: keys must be 1-%d characters", ErrInvalidMetadata, maxMetadataKeyLen)
+		}
+		if metadataValueLen(value) > maxMetadataValueLen {
+			return fmt.Errorf("%w: value of %q is longer than %d characters", ErrInvalidMetadata, key, maxMetadataValueLen)
+		}
+	}
+	return nil
+}
+
+// metadataValueLen measures a string in characters and anything else by its JSON
+func metadataValueLen(value interface{}) int {
+	if str, ok := value.(string); ok {
+		return utf8.RuneCountInString(str)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return maxMetadataValueLen + 1
+	}
+	return len(data)
+}
@@ -0,0 +1,124 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ngenohkevin/veilsupport/internal/db"
+)
+
+// pendingNotification is a user message waiting for its batching window to close
+type pendingNotification struct {
+	user *db.User
+	msg  *db.Message
+}
+
+// notificationBatch collects admin notifications until its window closes
+type notificationBatch struct {
+	window  time.Duration
+	pending []pendingNotification
+	timer   *time.Timer
+	mu      sync.Mutex
+}
+
+// SetNotificationBatchWindow makes the service collect new-message
+// notifications for the given window and send the admin a single digest
+// instead of one stanza per message. A message that arrives alone in its
+// window is still sent in full. 0 disables batching.
+func (s *ChatService) SetNotificationBatchWindow(window time.Duration) {
+	s.batch.mu.Lock()
+	defer s.batch.mu.Unlock()
+	s.batch.window = window
+}
+
+// notifyAdmin forwards a new user message to the admin, either straight away
+// or as part of the current batch. VIPs' messages are never held back.
+func (s *ChatService) notifyAdmin(ctx context.Context, user *db.User, msg *db.Message) error {
+	s.batch.mu.Lock()
+	window := s.batch.window
+	if window <= 0 || user.VIP {
+		s.batch.mu.Unlock()
+		return s.bridgeMessage(ctx, user, msg)
+	}
+
+	s.batch.pending = append(s.batch.pending, pendingNotification{user: user, msg: msg})
+	if s.batch.timer == nil {
+		s.batch.timer = time.AfterFunc(window, s.FlushNotifications)
+	}
+	s.batch.mu.Unlock()
+	return nil
+}
+
+// FlushNotifications sends any batched notifications now without waiting
+// for the window to close
+func (s *ChatService) FlushNotifications() {
+	s.batch.mu.Lock()
+	pending := s.batch.pending
+	s.batch.pending = nil
+	if s.batch.timer != nil {
+		s.batch.timer.Stop()
+		s.batch.timer = nil
+	}
+	s.batch.mu.Unlock()
+
+	switch len(pending) {
+	case 0:
+		return
+	case 1:
+		if err := s.bridgeMessage(context.Background(), pending[0].user, pending[0].msg); err != nil {
+			log.Printf("%v - message saved to database only", err)
+		}
+		return
+	}
+
+	adminJID, err := s.adminTarget()
+	if err != nil {
+		log.Printf("%v - %d batched messages saved to database only", err, len(pending))
+		return
+	}
+
+	status := db.StatusSent
+	if err := s.sendToAdmin(context.Background(), adminJID, formatDigest(pending)); err != nil {
+		log.Printf("XMPP digest send failed: %v", err)
+		status = db.StatusFailed
+	} else {
+		log.Printf("XMPP digest of %d messages sent to %s", len(pending), adminJID)
+	}
+	for _, notification := range pending {
+		s.setMessageStatus(notification.msg, status)
+	}
+}
+
+// formatDigest summarizes a batch of messages, e.g. "5 new messages from 3 users"
+func formatDigest(pending []pendingNotification) string {
+	counts := make(map[string]int)
+	for _, notification := range pending {
+		counts[notification.user.Email]++
+	}
+
+	emails := make([]string, 0, len(counts))
+	for email := range counts {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📬 %s from %s\n", plural(len(pending), "new message"), plural(len(counts), "user")))
+	for _, email := range emails {
+		sb.WriteString(fmt.Sprintf("• %s: %s\n", email, plural(counts[email], "message")))
+	}
+	sb.WriteString("Open the dashboard to read them.")
+	return sb.String()
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,90 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ngenohkevin/veilsupport/internal/db"
+)
+
+// EditMessage replaces the content of one of the user's own messages and
+// tells their WebSocket about it
+func (s *ChatService) EditMessage(userID, messageID int, content string) (*db.Message, error) {
+	if err := s.checkOwnMessage(userID, messageID); err != nil {
+		return nil, err
+	}
+
+	content = s.cleanContent(content)
+	if strings.TrimSpace(content) == "" {
+		return nil, ErrEmptyMessage
+	}
+
+	msg, err := s.db.EditMessage(messageID, content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to edit message: %w", err)
+	}
+	if msg == nil {
+		return nil, ErrMessageNotFound
+	}
+
+	s.pushMessageEvent(msg.UserID, map[string]interface{}{
+		"type":       "edited",
+		"message_id": msg.ID,
+		"seq":        msg.Seq,
+		"content":    msg.Content,
+		"edited_at":  msg.EditedAt,
+	})
+	return msg, nil
+}
+
+// DeleteMessage soft-deletes one of the user's own messages and tells their
+// WebSocket about it
+func (s *ChatService) DeleteMessage(userID, messageID int) (*db.Message, error) {
+	if err := s.checkOwnMessage(userID, messageID); err != nil {
+		return nil, err
+	}
+
+	msg, err := s.db.DeleteMessage(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete message: %w", err)
+	}
+	if msg == nil {
+		return nil, ErrMessageNotFound
+	}
+
+	s.pushMessageEvent(msg.UserID, map[string]interface{}{
+		"type":       "deleted",
+		"message_id": msg.ID,
+		"seq":        msg.Seq,
+	})
+	return msg, nil
+}
+
+// checkOwnMessage makes sure the message is one the user sent and hasn't
+// deleted. Anything else is reported as ErrMessageNotFound.
+func (s *ChatService) checkOwnMessage(userID, messageID int) error {
+	msg, err := s.db.GetMessageByID(messageID)
+	if err != nil {
+		return fmt.Errorf("failed to get message: %w", err)
+	}
+	if msg == nil || msg.UserID != userID || msg.SenderType != db.SenderUser || msg.DeletedAt != nil {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+// pushMessageEvent sends an event about a stored message to the user's WebSocket
+func (s *ChatService) pushMessageEvent(userID int, event map[string]interface{}) {
+	if s.ws == nil {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal %v event: %v", event["type"], err)
+		return
+	}
+	s.ws.SendToUser(userID, data)
+}
@@ -0,0 +1,117 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ngenohkevin/veilsupport/internal/storage"
+)
+
+// expiryBatchSize bounds how many attachments are loaded per query during cleanup
+const expiryBatchSize = 100
+
+// SetAttachmentStorage sets where uploaded attachment files live, so expired
+// ones can be deleted. Without it expiry only marks the rows.
+func (s *ChatService) SetAttachmentStorage(backend storage.Backend) {
+	s.storage = backend
+}
+
+// ExpireAttachments deletes the files of attachments older than maxAge and
+// marks them expired, leaving the rows as placeholders in history. Files
+// stored elsewhere, such as on an admin's XMPP upload server, can't be
+// deleted and are only marked. Files that fail to delete are kept for the
+// next run. It returns how many attachments were expired.
+func (s *ChatService) ExpireAttachments(maxAge time.Duration) (int, error) {
+	expired := 0
+	afterID := 0
+	for {
+		attachments, err := s.db.GetExpirableAttachments(maxAge, afterID, expiryBatchSize)
+		if err != nil {
+			return expired, err
+		}
+
+		for _, attachment := range attachments {
+			afterID = attachment.ID
+			if s.storage != nil {
+				err := s.storage.Delete(attachment.URL)
+				if err != nil && !errors.Is(err, storage.ErrNotStored) {
+					log.Printf("Failed to delete expired attachment %d: %v", attachment.ID, err)
+					continue
+				}
+			}
+			if err := s.db.MarkAttachmentExpired(attachment.ID); err != nil {
+				return expired, fmt.Errorf("failed to expire attachment %d: %w", attachment.ID, err)
+			}
+			expired++
+		}
+
+		if len(attachments) < expiryBatchSize {
+			return expired, nil
+		}
+	}
+}
+
+// StartAttachmentCleanup expires attachments older than maxAge every interval until ctx is cancelled
+func (s *ChatService) StartAttachmentCleanup(ctx context.Context, maxAge, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			expired, err := s.ExpireAttachments(maxAge)
+			if err != nil {
+				log.Printf("Attachment cleanup failed: %v", err)
+			}
+			if expired > 0 {
+				log.Printf("Attachment cleanup: %d expired", expired)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// PurgeExpiredMessages deletes messages whose TTL has passed and tells each
+// owner's WebSocket to remove them, with the same event as DeleteMessage.
+// Copies already bridged to admins can't be recalled. It returns how many
+// messages were purged.
+func (s *ChatService) PurgeExpiredMessages() (int, error) {
+	messages, err := s.db.DeleteExpiredMessages()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, msg := range messages {
+		s.pushMessageEvent(msg.UserID, map[string]interface{}{
+			"type":       "deleted",
+			"message_id": msg.ID,
+			"seq":        msg.Seq,
+		})
+	}
+	return len(messages), nil
+}
+
+// StartMessagePurge purges expired messages every interval until ctx is cancelled
+func (s *ChatService) StartMessagePurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			purged, err := s.PurgeExpiredMessages()
+			if err != nil {
+				log.Printf("Message purge failed: %v", err)
+			}
+			if purged > 0 {
+				log.Printf("Message purge: %d expired", purged)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
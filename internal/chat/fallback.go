@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ngenohkevin/veilsupport/internal/xmpp"
+)
+
+// SetFallbackAdmin makes replies that can't be routed to a user, such as
+// ones addressed to an unknown JID, be forwarded to the given admin instead
+// of only being logged. Empty disables forwarding.
+func (s *ChatService) SetFallbackAdmin(jid string) {
+	s.fallbackJID = jid
+}
+
+// forwardUnroutable sends a reply that matched no user to the fallback
+// admin so it isn't lost. Only replies from allowed admins are forwarded;
+// anything else is the same noise HandleAdminReply drops.
+func (s *ChatService) forwardUnroutable(msg xmpp.XMPPMessage) {
+	if s.fallbackJID == "" || !s.admins.Allows(msg.From) {
+		return
+	}
+	if s.xmpp == nil || !s.xmpp.IsConnected() {
+		log.Printf("Can't forward unroutable reply from %s: XMPP not connected", msg.From)
+		return
+	}
+
+	notice := unroutableNotice(msg.From, msg.To, msg.Body, msg.Attachments)
+	if err := s.sendToAdmin(context.Background(), s.fallbackJID, notice); err != nil {
+		log.Printf("Failed to forward unroutable reply from %s to %s: %v", msg.From, s.fallbackJID, err)
+	}
+}
+
+// forwardUnroutable sends an admin's reply that matched no user to the
+// fallback admin through the gateway bot. The gateway has already dropped
+// replies from anyone but admins.
+func (s *GatewayService) forwardUnroutable(from, body string, routeErr error) {
+	if s.fallbackJID == "" {
+		return
+	}
+	if s.gateway == nil || !s.gateway.IsConnected() {
+		log.Printf("Gateway: Can't forward unroutable reply from %s: not connected", from)
+		return
+	}
+
+	notice := unroutableNotice(from, "unknown user", body, nil)
+	if err := s.gateway.SendText(s.fallbackJID, notice); err != nil {
+		log.Printf("Gateway: Failed to forward unroutable reply from %s to %s: %v", from, s.fallbackJID, err)
+		return
+	}
+	log.Printf("Gateway: Forwarded reply from %s to %s (%v)", from, s.fallbackJID, routeErr)
+}
+
+// unroutableNotice is what the fallback admin receives for a lost reply
+func unroutableNotice(from, to, body string, attachments []string) string {
+	notice := fmt.Sprintf("[Undeliverable reply from %s to %s] %s", from, to, body)
+	if len(attachments) > 0 {
+		notice += "\n" + strings.Join(attachments, "\n")
+	}
+	return notice
+}
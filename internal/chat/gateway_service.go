@@ -3,61 +3,62 @@ package chat
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os"
 	"strings"
 	"time"
 
+	"github.com/ngenohkevin/veilsupport/internal/config"
 	"github.com/ngenohkevin/veilsupport/internal/db"
+	"github.com/ngenohkevin/veilsupport/internal/sanitize"
+	"github.com/ngenohkevin/veilsupport/internal/storage"
+	"github.com/ngenohkevin/veilsupport/internal/webhook"
 	"github.com/ngenohkevin/veilsupport/internal/ws"
 	"github.com/ngenohkevin/veilsupport/internal/xmpp"
 )
 
 // GatewayService handles chat using the XMPP gateway approach
 type GatewayService struct {
-	db      *db.DB
-	gateway *xmpp.GatewayClient
-	ws      *ws.Manager
+	db           *db.DB
+	gateway      *xmpp.GatewayClient
+	ws           *ws.Manager
+	sanitizeMode sanitize.Mode
+	normalize    bool
+	replyWebhook *webhook.Client // nil when ADMIN_REPLY_WEBHOOK_URL is unset
+	uploads      storage.Backend
+	fallbackJID  string // Receives replies for unknown users, from XMPP_FALLBACK_ADMIN_JID
 }
 
 // NewGatewayService creates a new gateway-based chat service
-func NewGatewayService(database *db.DB, wsManager *ws.Manager) *GatewayService {
-	// Get admin JIDs from environment
-	adminJIDsStr := os.Getenv("XMPP_ADMIN_JIDS")
-	if adminJIDsStr == "" {
-		adminJIDsStr = os.Getenv("XMPP_ADMIN_JID") // Fallback to single admin
-	}
-	
-	// Parse multiple admin JIDs
-	adminJIDs := strings.Split(adminJIDsStr, ",")
-	for i := range adminJIDs {
-		adminJIDs[i] = strings.TrimSpace(adminJIDs[i])
-	}
-	
-	// Get bot credentials
-	botJID := os.Getenv("XMPP_BOT_JID")
-	if botJID == "" {
-		// Fallback to connection JID
-		botJID = os.Getenv("XMPP_CONNECTION_JID")
-	}
-	
-	botPassword := os.Getenv("XMPP_BOT_PASSWORD")
-	if botPassword == "" {
-		// Fallback to connection password
-		botPassword = os.Getenv("XMPP_CONNECTION_PASSWORD")
-	}
-	
-	xmppServer := os.Getenv("XMPP_SERVER")
-	
+func NewGatewayService(database *db.DB, wsManager *ws.Manager, cfg *config.Config) *GatewayService {
 	// Create gateway client
-	gateway := xmpp.NewGatewayClient(botJID, botPassword, xmppServer, adminJIDs)
+	gateway := xmpp.NewGatewayClient(cfg.BotJID, cfg.BotPassword, cfg.XMPPServer, cfg.XMPPAdminJIDs)
+	gateway.SetTLSOptions(cfg.XMPPTLS)
+	gateway.SetReplyConfirmations(cfg.GatewayReplyConfirmations)
+	gateway.SetPresenceGrace(cfg.GatewayPresenceGrace)
+	gateway.SetThreads(cfg.GatewayThreads)
 	
-	return &GatewayService{
-		db:      database,
-		gateway: gateway,
-		ws:      wsManager,
+	service := &GatewayService{
+		db:           database,
+		gateway:      gateway,
+		ws:           wsManager,
+		sanitizeMode: cfg.ContentSanitize,
+		normalize:    cfg.ContentNormalize,
+		uploads:      storage.NewLocal(cfg.UploadDir, "/uploads/"),
+		fallbackJID:  cfg.XMPPFallbackAdminJID,
 	}
+	if cfg.AdminReplyWebhookURL != "" {
+		service.replyWebhook = webhook.New(cfg.AdminReplyWebhookURL)
+		service.replyWebhook.SetRetries(cfg.AdminReplyWebhookAttempts, time.Second)
+	}
+	return service
+}
+
+// SetSession attaches an established gateway session. Useful for tests that
+// substitute a fake session.
+func (s *GatewayService) SetSession(session xmpp.Session) {
+	s.gateway.SetSession(session)
 }
 
 // Connect initializes the gateway connection
@@ -103,8 +104,16 @@ func (s *GatewayService) SendMessage(userID int, content string, attachments []s
 		log.Printf("Gateway: Failed to register user %d: %v", userID, err)
 	}
 	
+	content = sanitize.Content(s.sanitizeMode, content)
+	if s.normalize {
+		content = sanitize.Normalize(content)
+	}
+	if strings.TrimSpace(content) == "" && len(attachments) == 0 {
+		return ErrEmptyMessage
+	}
+	
 	// Save to database first
-	_, err = s.db.SaveMessage(userID, content, "user")
+	_, err = s.db.SaveMessage(userID, content, db.SenderUser)
 	if err != nil {
 		return fmt.Errorf("failed to save message: %w", err)
 	}
@@ -132,41 +141,81 @@ func (s *GatewayService) SendMessage(userID int, content string, attachments []s
 
 // HandleAdminReply processes a reply from admin through the gateway
 func (s *GatewayService) HandleAdminReply(from, body string) error {
+	gwMsg, err := s.routeAdminReply(from, body)
+	if errors.Is(err, xmpp.ErrNotAdmin) {
+		// Strangers get no confirmation
+		return err
+	}
+	if errors.Is(err, xmpp.ErrUnknownRecipient) || errors.Is(err, xmpp.ErrUserNotFound) {
+		s.forwardUnroutable(from, body, err)
+	}
+	
+	// Let the admin know whether routing worked (when enabled)
+	userID := 0
+	if gwMsg != nil {
+		userID = gwMsg.UserID
+	}
+	if confirmErr := s.gateway.ConfirmReply(from, userID, err); confirmErr != nil {
+		log.Printf("Gateway: Failed to confirm reply to %s: %v", from, confirmErr)
+	}
+	
+	return err
+}
+
+// routeAdminReply stores an admin reply and delivers it to the target user
+func (s *GatewayService) routeAdminReply(from, body string) (*xmpp.GatewayMessage, error) {
 	// Let gateway parse the message and determine target user
 	gwMsg, err := s.gateway.HandleAdminReply(from, body)
 	if err != nil {
-		return fmt.Errorf("failed to handle admin reply: %w", err)
+		return nil, fmt.Errorf("failed to handle admin reply: %w", err)
 	}
 	
 	// Save to database
-	_, err = s.db.SaveMessage(gwMsg.UserID, gwMsg.Body, "admin")
+	msg, err := s.db.SaveMessage(gwMsg.UserID, gwMsg.Body, db.SenderAdmin)
 	if err != nil {
-		return fmt.Errorf("failed to save admin message: %w", err)
+		return gwMsg, fmt.Errorf("failed to save admin message: %w", err)
+	}
+	
+	attachments := make([]db.Attachment, 0, len(gwMsg.Attachments))
+	for _, url := range gwMsg.Attachments {
+		attachment, err := s.db.SaveAttachment(msg.ID, url)
+		if err != nil {
+			return gwMsg, fmt.Errorf("failed to save attachment: %w", err)
+		}
+		attachments = append(attachments, *attachment)
 	}
 	
+	notifyReplyWebhook(s.replyWebhook, gwMsg.UserID, gwMsg.UserEmail, msg, gwMsg.Attachments)
+	
 	// Send via WebSocket to user if connected
 	if s.ws != nil {
 		wsMsg := map[string]interface{}{
-			"type":      "message",
-			"content":   gwMsg.Body,
-			"from":      "admin",
-			"timestamp": gwMsg.Timestamp,
+			"type":       "message",
+			"id":         msg.ID,
+			"message_id": msg.ID,
+			"session_id": msg.SessionID,
+			"seq":        msg.Seq,
+			"content":    gwMsg.Body,
+			"from":       db.SenderAdmin,
+			"created_at": msg.CreatedAt,
+			"timestamp":  gwMsg.Timestamp,
 		}
 		
-		if len(gwMsg.Attachments) > 0 {
-			wsMsg["attachments"] = gwMsg.Attachments
+		// Same schema as ChatService replies: the saved attachment rows
+		if len(attachments) > 0 {
+			wsMsg["attachments"] = attachments
 		}
 		
 		data, err := json.Marshal(wsMsg)
 		if err != nil {
-			return fmt.Errorf("failed to marshal WebSocket message: %w", err)
+			return gwMsg, fmt.Errorf("failed to marshal WebSocket message: %w", err)
 		}
 		
 		s.ws.SendToUser(gwMsg.UserID, data)
 		log.Printf("Gateway: Admin reply sent to user %s via WebSocket", gwMsg.UserEmail)
 	}
 	
-	return nil
+	return gwMsg, nil
 }
 
 // SetUserOnline updates user's online status
@@ -179,32 +228,14 @@ func (s *GatewayService) SetUserOnline(userID int, online bool) error {
 
 // UploadFile handles file uploads from web users
 func (s *GatewayService) UploadFile(userID int, filename string, data []byte) (string, error) {
-	// In production, you'd store this in S3 or similar
-	// For now, store locally
-	uploadDir := os.Getenv("UPLOAD_DIR")
-	if uploadDir == "" {
-		uploadDir = "/tmp/veilsupport/uploads"
-	}
-	
-	// Create upload directory if it doesn't exist
-	err := os.MkdirAll(uploadDir, 0755)
-	if err != nil {
-		return "", fmt.Errorf("failed to create upload directory: %w", err)
-	}
-	
 	// Generate unique filename
 	uniqueFilename := fmt.Sprintf("%d_%d_%s", userID, time.Now().Unix(), filename)
-	filepath := fmt.Sprintf("%s/%s", uploadDir, uniqueFilename)
 	
-	// Write file
-	err = os.WriteFile(filepath, data, 0644)
+	url, err := s.uploads.Save(uniqueFilename, data)
 	if err != nil {
-		return "", fmt.Errorf("failed to write file: %w", err)
+		return "", err
 	}
 	
-	// Return URL (in production, this would be a public URL)
-	url := fmt.Sprintf("/uploads/%s", uniqueFilename)
-	
 	log.Printf("Gateway: File uploaded for user %d: %s", userID, url)
 	return url, nil
 }
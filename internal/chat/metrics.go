@@ -0,0 +1,88 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ngenohkevin/veilsupport/internal/db"
+)
+
+// Metrics is a point-in-time snapshot of service health for the admin dashboard
+type Metrics struct {
+	Connections       int       `json:"connections"`
+	AdminConnections  int       `json:"admin_connections"`
+	ReapedConnections int       `json:"reaped_connections"` // Idle connections closed since startup
+	QueueDepth        int       `json:"queue_depth"`        // User messages waiting to be bridged
+	XMPPConnected     bool      `json:"xmpp_connected"`
+	MessagesPerMinute int       `json:"messages_per_minute"`
+	Timestamp         time.Time `json:"timestamp"`
+}
+
+// Metrics collects a snapshot of the current service state
+func (s *ChatService) Metrics() (*Metrics, error) {
+	metrics := &Metrics{
+		XMPPConnected: s.xmpp != nil && s.xmpp.IsConnected(),
+		Timestamp:     time.Now(),
+	}
+
+	if s.ws != nil {
+		metrics.Connections = s.ws.GetClientCount()
+		metrics.AdminConnections = s.ws.GetAdminCount()
+		metrics.ReapedConnections = s.ws.GetReapedCount()
+	}
+
+	queueDepth, err := s.db.CountMessagesByStatus(db.StatusPending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get queue depth: %w", err)
+	}
+	metrics.QueueDepth = queueDepth
+
+	rate, err := s.db.CountMessagesSince(metrics.Timestamp.Add(-time.Minute))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message rate: %w", err)
+	}
+	metrics.MessagesPerMinute = rate
+
+	return metrics, nil
+}
+
+// StartMetricsBroadcast pushes a metrics snapshot to connected admin dashboards every interval
+func (s *ChatService) StartMetricsBroadcast(ctx context.Context, interval time.Duration) {
+	if s.ws == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// Skip the database work when nobody is watching
+			if s.ws.GetAdminCount() == 0 {
+				continue
+			}
+
+			metrics, err := s.Metrics()
+			if err != nil {
+				log.Printf("Failed to collect metrics: %v", err)
+				continue
+			}
+
+			data, err := json.Marshal(map[string]interface{}{
+				"type":    "metrics",
+				"metrics": metrics,
+			})
+			if err != nil {
+				log.Printf("Failed to marshal metrics: %v", err)
+				continue
+			}
+			s.ws.BroadcastToAdmins(data)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
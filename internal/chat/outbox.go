@@ -0,0 +1,60 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/ngenohkevin/veilsupport/internal/db"
+)
+
+// drainBatchSize bounds how many pending messages are loaded per query while draining
+const drainBatchSize = 100
+
+// DrainOutbox makes a final attempt to bridge every pending user message,
+// for use during shutdown. It stops when ctx is done or the bridge is
+// unavailable, and returns how many messages were attempted.
+func (s *ChatService) DrainOutbox(ctx context.Context) (int, error) {
+	// Send anything waiting for a batching window first so it isn't sent twice
+	s.FlushNotifications()
+
+	users := make(map[int]*db.User)
+	attempted := 0
+	for {
+		pending, err := s.db.GetMessagesByStatus(db.StatusPending, drainBatchSize)
+		if err != nil {
+			return attempted, fmt.Errorf("failed to get pending messages: %w", err)
+		}
+		if len(pending) == 0 {
+			return attempted, nil
+		}
+
+		for i := range pending {
+			if err := ctx.Err(); err != nil {
+				return attempted, fmt.Errorf("outbox drain stopped after %d message(s): %w", attempted, err)
+			}
+
+			msg := &pending[i]
+			user, ok := users[msg.UserID]
+			if !ok {
+				user, err = s.db.GetUserByID(msg.UserID)
+				if err != nil {
+					return attempted, fmt.Errorf("failed to get user: %w", err)
+				}
+				users[msg.UserID] = user
+			}
+			if user == nil {
+				// Nothing to attribute the message to; don't pick it up again
+				s.setMessageStatus(msg, db.StatusFailed)
+				continue
+			}
+
+			// Unavailable means every remaining message would fail the same way
+			if err := s.bridgeMessage(ctx, user, msg); err != nil {
+				return attempted, err
+			}
+			attempted++
+		}
+		log.Printf("Outbox drain: %d message(s) attempted so far", attempted)
+	}
+}
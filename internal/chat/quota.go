@@ -0,0 +1,145 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ngenohkevin/veilsupport/internal/db"
+)
+
+// OrgMetadataKey is the user metadata key naming the user's organization.
+// Metadata is only set by admins and trusted integrations, so users can't
+// choose their organization and spend someone else's quota.
+const OrgMetadataKey = "org"
+
+// maxOrgNameLen is the longest organization name usage is counted under,
+// matching org_usage.org
+const maxOrgNameLen = 255
+
+// DefaultQuotaPeriod is how long an organization's message quota lasts
+// unless configured otherwise
+const DefaultQuotaPeriod = 30 * 24 * time.Hour
+
+// rateWindow is the span an organization's rate limit applies to
+const rateWindow = time.Minute
+
+var (
+	// ErrQuotaExceeded is returned when the user's organization has used up
+	// its messages for the current period
+	ErrQuotaExceeded = errors.New("organization message quota exceeded")
+	// ErrRateLimited is returned when the user's organization is sending
+	// messages faster than its rate limit allows
+	ErrRateLimited = errors.New("organization is sending messages too fast")
+)
+
+// orgLimits caps the messages each organization sends. Usage against the
+// quota is kept in the database; the rate limit is counted in memory.
+type orgLimits struct {
+	quota  int           // Messages per period; 0 means unlimited
+	period time.Duration // How long a quota lasts
+	rate   int           // Messages per minute; 0 means unlimited
+
+	mu      sync.Mutex
+	windows map[string]*orgWindow
+	swept   time.Time // When expired windows were last removed
+}
+
+// orgWindow counts an organization's messages in the current rate window
+type orgWindow struct {
+	start time.Time
+	count int
+}
+
+// SetOrgLimits limits the messages sent by users of each organization, as
+// named by the "org" key of their metadata, to quota per period and rate
+// per minute. Users without an organization aren't limited. 0 disables
+// either limit.
+func (s *ChatService) SetOrgLimits(quota int, period time.Duration, rate int) {
+	s.limits.mu.Lock()
+	defer s.limits.mu.Unlock()
+
+	if period <= 0 {
+		period = DefaultQuotaPeriod
+	}
+	s.limits.quota = quota
+	s.limits.period = period
+	s.limits.rate = rate
+	s.limits.windows = make(map[string]*orgWindow)
+}
+
+// checkOrgLimits counts a message from the user against their
+// organization's limits, returning ErrRateLimited or ErrQuotaExceeded if it
+// may not be sent
+func (s *ChatService) checkOrgLimits(user *db.User) error {
+	org := orgName(user)
+	if org == "" {
+		return nil
+	}
+
+	s.limits.mu.Lock()
+	quota, period := s.limits.quota, s.limits.period
+	allowed := s.limits.allowRate(org, time.Now())
+	s.limits.mu.Unlock()
+	if !allowed {
+		return ErrRateLimited
+	}
+
+	if quota <= 0 {
+		return nil
+	}
+	allowed, err := s.db.UseOrgQuota(org, quota, period)
+	if err != nil {
+		return fmt.Errorf("failed to check org quota: %w", err)
+	}
+	if !allowed {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// orgName returns the user's organization, or "" if they have none. Metadata
+// values are capped well below the column size, but older rows may not be,
+// so long names are cut rather than failing every message.
+func orgName(user *db.User) string {
+	org, _ := user.Metadata[OrgMetadataKey].(string)
+	if runes := []rune(org); len(runes) > maxOrgNameLen {
+		org = string(runes[:maxOrgNameLen])
+	}
+	return org
+}
+
+// allowRate counts a message in the organization's current rate window and
+// reports whether it fits. The caller holds mu.
+func (l *orgLimits) allowRate(org string, now time.Time) bool {
+	if l.rate <= 0 {
+		return true
+	}
+
+	l.sweep(now)
+	window := l.windows[org]
+	if window == nil || now.Sub(window.start) >= rateWindow {
+		window = &orgWindow{start: now}
+		l.windows[org] = window
+	}
+	if window.count >= l.rate {
+		return false
+	}
+	window.count++
+	return true
+}
+
+// sweep removes expired windows, at most once per rate window, so orgs that
+// stop sending don't stay in memory. The caller holds mu.
+func (l *orgLimits) sweep(now time.Time) {
+	if now.Sub(l.swept) < rateWindow {
+		return
+	}
+	for org, window := range l.windows {
+		if now.Sub(window.start) >= rateWindow {
+			delete(l.windows, org)
+		}
+	}
+	l.swept = now
+}
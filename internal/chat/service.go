@@ -3,30 +3,146 @@ package chat
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"github.com/ngenohkevin/veilsupport/internal/db"
+	"github.com/ngenohkevin/veilsupport/internal/i18n"
+	"github.com/ngenohkevin/veilsupport/internal/redact"
+	"github.com/ngenohkevin/veilsupport/internal/sanitize"
+	"github.com/ngenohkevin/veilsupport/internal/storage"
+	"github.com/ngenohkevin/veilsupport/internal/tracing"
+	"github.com/ngenohkevin/veilsupport/internal/webhook"
 	"github.com/ngenohkevin/veilsupport/internal/ws"
 	"github.com/ngenohkevin/veilsupport/internal/xmpp"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// XMPPSender is the part of the XMPP client used by ChatService.
+// *xmpp.XMPPClient satisfies it; tests can substitute a fake.
+type XMPPSender interface {
+	IsConnected() bool
+	SendMessage(to, body string) error
+	SendMessageSimple(to, body string) error
+	Listen(ctx context.Context, messages chan<- xmpp.XMPPMessage, errorChan chan<- error) error
+}
+
+var (
+	// ErrEmptyMessage is returned when nothing is left of a message after sanitizing
+	ErrEmptyMessage = errors.New("message is empty")
+	// ErrMessageNotFound is returned when a message doesn't exist or belongs to someone else
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrNotResendable is returned when resending a message that hasn't failed
+	ErrNotResendable = errors.New("only failed messages can be resent")
+	// ErrBridgeUnavailable is returned when messages can't be forwarded to XMPP right now
+	ErrBridgeUnavailable = errors.New("message bridge unavailable")
+	// ErrInvalidSenderType is returned when filtering by an unknown sender type
+	ErrInvalidSenderType = errors.New("invalid sender type")
 )
 
 type ChatService struct {
-	db   *db.DB
-	xmpp *xmpp.XMPPClient
-	ws   *ws.Manager
+	db           *db.DB
+	xmpp         XMPPSender
+	ws           *ws.Manager
+	catalog      *i18n.Catalog
+	sanitizeMode sanitize.Mode
+	normalize    bool // Tidy whitespace in user messages
+	sendAcks     bool
+	welcome      bool
+	batch        notificationBatch
+	limits       orgLimits
+	replyWebhook *webhook.Client
+	surveyURL    string        // Template with {session_id}; empty disables surveys
+	maxSessions  int           // Active sessions per user; 0 means unlimited
+	starting     sync.Mutex    // Serializes StartSession so the cap holds
+	reconnected  chan struct{} // Signals StartXMPPListener to reattach to a new session
+	listening    sync.Once     // Starts the listener on the first XMPP connection
+	adminJID     string        // Where user messages are bridged to
+	fallbackJID  string        // Where replies that match no user are forwarded
+	admins       xmpp.Allowlist
+	storage      storage.Backend // Where attachment files live, for expiry
+	uploader     FileUploader    // Shares user files with agents; nil disables it
 }
 
-func NewChatService(database *db.DB, xmppClient *xmpp.XMPPClient, wsManager *ws.Manager) *ChatService {
+func NewChatService(database *db.DB, xmppClient XMPPSender, wsManager *ws.Manager) *ChatService {
 	return &ChatService{
-		db:   database,
-		xmpp: xmppClient,
-		ws:   wsManager,
+		db:           database,
+		xmpp:         xmppClient,
+		ws:           wsManager,
+		catalog:      i18n.NewCatalog("en"),
+		sanitizeMode: sanitize.ModeOff,
+		reconnected:  make(chan struct{}, 1),
 	}
 }
 
-func (s *ChatService) SendMessage(userID int, content string) error {
+// SetSanitizeMode controls how HTML in user messages is neutralized before storage
+func (s *ChatService) SetSanitizeMode(mode sanitize.Mode) {
+	s.sanitizeMode = mode
+}
+
+// SetNormalize enables tidying whitespace in user messages before they are
+// stored or bridged; see sanitize.Normalize
+func (s *ChatService) SetNormalize(enabled bool) {
+	s.normalize = enabled
+}
+
+// SetReplyWebhook makes the service POST every routed admin reply to the
+// given webhook. nil disables it.
+func (s *ChatService) SetReplyWebhook(client *webhook.Client) {
+	s.replyWebhook = client
+}
+
+// SetAdminJID sets the XMPP account user messages are bridged to. Without
+// one, messages are only saved.
+func (s *ChatService) SetAdminJID(jid string) {
+	s.adminJID = jid
+}
+
+// SetAdminAllowlist restricts who may reply to users to the given JIDs
+// (compared as bare JIDs), plus whoever a conversation was transferred to.
+// Replies from anyone else are dropped. Until it is set, nobody but
+// transferred-to agents may reply.
+func (s *ChatService) SetAdminAllowlist(jids []string) {
+	s.admins = xmpp.NewAllowlist(jids)
+}
+
+// SetCatalog replaces the message catalog used for system messages
+func (s *ChatService) SetCatalog(catalog *i18n.Catalog) {
+	s.catalog = catalog
+}
+
+// SetWelcomeMessages enables a localized welcome message at the start of each new session
+func (s *ChatService) SetWelcomeMessages(enabled bool) {
+	s.welcome = enabled
+}
+
+// SetSendAcks enables pushing "ack"/"failed" events to the sender's WebSocket
+// as their messages are bridged to XMPP
+func (s *ChatService) SetSendAcks(enabled bool) {
+	s.sendAcks = enabled
+}
+
+func (s *ChatService) SendMessage(ctx context.Context, userID int, content string) error {
+	return s.SendExpiringMessage(ctx, userID, content, 0)
+}
+
+// SendExpiringMessage sends a message that the purge job deletes once ttl
+// has passed; see PurgeExpiredMessages. A ttl of 0 keeps it.
+func (s *ChatService) SendExpiringMessage(ctx context.Context, userID int, content string, ttl time.Duration) error {
+	ctx, span := tracing.Start(ctx, "chat.SendMessage", attribute.Int("user.id", userID))
+	err := s.sendMessage(ctx, userID, content, ttl)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *ChatService) sendMessage(ctx context.Context, userID int, content string, ttl time.Duration) error {
 	// Get user
 	user, err := s.db.GetUserByID(userID)
 	if err != nil {
@@ -36,42 +152,318 @@ func (s *ChatService) SendMessage(userID int, content string) error {
 		return fmt.Errorf("user not found")
 	}
 	
+	// Neutralize markup before it reaches the database or the agent
+	content = s.cleanContent(content)
+	if strings.TrimSpace(content) == "" {
+		return ErrEmptyMessage
+	}
+	
+	if err := s.checkOrgLimits(user); err != nil {
+		return err
+	}
+	
+	// A message without an active session opens a new one
+	newSession := false
+	if s.welcome {
+		active, err := s.db.GetActiveSession(userID)
+		if err != nil {
+			return fmt.Errorf("failed to get active session: %w", err)
+		}
+		newSession = active == nil
+	}
+	
 	// Save to database first (always save even if XMPP fails)
-	_, err = s.db.SaveMessage(userID, content, "user")
+	_, dbSpan := tracing.Start(ctx, "db.SaveMessage")
+	msg, err := s.db.SaveExpiringMessage(userID, content, db.SenderUser, ttl)
+	tracing.End(dbSpan, err)
 	if err != nil {
 		return fmt.Errorf("failed to save message: %w", err)
 	}
 	
-	// Try to send via XMPP if connected
-	if s.xmpp != nil && s.xmpp.IsConnected() {
-		adminJID := os.Getenv("XMPP_ADMIN_JID")
-		if adminJID == "" {
-			log.Println("XMPP_ADMIN_JID not configured")
-			return nil // Don't fail the whole operation
-		}
-		
-		// Format message with user email for context
-		message := fmt.Sprintf("[User: %s] %s", user.Email, content)
-		
-		// Try to send via XMPP
-		err = s.xmpp.SendMessage(adminJID, message)
-		if err != nil {
-			// Try the simple send method as fallback
-			log.Printf("Regular XMPP send failed: %v, trying simple method...", err)
-			err = s.xmpp.SendMessageSimple(adminJID, message)
-			if err != nil {
-				log.Printf("XMPP send failed (both methods): %v", err)
-				// Don't return error - message is saved in DB
-			} else {
-				log.Printf("XMPP message sent via simple method to %s", adminJID)
-			}
-		} else {
-			log.Printf("XMPP message sent to %s", adminJID)
+	if newSession {
+		if _, err := s.SendSystemMessage(userID, i18n.KeyWelcome); err != nil {
+			log.Printf("Failed to send welcome message to user %d: %v", userID, err)
 		}
+	}
+	
+	// Show the full message on admin dashboards even when XMPP only gets a digest
+	s.broadcastNewMessage(user, msg)
+	
+	// Try to send via XMPP if connected
+	if err := s.notifyAdmin(ctx, user, msg); err != nil {
+		log.Printf("%v - message saved to database only", err)
+	}
+	
+	return nil
+}
+
+// cleanContent neutralizes markup in a user message and, when enabled,
+// tidies its whitespace
+func (s *ChatService) cleanContent(content string) string {
+	content = sanitize.Content(s.sanitizeMode, content)
+	if s.normalize {
+		content = sanitize.Normalize(content)
+	}
+	return content
+}
+
+// broadcastNewMessage pushes a new user message to the admin dashboard feed
+func (s *ChatService) broadcastNewMessage(user *db.User, msg *db.Message) {
+	if s.ws == nil {
+		return
+	}
+	
+	data, err := json.Marshal(map[string]interface{}{
+		"type":          "new_message",
+		"user_email":    user.Email,
+		"user_metadata": user.Metadata,
+		"message":       msg,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal dashboard message: %v", err)
+		return
+	}
+	s.ws.BroadcastToAdmins(data)
+}
+
+// bridgeMessage forwards a stored user message to the admin over XMPP and
+// records the outcome in its status. It returns ErrBridgeUnavailable without
+// touching the status when there's nowhere to send it.
+func (s *ChatService) bridgeMessage(ctx context.Context, user *db.User, msg *db.Message) error {
+	adminJID, err := s.adminTarget()
+	if err != nil {
+		return err
+	}
+	
+	// Format message with user email and metadata for context
+	message := fmt.Sprintf("%s %s", userHeader(user), msg.Content)
+	
+	if err := s.sendToAdmin(ctx, adminJID, message); err != nil {
+		log.Printf("XMPP send failed (both methods): %v", err)
+		// Don't return error - message is saved in DB
+		s.setMessageStatus(msg, db.StatusFailed)
 	} else {
-		log.Println("XMPP not connected - message saved to database only")
+		s.setMessageStatus(msg, db.StatusSent)
+	}
+	
+	return nil
+}
+
+// userHeader identifies the sender of a bridged message, e.g.
+// "[User: jane@example.com | plan=pro, source=ads]". VIPs are prefixed with
+// "⭐ VIP" so agents can pick them out.
+func userHeader(user *db.User) string {
+	prefix := ""
+	if user.VIP {
+		prefix = "⭐ VIP "
+	}
+	if len(user.Metadata) == 0 {
+		return fmt.Sprintf("%s[User: %s]", prefix, user.Email)
+	}
+	
+	keys := make([]string, 0, len(user.Metadata))
+	for key := range user.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	
+	fields := make([]string, 0, len(keys))
+	for _, key := range keys {
+		fields = append(fields, headerText(key)+"="+headerText(fmt.Sprint(user.Metadata[key])))
+	}
+	return fmt.Sprintf("%s[User: %s | %s]", prefix, user.Email, strings.Join(fields, ", "))
+}
+
+// maxHeaderText caps each metadata key and value in the header
+const maxHeaderText = 64
+
+// headerText makes a metadata key or value safe to put in the header. Text
+// that could break out of its field, such as brackets, separators or line
+// breaks, is quoted, and long text is shortened.
+func headerText(text string) string {
+	if runes := []rune(text); len(runes) > maxHeaderText {
+		text = string(runes[:maxHeaderText-1]) + "…"
+	}
+	if text == "" || strings.ContainsAny(text, "[]|,=\"\\") || strings.ContainsFunc(text, unicode.IsControl) {
+		return strconv.Quote(text)
+	}
+	return text
+}
+
+// adminTarget returns the admin JID to notify, or ErrBridgeUnavailable when
+// there's nowhere to send to
+func (s *ChatService) adminTarget() (string, error) {
+	if s.xmpp == nil || !s.xmpp.IsConnected() {
+		return "", fmt.Errorf("%w: XMPP not connected", ErrBridgeUnavailable)
 	}
 	
+	if s.adminJID == "" {
+		return "", fmt.Errorf("%w: XMPP_ADMIN_JID not configured", ErrBridgeUnavailable)
+	}
+	return s.adminJID, nil
+}
+
+// sendToAdmin sends a stanza body to the admin, falling back to the simple
+// send method if the regular one fails
+func (s *ChatService) sendToAdmin(ctx context.Context, adminJID, message string) (err error) {
+	_, span := tracing.Start(ctx, "xmpp.SendMessage", attribute.String("xmpp.to", adminJID))
+	defer func() { tracing.End(span, err) }()
+	
+	err = s.xmpp.SendMessage(adminJID, message)
+	if err == nil {
+		log.Printf("XMPP message sent to %s", adminJID)
+		return nil
+	}
+	
+	log.Printf("Regular XMPP send failed: %v, trying simple method...", err)
+	if err := s.xmpp.SendMessageSimple(adminJID, message); err != nil {
+		return err
+	}
+	log.Printf("XMPP message sent via simple method to %s", adminJID)
+	return nil
+}
+
+// ResendMessage retries bridging one of the user's failed messages and
+// returns it with its updated status. The message is claimed first, so
+// concurrent resends send it once; if the retry fails it is marked failed
+// again and ErrBridgeUnavailable is returned.
+func (s *ChatService) ResendMessage(userID, messageID int) (*db.Message, error) {
+	msg, err := s.db.GetMessageByID(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+	// Other users' messages are indistinguishable from missing ones
+	if msg == nil || msg.UserID != userID || msg.SenderType != db.SenderUser {
+		return nil, ErrMessageNotFound
+	}
+	if msg.Status != db.StatusFailed {
+		return nil, ErrNotResendable
+	}
+	
+	user, err := s.db.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	
+	claimed, err := s.db.ClaimFailedMessage(msg.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrNotResendable
+	}
+	msg.Status = db.StatusPending
+	
+	if err := s.bridgeMessage(context.Background(), user, msg); err != nil {
+		// Nothing was sent, so it stays failed
+		if statusErr := s.db.UpdateMessageStatus(msg.ID, db.StatusFailed); statusErr != nil {
+			log.Printf("Failed to update status of message %d: %v", msg.ID, statusErr)
+		}
+		return nil, err
+	}
+	if msg.Status == db.StatusFailed {
+		return nil, fmt.Errorf("%w: resend failed", ErrBridgeUnavailable)
+	}
+	
+	return msg, nil
+}
+
+// setMessageStatus records a status transition and, when acks are enabled,
+// tells the sender's WebSocket about it
+func (s *ChatService) setMessageStatus(msg *db.Message, status string) {
+	if err := s.db.UpdateMessageStatus(msg.ID, status); err != nil {
+		log.Printf("Failed to update status of message %d: %v", msg.ID, err)
+		return
+	}
+	msg.Status = status
+	
+	if !s.sendAcks || s.ws == nil {
+		return
+	}
+	
+	event := map[string]interface{}{
+		"message_id": msg.ID,
+		"seq":        msg.Seq,
+		"status":     status,
+	}
+	switch status {
+	case db.StatusSent, db.StatusDelivered:
+		event["type"] = "ack"
+	case db.StatusFailed:
+		event["type"] = "failed"
+	default:
+		return
+	}
+	
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal status event: %v", err)
+		return
+	}
+	s.ws.SendToUser(msg.UserID, data)
+}
+
+// MarkDelivered records a delivery receipt from the user's WebSocket for a
+// message sent to them. Receipts for messages the user sent, or that belong
+// to someone else, are rejected with ErrMessageNotFound.
+func (s *ChatService) MarkDelivered(userID, messageID int) error {
+	msg, err := s.db.GetMessageByID(messageID)
+	if err != nil {
+		return fmt.Errorf("failed to get message: %w", err)
+	}
+	if msg == nil || msg.UserID != userID || msg.SenderType == db.SenderUser {
+		return ErrMessageNotFound
+	}
+	if msg.Status == db.StatusDelivered {
+		return nil
+	}
+	
+	return s.db.UpdateMessageStatus(msg.ID, db.StatusDelivered)
+}
+
+// SendSystemMessage renders a catalog message in the user's locale, stores it
+// in their history and pushes it to their WebSocket
+func (s *ChatService) SendSystemMessage(userID int, key string, args ...interface{}) (*db.Message, error) {
+	user, err := s.db.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	
+	content := s.catalog.Render(user.Locale, key, args...)
+	
+	msg, err := s.db.SaveMessage(userID, content, db.SenderSystem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save system message: %w", err)
+	}
+	
+	if err := s.pushSystemMessage(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// pushSystemMessage sends a stored system message to the user's WebSocket
+func (s *ChatService) pushSystemMessage(msg *db.Message) error {
+	if s.ws == nil {
+		return nil
+	}
+	
+	data, err := json.Marshal(map[string]interface{}{
+		"type":       "system",
+		"message_id": msg.ID,
+		"seq":        msg.Seq,
+		"content":    msg.Content,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal WebSocket message: %w", err)
+	}
+	s.ws.SendToUser(msg.UserID, data)
 	return nil
 }
 
@@ -85,21 +477,47 @@ func (s *ChatService) HandleAdminReply(xmppMsg xmpp.XMPPMessage) error {
 		return fmt.Errorf("failed to find user by JID: %w", err)
 	}
 	if user == nil {
-		return fmt.Errorf("user not found for JID: %s", userJID)
+		s.forwardUnroutable(xmppMsg)
+		return fmt.Errorf("%w: no user has JID %s", xmpp.ErrUnknownRecipient, userJID)
+	}
+	
+	allowed, err := s.mayReply(user.ID, xmppMsg.From)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		log.Printf("Dropping reply to %s from %s, not an admin", user.Email, xmppMsg.From)
+		return fmt.Errorf("%w: %s", xmpp.ErrNotAdmin, xmppMsg.From)
 	}
 	
 	// Save to database
-	_, err = s.db.SaveMessage(user.ID, xmppMsg.Body, "admin")
+	msg, err := s.db.SaveMessage(user.ID, xmppMsg.Body, db.SenderAdmin)
 	if err != nil {
 		return fmt.Errorf("failed to save admin message: %w", err)
 	}
 	
-	// Send via WebSocket if user is connected
+	attachments, err := s.saveAttachments(msg.ID, xmppMsg.Attachments)
+	if err != nil {
+		return err
+	}
+	
+	notifyReplyWebhook(s.replyWebhook, user.ID, user.Email, msg, xmppMsg.Attachments)
+	
+	// Send via WebSocket if user is connected. id, session_id and seq match
+	// the history row, so clients can dedupe replies they also fetch.
 	if s.ws != nil {
-		wsMsg := map[string]string{
-			"type":    "message",
-			"content": xmppMsg.Body,
-			"from":    "admin",
+		wsMsg := map[string]interface{}{
+			"type":       "message",
+			"id":         msg.ID,
+			"message_id": msg.ID,
+			"session_id": msg.SessionID,
+			"seq":        msg.Seq,
+			"content":    xmppMsg.Body,
+			"from":       db.SenderAdmin,
+			"created_at": msg.CreatedAt,
+		}
+		if len(attachments) > 0 {
+			wsMsg["attachments"] = attachments
 		}
 		
 		data, err := json.Marshal(wsMsg)
@@ -114,6 +532,63 @@ func (s *ChatService) HandleAdminReply(xmppMsg xmpp.XMPPMessage) error {
 	return nil
 }
 
+// mayReply reports whether from is an allowed admin or the agent the user's
+// conversation is assigned to
+func (s *ChatService) mayReply(userID int, from string) (bool, error) {
+	if s.admins.Allows(from) {
+		return true, nil
+	}
+	
+	session, err := s.db.GetActiveSession(userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get active session: %w", err)
+	}
+	return session != nil && session.AssignedTo != "" && xmpp.BareJID(session.AssignedTo) == xmpp.BareJID(from), nil
+}
+
+// AdminReplyEvent is the webhook payload for an admin reply routed to a user
+type AdminReplyEvent struct {
+	UserID      int         `json:"user_id"`
+	UserEmail   string      `json:"user_email"`
+	Message     *db.Message `json:"message"`
+	Attachments []string    `json:"attachments"`
+}
+
+// notifyReplyWebhook reports an admin reply to the webhook in the background
+// so a slow or failing endpoint never holds up delivery
+func notifyReplyWebhook(client *webhook.Client, userID int, email string, msg *db.Message, attachments []string) {
+	if client == nil {
+		return
+	}
+	
+	if attachments == nil {
+		attachments = []string{}
+	}
+	client.SendAsync("admin_reply", AdminReplyEvent{
+		UserID:      userID,
+		UserEmail:   email,
+		Message:     msg,
+		Attachments: attachments,
+	})
+}
+
+// saveAttachments links file URLs to a stored message
+func (s *ChatService) saveAttachments(messageID int, urls []string) ([]db.Attachment, error) {
+	attachments := make([]db.Attachment, 0, len(urls))
+	for _, url := range urls {
+		attachment, err := s.db.SaveAttachment(messageID, url)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save attachment: %w", err)
+		}
+		attachments = append(attachments, *attachment)
+	}
+	return attachments, nil
+}
+
+// StartXMPPListener handles incoming XMPP messages until ctx is cancelled.
+// A Listen call only lasts as long as its session, so after each
+// NotifyReconnected the old one is stopped and a new one started on the
+// current session; there is never more than one running.
 func (s *ChatService) StartXMPPListener(ctx context.Context) {
 	if s.xmpp == nil {
 		log.Println("XMPP client not initialized, skipping listener")
@@ -124,12 +599,19 @@ func (s *ChatService) StartXMPPListener(ctx context.Context) {
 	errorChan := make(chan error, 10)
 	
 	// Start XMPP listener in goroutine
-	go func() {
-		err := s.xmpp.Listen(ctx, messages, errorChan)
-		if err != nil {
-			log.Printf("XMPP listener error: %v", err)
-		}
-	}()
+	listen := func() (context.CancelFunc, <-chan struct{}) {
+		listenCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			err := s.xmpp.Listen(listenCtx, messages, errorChan)
+			if err != nil && listenCtx.Err() == nil {
+				log.Printf("XMPP listener error: %v", err)
+			}
+		}()
+		return cancel, done
+	}
+	stopListen, listenDone := listen()
 	
 	log.Println("XMPP listener started")
 	
@@ -137,19 +619,50 @@ func (s *ChatService) StartXMPPListener(ctx context.Context) {
 	for {
 		select {
 		case msg := <-messages:
-			log.Printf("Received XMPP message from %s to %s: %s", msg.From, msg.To, msg.Body)
+			log.Printf("Received XMPP message from %s to %s: %s", msg.From, msg.To, redact.Body(msg.Body))
 			if err := s.HandleAdminReply(msg); err != nil {
 				log.Printf("Error handling XMPP message: %v", err)
 			}
 		case err := <-errorChan:
 			log.Printf("XMPP error: %v", err)
+		case <-s.reconnected:
+			// Wait for the old listener to exit so they never overlap
+			stopListen()
+			<-listenDone
+			stopListen, listenDone = listen()
+			log.Println("XMPP listener reattached after reconnect")
 		case <-ctx.Done():
+			stopListen()
+			<-listenDone
 			log.Println("XMPP listener stopping")
 			return
 		}
 	}
 }
 
+// XMPPConnected is called after every successful XMPP connection, e.g. by
+// the reconnector. The first connection starts the listener; later ones move
+// it to the new session.
+func (s *ChatService) XMPPConnected(ctx context.Context) {
+	started := false
+	s.listening.Do(func() {
+		started = true
+		go s.StartXMPPListener(ctx)
+	})
+	if !started {
+		s.NotifyReconnected()
+	}
+}
+
+// NotifyReconnected tells the running listener that XMPP has a new session.
+// Signals that arrive before the listener has caught up are coalesced.
+func (s *ChatService) NotifyReconnected() {
+	select {
+	case s.reconnected <- struct{}{}:
+	default:
+	}
+}
+
 func (s *ChatService) GetUserMessages(userID int) ([]db.Message, error) {
 	messages, err := s.db.GetUserMessages(userID)
 	if err != nil {
@@ -157,3 +670,37 @@ func (s *ChatService) GetUserMessages(userID int) ([]db.Message, error) {
 	}
 	return messages, nil
 }
+
+// GetUserMessagesPage retrieves a page of the user's messages and whether
+// older ones remain. A non-empty senderType ("user", "admin" or "system")
+// only includes that kind of sender.
+func (s *ChatService) GetUserMessagesPage(userID int, senderType string, page db.Page) ([]db.Message, bool, error) {
+	if senderType != "" && !db.ValidSenderType(senderType) {
+		return nil, false, fmt.Errorf("%w: %q", ErrInvalidSenderType, senderType)
+	}
+	
+	messages, hasMore, err := s.db.GetUserMessagesPage(userID, senderType, page)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get user messages: %w", err)
+	}
+	return messages, hasMore, nil
+}
+
+// GetUserHistory retrieves the user's messages across all sessions in chronological order
+func (s *ChatService) GetUserHistory(userID int) ([]db.HistoryEntry, error) {
+	history, err := s.db.GetUserHistory(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user history: %w", err)
+	}
+	return history, nil
+}
+
+// GetUserHistoryPage retrieves a page of the user's messages across all
+// sessions and whether older ones remain
+func (s *ChatService) GetUserHistoryPage(userID int, page db.Page) ([]db.HistoryEntry, bool, error) {
+	history, hasMore, err := s.db.GetUserHistoryPage(userID, page)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get user history: %w", err)
+	}
+	return history, hasMore, nil
+}
\ No newline at end of file
@@ -0,0 +1,140 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/ngenohkevin/veilsupport/internal/db"
+	"github.com/ngenohkevin/veilsupport/internal/i18n"
+)
+
+var (
+	// ErrSessionNotFound is returned when a session doesn't exist
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionForbidden is returned when a user asks for someone else's session
+	ErrSessionForbidden = errors.New("session belongs to another user")
+	// ErrSessionResolved is returned when resolving a session that is already resolved
+	ErrSessionResolved = errors.New("session already resolved")
+)
+
+// SetSurveyURL sets the satisfaction survey link sent to users when their
+// session is resolved. "{session_id}" in the template is replaced with the
+// session's ID. An empty template disables surveys.
+func (s *ChatService) SetSurveyURL(template string) {
+	s.surveyURL = template
+}
+
+// SetMaxActiveSessions caps how many active sessions a user may have at
+// once. Starting a session past the cap resolves the user's oldest active
+// ones. 0 removes the cap.
+func (s *ChatService) SetMaxActiveSessions(max int) {
+	s.maxSessions = max
+}
+
+// StartSession opens a new conversation for the user alongside any active
+// ones, which new messages no longer go to. Past the cap, the oldest active
+// sessions are resolved first, just as if an agent had resolved them.
+func (s *ChatService) StartSession(userID int) (*db.Session, error) {
+	s.starting.Lock()
+	defer s.starting.Unlock()
+
+	if s.maxSessions > 0 {
+		active, err := s.db.GetActiveSessions(userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get active sessions: %w", err)
+		}
+		for i := 0; i <= len(active)-s.maxSessions; i++ {
+			_, err := s.ResolveSession(active[i].ID)
+			if err != nil && !errors.Is(err, ErrSessionResolved) {
+				return nil, fmt.Errorf("failed to close session %d: %w", active[i].ID, err)
+			}
+		}
+	}
+
+	session, err := s.db.CreateSession(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start session: %w", err)
+	}
+	return session, nil
+}
+
+// GetSession returns any session's status and message count, for admins.
+// The count includes agents' notes.
+func (s *ChatService) GetSession(sessionID int) (*db.SessionSummary, error) {
+	return s.getSessionSummary(sessionID, true)
+}
+
+// GetUserSession returns one of the user's own sessions. Its message count
+// leaves out agents' notes, which the user never sees.
+func (s *ChatService) GetUserSession(userID, sessionID int) (*db.SessionSummary, error) {
+	summary, err := s.getSessionSummary(sessionID, false)
+	if err != nil {
+		return nil, err
+	}
+	if summary.UserID != userID {
+		return nil, ErrSessionForbidden
+	}
+	return summary, nil
+}
+
+func (s *ChatService) getSessionSummary(sessionID int, includeNotes bool) (*db.SessionSummary, error) {
+	summary, err := s.db.GetSessionSummary(sessionID, includeNotes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if summary == nil {
+		return nil, ErrSessionNotFound
+	}
+	return summary, nil
+}
+
+// ResolveSession closes a session and, when surveys are enabled, sends the
+// user a link to rate it. The survey is stored in the resolved session.
+func (s *ChatService) ResolveSession(sessionID int) (*db.Session, error) {
+	session, err := s.db.GetSessionByID(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if session == nil {
+		return nil, ErrSessionNotFound
+	}
+	if session.Status == db.SessionResolved {
+		return nil, ErrSessionResolved
+	}
+
+	session, err = s.db.ResolveSession(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve session: %w", err)
+	}
+
+	if s.surveyURL != "" {
+		if err := s.sendSurvey(session); err != nil {
+			log.Printf("Failed to send survey for session %d: %v", session.ID, err)
+		}
+	}
+
+	return session, nil
+}
+
+// sendSurvey stores the survey link as a system message and pushes it to the user
+func (s *ChatService) sendSurvey(session *db.Session) error {
+	user, err := s.db.GetUserByID(session.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return fmt.Errorf("user not found")
+	}
+
+	url := strings.ReplaceAll(s.surveyURL, "{session_id}", strconv.Itoa(session.ID))
+	content := s.catalog.Render(user.Locale, i18n.KeySurvey, url)
+
+	msg, err := s.db.SaveSessionMessage(session, content, db.SenderSystem)
+	if err != nil {
+		return fmt.Errorf("failed to save survey message: %w", err)
+	}
+	return s.pushSystemMessage(msg)
+}
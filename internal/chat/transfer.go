@@ -0,0 +1,130 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ngenohkevin/veilsupport/internal/db"
+)
+
+// transcriptLength is how many recent messages are included in a handoff
+const transcriptLength = 10
+
+// ErrNoActiveSession is returned when a user has no open conversation
+var ErrNoActiveSession = errors.New("no active session")
+
+// Transfer describes a completed conversation handoff
+type Transfer struct {
+	Session   *db.Session `json:"session"`
+	FromAgent string      `json:"from_agent,omitempty"`
+	ToAgent   string      `json:"to_agent"`
+	Note      *db.Message `json:"note"`
+	Notified  []string    `json:"notified"`
+}
+
+// TransferConversation reassigns the user's active session to another agent,
+// records a handoff note in the conversation that only agents see and, when
+// notify is set, sends the new agent the note with the recent transcript and
+// tells the previous agent about the handoff.
+func (s *ChatService) TransferConversation(userID int, toAgent, note string, notify bool) (*Transfer, error) {
+	user, err := s.db.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	session, err := s.db.GetActiveSession(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active session: %w", err)
+	}
+	if session == nil {
+		return nil, ErrNoActiveSession
+	}
+
+	transfer := &Transfer{
+		FromAgent: session.AssignedTo,
+		ToAgent:   toAgent,
+		Notified:  []string{},
+	}
+
+	transfer.Session, err = s.db.AssignSession(session.ID, toAgent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reassign session: %w", err)
+	}
+
+	// Grab the transcript before the note so the note isn't part of it
+	transcript, err := s.db.GetRecentSessionMessages(session.ID, transcriptLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transcript: %w", err)
+	}
+
+	transfer.Note, err = s.db.SaveMessage(userID, formatHandoffNote(transfer.FromAgent, toAgent, note), db.SenderNote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save handoff note: %w", err)
+	}
+
+	if notify {
+		transfer.Notified = s.notifyTransfer(user, transfer, note, transcript)
+	}
+
+	log.Printf("Conversation with user %d transferred to %s", userID, toAgent)
+	return transfer, nil
+}
+
+// notifyTransfer messages both agents and returns the JIDs that were reached
+func (s *ChatService) notifyTransfer(user *db.User, transfer *Transfer, note string, transcript []db.Message) []string {
+	notified := []string{}
+	if s.xmpp == nil || !s.xmpp.IsConnected() {
+		log.Println("XMPP not connected - transfer notifications skipped")
+		return notified
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🔀 Conversation with %s (User ID: %d) has been transferred to you", user.Email, user.ID))
+	if transfer.FromAgent != "" {
+		sb.WriteString(fmt.Sprintf(" from %s", transfer.FromAgent))
+	}
+	sb.WriteString("\n")
+	if note != "" {
+		sb.WriteString(fmt.Sprintf("📝 Note: %s\n", note))
+	}
+	sb.WriteString("\nRecent transcript:\n")
+	if len(transcript) == 0 {
+		sb.WriteString("(no messages yet)\n")
+	}
+	for _, msg := range transcript {
+		sb.WriteString(fmt.Sprintf("[%s] %s: %s\n", msg.CreatedAt.Format("15:04:05"), msg.SenderType, msg.Content))
+	}
+
+	if err := s.xmpp.SendMessage(transfer.ToAgent, sb.String()); err != nil {
+		log.Printf("Failed to notify %s of transfer: %v", transfer.ToAgent, err)
+	} else {
+		notified = append(notified, transfer.ToAgent)
+	}
+
+	if transfer.FromAgent != "" && transfer.FromAgent != transfer.ToAgent {
+		message := fmt.Sprintf("🔀 Conversation with %s (User ID: %d) has been transferred to %s", user.Email, user.ID, transfer.ToAgent)
+		if err := s.xmpp.SendMessage(transfer.FromAgent, message); err != nil {
+			log.Printf("Failed to notify %s of transfer: %v", transfer.FromAgent, err)
+		} else {
+			notified = append(notified, transfer.FromAgent)
+		}
+	}
+
+	return notified
+}
+
+func formatHandoffNote(fromAgent, toAgent, note string) string {
+	content := fmt.Sprintf("Conversation transferred to %s", toAgent)
+	if fromAgent != "" {
+		content = fmt.Sprintf("Conversation transferred from %s to %s", fromAgent, toAgent)
+	}
+	if note != "" {
+		content += ": " + note
+	}
+	return content
+}
@@ -0,0 +1,63 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/ngenohkevin/veilsupport/internal/db"
+)
+
+// ErrUploadsDisabled is returned when sharing a file without an uploader set
+var ErrUploadsDisabled = errors.New("file uploads are not enabled")
+
+// FileUploader shares files somewhere agents can fetch them and returns the
+// download URL. *xmpp.XMPPClient satisfies it through XEP-0363 HTTP upload.
+type FileUploader interface {
+	Upload(ctx context.Context, name, contentType string, data []byte) (string, error)
+}
+
+// SetFileUploader enables SendAttachment. nil disables it.
+func (s *ChatService) SetFileUploader(uploader FileUploader) {
+	s.uploader = uploader
+}
+
+// SendAttachment uploads a file from the user and sends its URL to the agent
+// as a message, with the file recorded as the message's attachment. Upload
+// failures are returned as ErrBridgeUnavailable, since nothing is saved.
+func (s *ChatService) SendAttachment(ctx context.Context, userID int, name, contentType string, data []byte) (*db.Message, error) {
+	if s.uploader == nil {
+		return nil, ErrUploadsDisabled
+	}
+
+	user, err := s.db.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	if err := s.checkOrgLimits(user); err != nil {
+		return nil, err
+	}
+
+	url, err := s.uploader.Upload(ctx, name, contentType, data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBridgeUnavailable, err)
+	}
+
+	msg, err := s.db.SaveMessage(userID, url, db.SenderUser)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save message: %w", err)
+	}
+	if _, err := s.saveAttachments(msg.ID, []string{url}); err != nil {
+		return nil, err
+	}
+
+	s.broadcastNewMessage(user, msg)
+	if err := s.notifyAdmin(ctx, user, msg); err != nil {
+		log.Printf("%v - attachment saved to database only", err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"crypto/tls"
+	"net/url"
+	"time"
+)
+
+// Redacted replaces a secret in Diagnostics. Unset secrets are shown as ""
+// so operators can still tell whether one is configured.
+const Redacted = "[redacted]"
+
+// Diagnostics returns the effective configuration keyed by environment
+// variable, for operators debugging a deployment. Passwords, secrets and
+// credentials embedded in URLs are redacted.
+func (c *Config) Diagnostics() map[string]interface{} {
+	return map[string]interface{}{
+		"DATABASE_URL":         redactURL(c.DatabaseURL),
+		"JWT_SECRET":           secret(c.JWTSecret),
+		"JWT_PREVIOUS_SECRETS": secrets(c.JWTPreviousSecrets),
+		"BCRYPT_COST":          c.BcryptCost,
+		"PASSWORD_RESET_URL":   c.PasswordResetURL,
+		"PASSWORD_RESET_TTL":   duration(c.PasswordResetTTL),
+		"PORT":                 c.Port,
+		"MAX_USERS":            c.MaxUsers,
+		"MAX_ACTIVE_SESSIONS":  c.MaxActiveSessions,
+		"ORG_MESSAGE_QUOTA":    c.OrgMessageQuota,
+		"ORG_QUOTA_PERIOD":     duration(c.OrgQuotaPeriod),
+		"ORG_RATE_LIMIT":       c.OrgRateLimit,
+		"PAGE_SIZE_DEFAULT":    c.PageSizeDefault,
+		"PAGE_SIZE_MAX":        c.PageSizeMax,
+		"OUTBOX_DRAIN_TIMEOUT": duration(c.OutboxDrainTimeout),
+		"TRACING_EXPORTER":     string(c.TracingExporter),
+
+		"XMPP_SERVER":                c.XMPPServer,
+		"XMPP_CONNECTION_JID":        c.XMPPConnectionJID,
+		"XMPP_CONNECTION_PASSWORD":   secret(c.XMPPConnectionPassword),
+		"XMPP_ADMIN_JID":             c.XMPPAdminJID,
+		"XMPP_ADMIN_JIDS":            c.XMPPAdminJIDs,
+		"XMPP_FALLBACK_ADMIN_JID":    c.XMPPFallbackAdminJID,
+		"XMPP_TLS_MIN_VERSION":       tls.VersionName(c.XMPPTLS.MinVersion),
+		"XMPP_TLS_CIPHER_SUITES":     cipherSuiteNames(c.XMPPTLS.CipherSuites),
+		"XMPP_RECONNECT_MAX_BACKOFF": duration(c.XMPPReconnectMaxBackoff),
+		"XMPP_ALERT_AFTER_ATTEMPTS":  c.XMPPAlertAfterAttempts,
+		"XMPP_ALERT_AFTER":           duration(c.XMPPAlertAfter),
+		"XMPP_KEEPALIVE_INTERVAL":    duration(c.XMPPKeepAlive),
+		"XMPP_UPLOAD_SERVICE":        c.XMPPUploadService,
+		"XMPP_UPLOAD_SLOT_TIMEOUT":   duration(c.XMPPUpload.SlotTimeout),
+		"XMPP_UPLOAD_PUT_TIMEOUT":    duration(c.XMPPUpload.PutTimeout),
+		"XMPP_UPLOAD_ATTEMPTS":       c.XMPPUpload.Attempts,
+		"XMPP_UPLOAD_BACKOFF":        duration(c.XMPPUpload.Backoff),
+
+		"XMPP_BOT_JID":                c.BotJID,
+		"XMPP_BOT_PASSWORD":           secret(c.BotPassword),
+		"GATEWAY_REPLY_CONFIRMATIONS": c.GatewayReplyConfirmations,
+		"GATEWAY_PRESENCE_GRACE":      duration(c.GatewayPresenceGrace),
+		"GATEWAY_THREADS":             c.GatewayThreads,
+
+		"METRICS_INTERVAL":    duration(c.MetricsInterval),
+		"NOTIFY_BATCH_WINDOW": duration(c.NotifyBatchWindow),
+
+		"ADMIN_REPLY_WEBHOOK_URL":      redactURL(c.AdminReplyWebhookURL),
+		"ADMIN_REPLY_WEBHOOK_ATTEMPTS": c.AdminReplyWebhookAttempts,
+		"INBOUND_WEBHOOK_SECRET":       secret(c.InboundWebhookSecret),
+		"INBOUND_CREATE_USERS":         c.InboundCreateUsers,
+
+		"DEFAULT_LOCALE":         c.DefaultLocale,
+		"WELCOME_MESSAGES":       c.WelcomeMessages,
+		"SURVEY_URL_TEMPLATE":    c.SurveyURLTemplate,
+		"CONTENT_SANITIZE":       string(c.ContentSanitize),
+		"CONTENT_NORMALIZE":      c.ContentNormalize,
+		"LOG_MESSAGE_BODIES":     string(c.LogMessageBodies),
+		"MESSAGE_PURGE_INTERVAL": duration(c.MessagePurgeInterval),
+
+		"UPLOAD_DIR":                  c.UploadDir,
+		"ATTACHMENT_MAX_AGE":          duration(c.AttachmentMaxAge),
+		"ATTACHMENT_CLEANUP_INTERVAL": duration(c.AttachmentCleanupInterval),
+
+		"WS_SEND_ACKS":         c.WSSendAcks,
+		"WS_IDLE_TIMEOUT":      duration(c.WSIdleTimeout),
+		"WS_OFFLINE_QUEUE":     c.WSOfflineQueue,
+		"WS_FLUSH_BATCH":       c.WSFlushBatch,
+		"WS_FLUSH_DELAY":       duration(c.WSFlushDelay),
+		"WS_DELIVERY_RECEIPTS": c.WSDeliveryReceipts,
+	}
+}
+
+func secret(value string) string {
+	if value == "" {
+		return ""
+	}
+	return Redacted
+}
+
+func secrets(values []string) []string {
+	redacted := make([]string, len(values))
+	for i := range values {
+		redacted[i] = Redacted
+	}
+	return redacted
+}
+
+// redactURL hides the password and query string, which is where URLs
+// usually carry credentials
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return Redacted
+	}
+	if parsed.RawQuery != "" {
+		parsed.RawQuery = Redacted
+	}
+	return parsed.Redacted()
+}
+
+func cipherSuiteNames(suites []uint16) []string {
+	names := make([]string, len(suites))
+	for i, suite := range suites {
+		names[i] = tls.CipherSuiteName(suite)
+	}
+	return names
+}
+
+func duration(d time.Duration) string {
+	return d.String()
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Attachment is a file linked to a message, referenced by URL. Once expired
+// the file is gone and URL is empty.
+type Attachment struct {
+	ID        int        `json:"id"`
+	MessageID int        `json:"message_id"`
+	URL       string     `json:"url"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiredAt *time.Time `json:"expired_at,omitempty"`
+}
+
+// attachmentColumns lists the columns scanned by scanAttachment, in order
+const attachmentColumns = `id, message_id, url, created_at, expired_at`
+
+func scanAttachment(row pgx.Row, attachment *Attachment) error {
+	return row.Scan(&attachment.ID, &attachment.MessageID, &attachment.URL, &attachment.CreatedAt, &attachment.ExpiredAt)
+}
+
+func (d *DB) SaveAttachment(messageID int, url string) (*Attachment, error) {
+	var attachment Attachment
+
+	err := scanAttachment(d.pool.QueryRow(context.Background(),
+		`INSERT INTO attachments (message_id, url) VALUES ($1, $2)
+         RETURNING `+attachmentColumns,
+		messageID, url), &attachment)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to save attachment: %w", err)
+	}
+
+	return &attachment, nil
+}
+
+func (d *DB) GetMessageAttachments(messageID int) ([]Attachment, error) {
+	rows, err := d.pool.Query(context.Background(),
+		`SELECT `+attachmentColumns+` FROM attachments
+         WHERE message_id = $1 ORDER BY id`, messageID)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var attachments []Attachment
+	for rows.Next() {
+		var attachment Attachment
+		if err := scanAttachment(rows, &attachment); err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating attachments: %w", err)
+	}
+
+	return attachments, nil
+}
+
+// GetExpirableAttachments returns up to limit unexpired attachments older
+// than maxAge by the database's clock with IDs above afterID, in ID order
+func (d *DB) GetExpirableAttachments(maxAge time.Duration, afterID, limit int) ([]Attachment, error) {
+	rows, err := d.pool.Query(context.Background(),
+		`SELECT `+attachmentColumns+` FROM attachments
+         WHERE created_at < NOW() - $1::interval AND expired_at IS NULL AND id > $2
+         ORDER BY id LIMIT $3`, maxAge, afterID, limit)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get expirable attachments: %w", err)
+	}
+	defer rows.Close()
+
+	var attachments []Attachment
+	for rows.Next() {
+		var attachment Attachment
+		if err := scanAttachment(rows, &attachment); err != nil {
+			return nil, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating attachments: %w", err)
+	}
+
+	return attachments, nil
+}
+
+// MarkAttachmentExpired records that an attachment's file is gone, clearing its URL
+func (d *DB) MarkAttachmentExpired(attachmentID int) error {
+	_, err := d.pool.Exec(context.Background(),
+		`UPDATE attachments SET expired_at = NOW(), url = '' WHERE id = $1`, attachmentID)
+	if err != nil {
+		return fmt.Errorf("failed to mark attachment expired: %w", err)
+	}
+	return nil
+}
@@ -3,13 +3,16 @@ package db
 import (
 	"context"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// DB is safe for concurrent use: every query borrows a connection from a pool
 type DB struct {
-	conn *pgx.Conn
+	pool *pgxpool.Pool
 }
 
 type User struct {
@@ -17,31 +20,92 @@ type User struct {
 	Email        string    `json:"email"`
 	PasswordHash string    `json:"-"` // Don't include in JSON responses
 	XmppJID      string    `json:"xmpp_jid"`
+	Locale       string    `json:"locale,omitempty"` // Empty means the server default
+	Metadata     Metadata  `json:"metadata,omitempty"`
+	VIP          bool      `json:"vip"` // Messages are sent to admins first and flagged
+	Admin        bool      `json:"admin,omitempty"` // May use the admin API; granted out of band
 	CreatedAt    time.Time `json:"created_at"`
 }
 
+// Metadata is integrator-supplied context about a user, such as plan tier or account ID
+type Metadata map[string]interface{}
+
+// userColumns lists the columns scanned by scanUser, in order
+const userColumns = `id, email, password_hash, xmpp_jid, COALESCE(locale, ''), metadata, vip, admin, created_at`
+
+func scanUser(row pgx.Row, user *User) error {
+	return row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.XmppJID, &user.Locale, &user.Metadata, &user.VIP, &user.Admin, &user.CreatedAt)
+}
+
 type Message struct {
-	ID         int       `json:"id"`
-	UserID     int       `json:"user_id"`
-	Content    string    `json:"content"`
-	SenderType string    `json:"sender_type"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Content    string     `json:"content"`
+	SessionID  *int       `json:"session_id"`
+	Seq        int        `json:"seq"` // Position within the session, starting at 1; 0 for agents' notes
+	SenderType string     `json:"sender_type"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	EditedAt   *time.Time `json:"edited_at,omitempty"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"` // Content is cleared when deleted
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // Deleted by the purge job after this
+}
+
+// Message delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusSent      = "sent"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Message sender types
+const (
+	SenderUser   = "user"
+	SenderAdmin  = "admin"
+	SenderSystem = "system"
+	SenderNote   = "note" // For agents only; never shown to the user
+)
+
+// ValidSenderType reports whether senderType is one of the sender types a
+// user sees in their history
+func ValidSenderType(senderType string) bool {
+	switch senderType {
+	case SenderUser, SenderAdmin, SenderSystem:
+		return true
+	}
+	return false
+}
+
+// messageColumns lists the columns scanned by scanMessage, in order
+const messageColumns = `id, user_id, session_id, COALESCE(seq, 0), content, sender_type, status, created_at, edited_at, deleted_at, expires_at`
+
+func scanMessage(row pgx.Row, msg *Message) error {
+	return row.Scan(&msg.ID, &msg.UserID, &msg.SessionID, &msg.Seq, &msg.Content, &msg.SenderType, &msg.Status, &msg.CreatedAt, &msg.EditedAt, &msg.DeletedAt, &msg.ExpiresAt)
 }
 
 func New(dsn string) (*DB, error) {
-	conn, err := pgx.Connect(context.Background(), dsn)
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
-	return &DB{conn: conn}, nil
+	
+	// The pool connects lazily; fail now rather than on the first query
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return &DB{pool: pool}, nil
 }
 
 func (d *DB) Close() error {
-	return d.conn.Close(context.Background())
+	d.pool.Close()
+	return nil
 }
 
-func (d *DB) GetConn() *pgx.Conn {
-	return d.conn
+func (d *DB) GetConn() *pgxpool.Pool {
+	return d.pool
 }
 
 func generateJID(email string) string {
@@ -71,15 +135,50 @@ func generateJID(email string) string {
 }
 
 func (d *DB) CreateUser(email, passwordHash string) (*User, error) {
+	return d.CreateUserWithMetadata(email, passwordHash, nil)
+}
+
+// CreateUserWithMetadata creates a user with their metadata in one statement
+func (d *DB) CreateUserWithMetadata(email, passwordHash string, metadata Metadata) (*User, error) {
+	xmppJID := generateJID(email)
+	if metadata == nil {
+		metadata = Metadata{}
+	}
+	var user User
+	
+	err := scanUser(d.pool.QueryRow(context.Background(),
+		`INSERT INTO users (email, password_hash, xmpp_jid, metadata) 
+         VALUES ($1, $2, $3, $4) RETURNING `+userColumns,
+		email, passwordHash, xmppJID, metadata), &user)
+	
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	
+	return &user, nil
+}
+
+// CreateUserWithinLimit creates a user unless there are already maxUsers,
+// counting and inserting in one statement so concurrent registrations can't
+// both take the last place. It returns nil if the limit has been reached.
+func (d *DB) CreateUserWithinLimit(email, passwordHash string, metadata Metadata, maxUsers int) (*User, error) {
 	xmppJID := generateJID(email)
+	if metadata == nil {
+		metadata = Metadata{}
+	}
 	var user User
 	
-	err := d.conn.QueryRow(context.Background(),
-		`INSERT INTO users (email, password_hash, xmpp_jid) 
-         VALUES ($1, $2, $3) RETURNING id, email, xmpp_jid, created_at`,
-		email, passwordHash, xmppJID).Scan(&user.ID, &user.Email, &user.XmppJID, &user.CreatedAt)
+	err := scanUser(d.pool.QueryRow(context.Background(),
+		`INSERT INTO users (email, password_hash, xmpp_jid, metadata)
+         SELECT $1::varchar, $2::varchar, $3::varchar, $4::jsonb
+         WHERE (SELECT COUNT(*) FROM users) < $5
+         RETURNING `+userColumns,
+		email, passwordHash, xmppJID, metadata, maxUsers), &user)
 	
 	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 	
@@ -89,9 +188,9 @@ func (d *DB) CreateUser(email, passwordHash string) (*User, error) {
 func (d *DB) GetUserByEmail(email string) (*User, error) {
 	var user User
 	
-	err := d.conn.QueryRow(context.Background(),
-		`SELECT id, email, password_hash, xmpp_jid, created_at FROM users WHERE email = $1`,
-		email).Scan(&user.ID, &user.Email, &user.PasswordHash, &user.XmppJID, &user.CreatedAt)
+	err := scanUser(d.pool.QueryRow(context.Background(),
+		`SELECT `+userColumns+` FROM users WHERE email = $1`,
+		email), &user)
 	
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -106,9 +205,9 @@ func (d *DB) GetUserByEmail(email string) (*User, error) {
 func (d *DB) GetUserByID(id int) (*User, error) {
 	var user User
 	
-	err := d.conn.QueryRow(context.Background(),
-		`SELECT id, email, xmpp_jid, created_at FROM users WHERE id = $1`,
-		id).Scan(&user.ID, &user.Email, &user.XmppJID, &user.CreatedAt)
+	err := scanUser(d.pool.QueryRow(context.Background(),
+		`SELECT `+userColumns+` FROM users WHERE id = $1`,
+		id), &user)
 	
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -123,9 +222,9 @@ func (d *DB) GetUserByID(id int) (*User, error) {
 func (d *DB) GetUserByJID(jid string) (*User, error) {
 	var user User
 	
-	err := d.conn.QueryRow(context.Background(),
-		`SELECT id, email, xmpp_jid, created_at FROM users WHERE xmpp_jid = $1`,
-		jid).Scan(&user.ID, &user.Email, &user.XmppJID, &user.CreatedAt)
+	err := scanUser(d.pool.QueryRow(context.Background(),
+		`SELECT `+userColumns+` FROM users WHERE xmpp_jid = $1`,
+		jid), &user)
 	
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -137,25 +236,181 @@ func (d *DB) GetUserByJID(jid string) (*User, error) {
 	return &user, nil
 }
 
+// CountUsers returns the total number of registered users
+func (d *DB) CountUsers() (int, error) {
+	var count int
+	err := d.pool.QueryRow(context.Background(), `SELECT COUNT(*) FROM users`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return count, nil
+}
+
+// SetUserLocale stores the user's preferred locale; an empty locale resets it to the server default
+func (d *DB) SetUserLocale(userID int, locale string) error {
+	var value interface{}
+	if locale != "" {
+		value = locale
+	}
+	
+	_, err := d.pool.Exec(context.Background(),
+		`UPDATE users SET locale = $2 WHERE id = $1`, userID, value)
+	if err != nil {
+		return fmt.Errorf("failed to set user locale: %w", err)
+	}
+	return nil
+}
+
+// SetUserMetadata replaces the user's metadata; nil clears it
+func (d *DB) SetUserMetadata(userID int, metadata Metadata) error {
+	if metadata == nil {
+		metadata = Metadata{}
+	}
+	
+	tag, err := d.pool.Exec(context.Background(),
+		`UPDATE users SET metadata = $2 WHERE id = $1`, userID, metadata)
+	if err != nil {
+		return fmt.Errorf("failed to set user metadata: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("failed to set user metadata: user %d not found", userID)
+	}
+	return nil
+}
+
+// SetUserVIP marks or unmarks the user as a VIP
+func (d *DB) SetUserVIP(userID int, vip bool) error {
+	tag, err := d.pool.Exec(context.Background(),
+		`UPDATE users SET vip = $2 WHERE id = $1`, userID, vip)
+	if err != nil {
+		return fmt.Errorf("failed to set user VIP: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("failed to set user VIP: user %d not found", userID)
+	}
+	return nil
+}
+
+// SetUserAdmin grants or revokes admin rights for the user with the given
+// email, ignoring case. It reports whether such a user exists.
+func (d *DB) SetUserAdmin(email string, admin bool) (bool, error) {
+	tag, err := d.pool.Exec(context.Background(),
+		`UPDATE users SET admin = $2 WHERE LOWER(email) = LOWER($1)`, email, admin)
+	if err != nil {
+		return false, fmt.Errorf("failed to set user admin: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// SetUserPasswordHash replaces the user's password hash
+func (d *DB) SetUserPasswordHash(userID int, passwordHash string) error {
+	tag, err := d.pool.Exec(context.Background(),
+		`UPDATE users SET password_hash = $2 WHERE id = $1`, userID, passwordHash)
+	if err != nil {
+		return fmt.Errorf("failed to set password hash: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("failed to set password hash: user %d not found", userID)
+	}
+	return nil
+}
+
+// GetUserMetadata returns the user's metadata, or nil if the user doesn't exist
+func (d *DB) GetUserMetadata(userID int) (Metadata, error) {
+	var metadata Metadata
+	err := d.pool.QueryRow(context.Background(),
+		`SELECT metadata FROM users WHERE id = $1`, userID).Scan(&metadata)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get user metadata: %w", err)
+	}
+	return metadata, nil
+}
+
 func (d *DB) SaveMessage(userID int, content, senderType string) (*Message, error) {
+	return d.SaveExpiringMessage(userID, content, senderType, 0)
+}
+
+// SaveExpiringMessage stores a message that expires ttl from now, by the
+// database's clock; see DeleteExpiredMessages. A ttl of 0 keeps it.
+func (d *DB) SaveExpiringMessage(userID int, content, senderType string, ttl time.Duration) (*Message, error) {
+	// Messages always belong to the user's active session
+	session, err := d.GetOrCreateActiveSession(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save message: %w", err)
+	}
+	
+	return d.saveSessionMessage(session, content, senderType, ttl)
+}
+
+// SaveSessionMessage stores a message in the given session, whatever its status
+func (d *DB) SaveSessionMessage(session *Session, content, senderType string) (*Message, error) {
+	return d.saveSessionMessage(session, content, senderType, 0)
+}
+
+func (d *DB) saveSessionMessage(session *Session, content, senderType string, ttl time.Duration) (*Message, error) {
 	var msg Message
+	userID := session.UserID
 	
-	err := d.conn.QueryRow(context.Background(),
-		`INSERT INTO messages (user_id, content, sender_type) 
-         VALUES ($1, $2, $3) RETURNING id, user_id, content, sender_type, created_at`,
-		userID, content, senderType).Scan(&msg.ID, &msg.UserID, &msg.Content, &msg.SenderType, &msg.CreatedAt)
+	// User messages wait to be bridged; everything else is already delivered to us
+	status := StatusSent
+	if senderType == SenderUser {
+		status = StatusPending
+	}
 	
+	// Expiry is worked out by the database, so it doesn't depend on our clock.
+	// A NULL lifetime leaves expires_at NULL.
+	var lifetime *time.Duration
+	if ttl > 0 {
+		lifetime = &ttl
+	}
+	
+	// Take the session's next sequence number and insert together, so a
+	// failed insert doesn't leave a gap
+	ctx := context.Background()
+	tx, err := d.pool.Begin(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to save message: %w", err)
 	}
+	defer tx.Rollback(ctx)
+	
+	// Users never see notes, so notes get no number; one would leave a gap
+	// in the sequence the user sees
+	var seq *int
+	if senderType != SenderNote {
+		seq = new(int)
+		err = tx.QueryRow(ctx,
+			`UPDATE sessions SET last_seq = last_seq + 1 WHERE id = $1 RETURNING last_seq`,
+			session.ID).Scan(seq)
+		if err != nil {
+			return nil, fmt.Errorf("failed to assign message sequence: %w", err)
+		}
+	}
+	
+	err = scanMessage(tx.QueryRow(ctx,
+		`INSERT INTO messages (user_id, session_id, seq, content, sender_type, status, expires_at) 
+         VALUES ($1, $2, $3, $4, $5, $6, NOW() + $7::interval)
+         RETURNING `+messageColumns,
+		userID, session.ID, seq, content, senderType, status, lifetime), &msg)
+	
+	if err != nil {
+		return nil, fmt.Errorf("failed to save message: %w", err)
+	}
+	
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to save message: %w", err)
+	}
 	
 	return &msg, nil
 }
 
+// GetUserMessages returns the user's messages, leaving out agents' notes
 func (d *DB) GetUserMessages(userID int) ([]Message, error) {
-	rows, err := d.conn.Query(context.Background(),
-		`SELECT id, user_id, content, sender_type, created_at FROM messages 
-         WHERE user_id = $1 ORDER BY created_at`, userID)
+	rows, err := d.pool.Query(context.Background(),
+		`SELECT `+messageColumns+` FROM messages 
+         WHERE user_id = $1 AND sender_type <> 'note' ORDER BY created_at`, userID)
 	
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user messages: %w", err)
@@ -165,7 +420,7 @@ func (d *DB) GetUserMessages(userID int) ([]Message, error) {
 	var messages []Message
 	for rows.Next() {
 		var msg Message
-		err := rows.Scan(&msg.ID, &msg.UserID, &msg.Content, &msg.SenderType, &msg.CreatedAt)
+		err := scanMessage(rows, &msg)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
 		}
@@ -177,4 +432,202 @@ func (d *DB) GetUserMessages(userID int) ([]Message, error) {
 	}
 	
 	return messages, nil
-}
\ No newline at end of file
+}
+
+// Page selects a window of a list, in the list's usual order. Message pages
+// count back from the newest message, so offset 0 is the latest conversation.
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// GetUserMessagesPage returns a page of the user's messages in chronological
+// order, leaving out agents' notes, and whether older messages remain.
+// A non-empty senderType only includes messages from that kind of sender.
+func (d *DB) GetUserMessagesPage(userID int, senderType string, page Page) ([]Message, bool, error) {
+	// One extra row tells whether there is another page
+	rows, err := d.pool.Query(context.Background(),
+		`SELECT `+messageColumns+` FROM messages 
+         WHERE user_id = $1 AND sender_type <> 'note' AND ($2 = '' OR sender_type = $2)
+         ORDER BY created_at DESC, id DESC LIMIT $3 OFFSET $4`, userID, senderType, page.Limit+1, page.Offset)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get user messages: %w", err)
+	}
+	defer rows.Close()
+	
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, false, err
+	}
+	
+	hasMore := len(messages) > page.Limit
+	if hasMore {
+		messages = messages[:page.Limit]
+	}
+	slices.Reverse(messages)
+	return messages, hasMore, nil
+}
+
+// scanMessages reads every row with scanMessage
+func scanMessages(rows pgx.Rows) ([]Message, error) {
+	var messages []Message
+	for rows.Next() {
+		var msg Message
+		if err := scanMessage(rows, &msg); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating messages: %w", err)
+	}
+	
+	return messages, nil
+}
+
+func (d *DB) GetMessageByID(id int) (*Message, error) {
+	var msg Message
+	
+	err := scanMessage(d.pool.QueryRow(context.Background(),
+		`SELECT `+messageColumns+` FROM messages WHERE id = $1`, id), &msg)
+	
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get message by ID: %w", err)
+	}
+	
+	return &msg, nil
+}
+
+func (d *DB) UpdateMessageStatus(id int, status string) error {
+	_, err := d.pool.Exec(context.Background(),
+		`UPDATE messages SET status = $2 WHERE id = $1`, id, status)
+	if err != nil {
+		return fmt.Errorf("failed to update message status: %w", err)
+	}
+	return nil
+}
+
+// ClaimFailedMessage moves a failed message back to pending so it can be
+// sent again. It reports whether the message was failed; when two callers
+// race, only one of them wins the claim.
+func (d *DB) ClaimFailedMessage(id int) (bool, error) {
+	tag, err := d.pool.Exec(context.Background(),
+		`UPDATE messages SET status = $2 WHERE id = $1 AND status = $3`, id, StatusPending, StatusFailed)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim message: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// EditMessage replaces the content of a message that hasn't been deleted and
+// marks it edited. It returns nil if there is no such message.
+func (d *DB) EditMessage(id int, content string) (*Message, error) {
+	var msg Message
+	err := scanMessage(d.pool.QueryRow(context.Background(),
+		`UPDATE messages SET content = $2, edited_at = NOW()
+         WHERE id = $1 AND deleted_at IS NULL RETURNING `+messageColumns, id, content), &msg)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to edit message: %w", err)
+	}
+	return &msg, nil
+}
+
+// DeleteMessage soft-deletes a message: its content is cleared but the row
+// stays, so the session's sequence numbers have no gaps. It returns nil if
+// there is no such message or it was already deleted.
+func (d *DB) DeleteMessage(id int) (*Message, error) {
+	var msg Message
+	err := scanMessage(d.pool.QueryRow(context.Background(),
+		`UPDATE messages SET content = '', deleted_at = NOW()
+         WHERE id = $1 AND deleted_at IS NULL RETURNING `+messageColumns, id), &msg)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to delete message: %w", err)
+	}
+	return &msg, nil
+}
+
+// DeleteExpiredMessages deletes every message whose expiry has passed, the
+// same way DeleteMessage does, and returns them
+func (d *DB) DeleteExpiredMessages() ([]Message, error) {
+	rows, err := d.pool.Query(context.Background(),
+		`UPDATE messages SET content = '', deleted_at = NOW()
+         WHERE expires_at <= NOW() AND deleted_at IS NULL RETURNING `+messageColumns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete expired messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var msg Message
+		if err := scanMessage(rows, &msg); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to delete expired messages: %w", err)
+	}
+	return messages, nil
+}
+
+// CountMessagesByStatus returns how many user messages currently have the given status
+func (d *DB) CountMessagesByStatus(status string) (int, error) {
+	var count int
+	err := d.pool.QueryRow(context.Background(),
+		`SELECT COUNT(*) FROM messages WHERE sender_type = 'user' AND status = $1`, status).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count messages: %w", err)
+	}
+	return count, nil
+}
+
+// GetMessagesByStatus returns up to limit user messages with the given status,
+// VIP users' first and otherwise oldest first
+func (d *DB) GetMessagesByStatus(status string, limit int) ([]Message, error) {
+	rows, err := d.pool.Query(context.Background(),
+		`SELECT `+messageColumns+` FROM messages
+         WHERE sender_type = 'user' AND status = $1
+         ORDER BY user_id IN (SELECT id FROM users WHERE vip) DESC, created_at, id
+         LIMIT $2`, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages by status: %w", err)
+	}
+	defer rows.Close()
+	
+	var messages []Message
+	for rows.Next() {
+		var msg Message
+		if err := scanMessage(rows, &msg); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating messages: %w", err)
+	}
+	
+	return messages, nil
+}
+
+// CountMessagesSince returns how many messages were stored after the given time
+func (d *DB) CountMessagesSince(since time.Time) (int, error) {
+	var count int
+	err := d.pool.QueryRow(context.Background(),
+		`SELECT COUNT(*) FROM messages WHERE created_at > $1`, since).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count messages: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// OrgUsage is how many messages an organization has sent in its current
+// quota period
+type OrgUsage struct {
+	Org         string    `json:"org"`
+	PeriodStart time.Time `json:"period_start"`
+	Messages    int       `json:"messages"`
+}
+
+// UseOrgQuota counts a message against the organization's quota of quota
+// messages per period and reports whether it was allowed. A period starts
+// with the first message after the previous one ended. Messages over quota
+// aren't counted.
+//
+// The check and the count are one statement, so concurrent messages can't
+// both take the last message of a quota, and the database clock decides
+// when a period ends.
+func (d *DB) UseOrgQuota(org string, quota int, period time.Duration) (bool, error) {
+	var messages int
+	err := d.pool.QueryRow(context.Background(),
+		`INSERT INTO org_usage (org, period_start, messages) VALUES ($1, NOW(), 1)
+         ON CONFLICT (org) DO UPDATE SET
+             messages = CASE WHEN org_usage.period_start + $3::interval <= NOW()
+                 THEN 1 ELSE org_usage.messages + 1 END,
+             period_start = CASE WHEN org_usage.period_start + $3::interval <= NOW()
+                 THEN NOW() ELSE org_usage.period_start END
+         WHERE org_usage.period_start + $3::interval <= NOW() OR org_usage.messages < $2
+         RETURNING messages`,
+		org, quota, period).Scan(&messages)
+	if err == pgx.ErrNoRows {
+		// The conflicting row was left alone: the quota is used up
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to use org quota: %w", err)
+	}
+	return true, nil
+}
+
+// GetOrgUsage returns the organization's usage, or nil if it hasn't sent
+// anything yet
+func (d *DB) GetOrgUsage(org string) (*OrgUsage, error) {
+	var usage OrgUsage
+	err := d.pool.QueryRow(context.Background(),
+		`SELECT org, period_start, messages FROM org_usage WHERE org = $1`, org).
+		Scan(&usage.Org, &usage.PeriodStart, &usage.Messages)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get org usage: %w", err)
+	}
+	return &usage, nil
+}
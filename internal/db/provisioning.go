@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// NewUser is a user to create with CreateUsers, along with a password reset
+// token so they can choose their own password
+type NewUser struct {
+	Email          string
+	PasswordHash   string
+	Metadata       Metadata
+	ResetTokenHash string        // SHA-256 hex of the token handed out
+	ResetTTL       time.Duration // From creation, by the database's clock
+}
+
+// CreateUsers creates all of the users and their reset tokens in a single
+// transaction, so either every user is created or none are
+func (d *DB) CreateUsers(users []NewUser) ([]*User, error) {
+	ctx := context.Background()
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create users: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	created := make([]*User, 0, len(users))
+	jids := make(map[string]int)
+	for _, newUser := range users {
+		metadata := newUser.Metadata
+		if metadata == nil {
+			metadata = Metadata{}
+		}
+
+		// JIDs are only unique to the second, which a batch easily shares
+		jid := generateJID(newUser.Email)
+		if n := jids[jid]; n > 0 {
+			jids[jid]++
+			jid = fmt.Sprintf("%d_%s", n, jid)
+		} else {
+			jids[jid] = 1
+		}
+
+		var user User
+		err := scanUser(tx.QueryRow(ctx,
+			`INSERT INTO users (email, password_hash, xmpp_jid, metadata)
+             VALUES ($1, $2, $3, $4) RETURNING `+userColumns,
+			newUser.Email, newUser.PasswordHash, jid, metadata), &user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create user %s: %w", newUser.Email, err)
+		}
+
+		if newUser.ResetTokenHash != "" {
+			_, err = tx.Exec(ctx,
+				`INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES ($1, $2, NOW() + $3::interval)`,
+				user.ID, newUser.ResetTokenHash, newUser.ResetTTL)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create password reset for %s: %w", newUser.Email, err)
+			}
+		}
+		created = append(created, &user)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to create users: %w", err)
+	}
+	return created, nil
+}
+
+// ConsumePasswordReset sets a new password hash for the owner of an unused,
+// unexpired reset token, uses the token up and revokes the owner's login
+// tokens, so whoever knew the old password is logged out. It returns nil if
+// there is no such token.
+func (d *DB) ConsumePasswordReset(tokenHash, passwordHash string) (*User, error) {
+	ctx := context.Background()
+	tx, err := d.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reset password: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int
+	err = tx.QueryRow(ctx,
+		`UPDATE password_resets SET used_at = NOW()
+         WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+         RETURNING user_id`, tokenHash).Scan(&userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to reset password: %w", err)
+	}
+
+	var user User
+	err = scanUser(tx.QueryRow(ctx,
+		`UPDATE users SET password_hash = $2 WHERE id = $1 RETURNING `+userColumns,
+		userID, passwordHash), &user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reset password: %w", err)
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE auth_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke auth tokens: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to reset password: %w", err)
+	}
+	return &user, nil
+}
@@ -0,0 +1,304 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Session statuses
+const (
+	SessionActive   = "active"
+	SessionResolved = "resolved"
+)
+
+// Session groups a user's messages into a single support conversation
+type Session struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Status     string     `json:"status"`
+	AssignedTo string     `json:"assigned_to,omitempty"` // Agent JID, empty when unassigned
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// sessionColumns lists the columns scanned by scanSession, in order
+const sessionColumns = `id, user_id, status, COALESCE(assigned_to, ''), created_at, resolved_at`
+
+func scanSession(row pgx.Row, session *Session) error {
+	return row.Scan(&session.ID, &session.UserID, &session.Status, &session.AssignedTo, &session.CreatedAt, &session.ResolvedAt)
+}
+
+// SessionSummary is a session along with how many messages it holds
+type SessionSummary struct {
+	Session
+	MessageCount int `json:"message_count"`
+}
+
+// HistoryEntry is a message in a user's merged history. SessionStart marks
+// the first message of each session so clients can draw boundaries.
+type HistoryEntry struct {
+	Message
+	SessionStart bool `json:"session_start"`
+}
+
+func (d *DB) CreateSession(userID int) (*Session, error) {
+	var session Session
+
+	err := scanSession(d.pool.QueryRow(context.Background(),
+		`INSERT INTO sessions (user_id, status) VALUES ($1, $2)
+         RETURNING `+sessionColumns,
+		userID, SessionActive), &session)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return &session, nil
+}
+
+// GetActiveSessions returns the user's active sessions, oldest first
+func (d *DB) GetActiveSessions(userID int) ([]Session, error) {
+	rows, err := d.pool.Query(context.Background(),
+		`SELECT `+sessionColumns+` FROM sessions
+         WHERE user_id = $1 AND status = $2 ORDER BY created_at, id`,
+		userID, SessionActive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		if err := scanSession(rows, &session); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// CountActiveSessions returns how many active sessions the user has
+func (d *DB) CountActiveSessions(userID int) (int, error) {
+	var count int
+	err := d.pool.QueryRow(context.Background(),
+		`SELECT COUNT(*) FROM sessions WHERE user_id = $1 AND status = $2`,
+		userID, SessionActive).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count active sessions: %w", err)
+	}
+	return count, nil
+}
+
+func (d *DB) GetSessionByID(id int) (*Session, error) {
+	var session Session
+
+	err := scanSession(d.pool.QueryRow(context.Background(),
+		`SELECT `+sessionColumns+` FROM sessions WHERE id = $1`,
+		id), &session)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get session by ID: %w", err)
+	}
+
+	return &session, nil
+}
+
+// GetSessionSummary returns the session with its message count, or nil if it
+// doesn't exist. Agents' notes are only counted when includeNotes is set.
+func (d *DB) GetSessionSummary(id int, includeNotes bool) (*SessionSummary, error) {
+	var summary SessionSummary
+
+	err := d.pool.QueryRow(context.Background(),
+		`SELECT `+sessionColumns+`, (SELECT COUNT(*) FROM messages
+             WHERE messages.session_id = sessions.id AND ($2 OR sender_type <> 'note'))
+         FROM sessions WHERE id = $1`, id, includeNotes).Scan(
+		&summary.ID, &summary.UserID, &summary.Status, &summary.AssignedTo,
+		&summary.CreatedAt, &summary.ResolvedAt, &summary.MessageCount)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get session summary: %w", err)
+	}
+
+	return &summary, nil
+}
+
+// GetActiveSession returns the user's most recent active session, or nil if there is none
+func (d *DB) GetActiveSession(userID int) (*Session, error) {
+	var session Session
+
+	err := scanSession(d.pool.QueryRow(context.Background(),
+		`SELECT `+sessionColumns+` FROM sessions
+         WHERE user_id = $1 AND status = $2 ORDER BY created_at DESC, id DESC LIMIT 1`,
+		userID, SessionActive), &session)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get active session: %w", err)
+	}
+
+	return &session, nil
+}
+
+// GetOrCreateActiveSession returns the user's active session, opening a new one if needed
+func (d *DB) GetOrCreateActiveSession(userID int) (*Session, error) {
+	session, err := d.GetActiveSession(userID)
+	if err != nil {
+		return nil, err
+	}
+	if session != nil {
+		return session, nil
+	}
+	return d.CreateSession(userID)
+}
+
+func (d *DB) ResolveSession(sessionID int) (*Session, error) {
+	var session Session
+
+	err := scanSession(d.pool.QueryRow(context.Background(),
+		`UPDATE sessions SET status = $2, resolved_at = NOW() WHERE id = $1
+         RETURNING `+sessionColumns,
+		sessionID, SessionResolved), &session)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to resolve session: %w", err)
+	}
+
+	return &session, nil
+}
+
+// AssignSession hands a session to the given agent JID
+func (d *DB) AssignSession(sessionID int, agentJID string) (*Session, error) {
+	var session Session
+
+	err := scanSession(d.pool.QueryRow(context.Background(),
+		`UPDATE sessions SET assigned_to = $2 WHERE id = $1
+         RETURNING `+sessionColumns,
+		sessionID, agentJID), &session)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to assign session: %w", err)
+	}
+
+	return &session, nil
+}
+
+// GetRecentSessionMessages returns up to limit of the session's latest messages, oldest first
+func (d *DB) GetRecentSessionMessages(sessionID, limit int) ([]Message, error) {
+	rows, err := d.pool.Query(context.Background(),
+		`SELECT `+messageColumns+` FROM (
+             SELECT * FROM messages WHERE session_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2
+         ) recent ORDER BY created_at, id`, sessionID, limit)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var msg Message
+		if err := scanMessage(rows, &msg); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// GetUserHistory returns every message across all of the user's sessions in
+// chronological order, marking where each session begins. Agents' notes are
+// left out.
+func (d *DB) GetUserHistory(userID int) ([]HistoryEntry, error) {
+	rows, err := d.pool.Query(context.Background(),
+		`SELECT `+messageColumns+` FROM messages
+         WHERE user_id = $1 AND sender_type <> 'note' ORDER BY created_at, id`, userID)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user history: %w", err)
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	return historyEntries(messages, false), nil
+}
+
+// GetUserHistoryPage returns a page of the user's merged history, counting
+// back from the newest message, and whether older messages remain
+func (d *DB) GetUserHistoryPage(userID int, page Page) ([]HistoryEntry, bool, error) {
+	// The message before the page, if any, tells whether the page's first
+	// message starts a new session, and that there is another page
+	rows, err := d.pool.Query(context.Background(),
+		`SELECT `+messageColumns+` FROM messages
+         WHERE user_id = $1 AND sender_type <> 'note'
+         ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3`, userID, page.Limit+1, page.Offset)
+
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get user history: %w", err)
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, false, err
+	}
+	slices.Reverse(messages)
+	hasMore := len(messages) > page.Limit
+	return historyEntries(messages, hasMore), hasMore, nil
+}
+
+// historyEntries marks session boundaries in chronological messages. When
+// hasPrevious is set the first message only provides context and is dropped.
+func historyEntries(messages []Message, hasPrevious bool) []HistoryEntry {
+	var history []HistoryEntry
+	var lastSessionID *int
+	for i, msg := range messages {
+		if i == 0 && hasPrevious {
+			lastSessionID = msg.SessionID
+			continue
+		}
+		entry := HistoryEntry{Message: msg}
+		entry.SessionStart = len(history) == 0 && !hasPrevious || !sameSession(lastSessionID, msg.SessionID)
+		lastSessionID = msg.SessionID
+		history = append(history, entry)
+	}
+	return history
+}
+
+func sameSession(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// AuthToken is an issued JWT, identified by its jti claim
+type AuthToken struct {
+	ID         string     `json:"id"`
+	UserID     int        `json:"-"`
+	UserAgent  string     `json:"user_agent"` // As last seen
+	IP         string     `json:"ip"`         // As last seen
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"-"`
+}
+
+// tokenTouchInterval limits how often a token's last use is written
+const tokenTouchInterval = time.Minute
+
+// authTokenColumns lists the columns scanned by scanAuthToken, in order
+const authTokenColumns = `id, user_id, user_agent, ip, created_at, last_used_at, expires_at, revoked_at`
+
+func scanAuthToken(row pgx.Row, token *AuthToken) error {
+	return row.Scan(&token.ID, &token.UserID, &token.UserAgent, &token.IP, &token.CreatedAt, &token.LastUsedAt, &token.ExpiresAt, &token.RevokedAt)
+}
+
+// CreateAuthToken records a newly issued token that expires after ttl, by
+// the database's clock like every other expiry check
+func (d *DB) CreateAuthToken(id string, userID int, ttl time.Duration) error {
+	_, err := d.pool.Exec(context.Background(),
+		`INSERT INTO auth_tokens (id, user_id, expires_at) VALUES ($1, $2, NOW() + $3::interval)`,
+		id, userID, ttl)
+	if err != nil {
+		return fmt.Errorf("failed to create auth token: %w", err)
+	}
+	return nil
+}
+
+// GetAuthToken returns a token by ID, or nil if it was never issued
+func (d *DB) GetAuthToken(id string) (*AuthToken, error) {
+	var token AuthToken
+	err := scanAuthToken(d.pool.QueryRow(context.Background(),
+		`SELECT `+authTokenColumns+` FROM auth_tokens WHERE id = $1`, id), &token)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get auth token: %w", err)
+	}
+	return &token, nil
+}
+
+// TouchAuthToken records where a token was just used from. To keep writes
+// down, nothing is written if it was already used within the last minute.
+func (d *DB) TouchAuthToken(id, userAgent, ip string) error {
+	_, err := d.pool.Exec(context.Background(),
+		`UPDATE auth_tokens SET last_used_at = NOW(), user_agent = $2, ip = $3
+         WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - $4::interval)`,
+		id, userAgent, ip, tokenTouchInterval)
+	if err != nil {
+		return fmt.Errorf("failed to touch auth token: %w", err)
+	}
+	return nil
+}
+
+// GetActiveAuthTokens returns a page of the user's unrevoked, unexpired
+// tokens, most recently issued first, and whether more remain
+func (d *DB) GetActiveAuthTokens(userID int, page Page) ([]AuthToken, bool, error) {
+	// One extra row tells whether there is another page
+	rows, err := d.pool.Query(context.Background(),
+		`SELECT `+authTokenColumns+` FROM auth_tokens
+         WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+         ORDER BY created_at DESC, id LIMIT $2 OFFSET $3`, userID, page.Limit+1, page.Offset)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get auth tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []AuthToken{}
+	for rows.Next() {
+		var token AuthToken
+		if err := scanAuthToken(rows, &token); err != nil {
+			return nil, false, fmt.Errorf("failed to scan auth token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("error iterating auth tokens: %w", err)
+	}
+
+	hasMore := len(tokens) > page.Limit
+	if hasMore {
+		tokens = tokens[:page.Limit]
+	}
+	return tokens, hasMore, nil
+}
+
+// RevokeAuthToken revokes one of the user's tokens. It reports whether an
+// active token was revoked.
+func (d *DB) RevokeAuthToken(userID int, id string) (bool, error) {
+	tag, err := d.pool.Exec(context.Background(),
+		`UPDATE auth_tokens SET revoked_at = NOW()
+         WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()`, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke auth token: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RevokeOtherAuthTokens revokes all of the user's active tokens except keepID
+// and returns how many were revoked
+func (d *DB) RevokeOtherAuthTokens(userID int, keepID string) (int, error) {
+	tag, err := d.pool.Exec(context.Background(),
+		`UPDATE auth_tokens SET revoked_at = NOW()
+         WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL AND expires_at > NOW()`, userID, keepID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke auth tokens: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// DeleteExpiredAuthTokens removes tokens past their expiry, which can no
+// longer be used either way, and returns how many were removed
+func (d *DB) DeleteExpiredAuthTokens() (int, error) {
+	tag, err := d.pool.Exec(context.Background(),
+		`DELETE FROM auth_tokens WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired auth tokens: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
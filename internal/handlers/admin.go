@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ngenohkevin/veilsupport/internal/auth"
+	"github.com/ngenohkevin/veilsupport/internal/chat"
+	"github.com/ngenohkevin/veilsupport/internal/config"
+	"github.com/ngenohkevin/veilsupport/internal/db"
+)
+
+type TransferRequest struct {
+	ToAgent string `json:"to_agent" binding:"required"`
+	Note    string `json:"note"`
+	Notify  bool   `json:"notify"`
+}
+
+type MetadataRequest struct {
+	Metadata db.Metadata `json:"metadata"`
+}
+
+type VIPRequest struct {
+	VIP *bool `json:"vip" binding:"required"`
+}
+
+// isAdmin reports whether the user has been granted admin rights. Rights
+// are looked up on each request, so revoking them takes effect at once.
+func (h *Handlers) isAdmin(userID int) bool {
+	user, err := h.auth.GetUser(userID)
+	if err != nil {
+		log.Printf("Failed to check admin rights of user %d: %v", userID, err)
+		return false
+	}
+	return user != nil && user.Admin
+}
+
+// AdminMiddleware restricts a route to admin accounts. It must run after JWTMiddleware.
+func (h *Handlers) AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !h.isAdmin(c.GetInt("user_id")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetStats returns a snapshot of service metrics
+func (h *Handlers) GetStats(c *gin.Context) {
+	metrics, err := h.chat.Metrics()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, metrics)
+}
+
+// SetConfig gives the handlers the configuration to report from GetConfig
+func (h *Handlers) SetConfig(cfg *config.Config) {
+	h.config = cfg
+}
+
+// GetConfig returns the effective configuration with secrets redacted
+func (h *Handlers) GetConfig(c *gin.Context) {
+	if h.config == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Configuration not available"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.config.Diagnostics())
+}
+
+// AdminWebSocket streams periodic metric snapshots to an admin dashboard
+func (h *Handlers) AdminWebSocket(c *gin.Context) {
+	// Get token from query parameter
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	claims, err := h.auth.ValidateToken(token)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	if !h.isAdmin(claims.UserID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade admin WebSocket: %v", err)
+		return
+	}
+
+	h.wsManager.AddAdminClient(claims.UserID, conn)
+}
+
+// TransferConversation reassigns a user's active conversation to another agent
+func (h *Handlers) TransferConversation(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req TransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	transfer, err := h.chat.TransferConversation(userID, req.ToAgent, req.Note, req.Notify)
+	if err != nil {
+		switch {
+		case errors.Is(err, chat.ErrNoActiveSession), strings.Contains(err.Error(), "user not found"):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer conversation"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, transfer)
+}
+
+// GetUser returns a user's profile, including their metadata
+func (h *Handlers) GetUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	user, err := h.auth.GetUser(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+// SetUserMetadata replaces a user's integrator metadata
+func (h *Handlers) SetUserMetadata(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req MetadataRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.auth.SetMetadata(userID, req.Metadata)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidMetadata):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case strings.Contains(err.Error(), "user not found"):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set metadata"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+// SetUserVIP marks or unmarks a user as a VIP, whose messages agents see first
+func (h *Handlers) SetUserVIP(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req VIPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.auth.SetVIP(userID, *req.VIP)
+	if err != nil {
+		if strings.Contains(err.Error(), "user not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set VIP status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+// AdminGetSession returns the status and message count of any session
+func (h *Handlers) AdminGetSession(c *gin.Context) {
+	sessionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	session, err := h.chat.GetSession(sessionID)
+	if err != nil {
+		writeSessionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"session": session})
+}
+
+// ResolveSession closes a session, sending the user a survey if configured
+func (h *Handlers) ResolveSession(c *gin.Context) {
+	sessionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	session, err := h.chat.ResolveSession(sessionID)
+	if errors.Is(err, chat.ErrSessionResolved) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeSessionError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"session": session})
+}
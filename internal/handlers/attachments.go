@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ngenohkevin/veilsupport/internal/chat"
+)
+
+// maxAttachmentBytes bounds the size of a file shared by a user
+const maxAttachmentBytes = 10 << 20
+
+// UploadAttachment shares the "file" field of a multipart form with the
+// agent, sending its download URL as a message from the caller
+func (h *Handlers) UploadAttachment(c *gin.Context) {
+	userID := c.GetInt("user_id") // From JWT middleware
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAttachmentBytes)
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file"})
+		return
+	}
+	opened, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unreadable file"})
+		return
+	}
+	defer opened.Close()
+	data, err := io.ReadAll(opened)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unreadable file"})
+		return
+	}
+
+	msg, err := h.chat.SendAttachment(c.Request.Context(), userID, filepath.Base(file.Filename), file.Header.Get("Content-Type"), data)
+	switch {
+	case errors.Is(err, chat.ErrUploadsDisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, chat.ErrQuotaExceeded) || errors.Is(err, chat.ErrRateLimited):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, chat.ErrBridgeUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to upload file"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send file"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "sent", "message": msg})
+}
@@ -1,31 +1,46 @@
 package handlers
 
 import (
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/ngenohkevin/veilsupport/internal/auth"
 	"github.com/ngenohkevin/veilsupport/internal/chat"
+	"github.com/ngenohkevin/veilsupport/internal/config"
 	"github.com/ngenohkevin/veilsupport/internal/ws"
 )
 
 type Handlers struct {
-	auth      *auth.AuthService
-	chat      *chat.ChatService
-	wsManager *ws.Manager
+	auth        *auth.AuthService
+	chat        *chat.ChatService
+	wsManager   *ws.Manager
+	pageSize    int
+	maxPageSize int
+	
+	inboundSecret      []byte // Empty disables POST /api/inbound
+	inboundCreateUsers bool
+	
+	config *config.Config // Shown, redacted, by GET /api/admin/config
 }
 
 func NewHandlers(authService *auth.AuthService, chatService *chat.ChatService, wsManager *ws.Manager) *Handlers {
 	return &Handlers{
-		auth:      authService,
-		chat:      chatService,
-		wsManager: wsManager,
+		auth:        authService,
+		chat:        chatService,
+		wsManager:   wsManager,
+		pageSize:    defaultPageSize,
+		maxPageSize: defaultMaxPage,
 	}
 }
 
+// RegisterRequest is a self-registration. Metadata can't be supplied here:
+// agents trust it, so only admins and signed integrations may set it.
 type RegisterRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required,min=8"`
@@ -38,6 +53,16 @@ type LoginRequest struct {
 
 type SendMessageRequest struct {
 	Message string `json:"message" binding:"required"`
+	TTL     int    `json:"ttl" binding:"min=0,max=31536000"` // Seconds until the message disappears; 0 keeps it
+}
+
+// EditMessageRequest replaces the content of a sent message
+type EditMessageRequest struct {
+	Message string `json:"message" binding:"required"`
+}
+
+type UpdateMeRequest struct {
+	Locale *string `json:"locale"`
 }
 
 func (h *Handlers) Register(c *gin.Context) {
@@ -49,6 +74,10 @@ func (h *Handlers) Register(c *gin.Context) {
 	}
 	
 	user, token, err := h.auth.Register(req.Email, req.Password)
+	if errors.Is(err, auth.ErrRegistrationClosed) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -95,7 +124,15 @@ func (h *Handlers) SendMessage(c *gin.Context) {
 	}
 	
 	// Use ChatService to send message (saves to DB and sends via XMPP)
-	err := h.chat.SendMessage(userID, req.Message)
+	err := h.chat.SendExpiringMessage(c.Request.Context(), userID, req.Message, time.Duration(req.TTL)*time.Second)
+	if errors.Is(err, chat.ErrEmptyMessage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, chat.ErrQuotaExceeded) || errors.Is(err, chat.ErrRateLimited) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
 		return
@@ -107,15 +144,202 @@ func (h *Handlers) SendMessage(c *gin.Context) {
 func (h *Handlers) GetHistory(c *gin.Context) {
 	userID := c.GetInt("user_id") // From JWT middleware
 	
-	messages, err := h.chat.GetUserMessages(userID)
+	page, err := h.pagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	messages, hasMore, err := h.chat.GetUserMessagesPage(userID, c.Query("sender_type"), page)
+	if errors.Is(err, chat.ErrInvalidSenderType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get history"})
 		return
 	}
 	
+	setPageHeaders(c, page, hasMore)
 	c.JSON(http.StatusOK, gin.H{"messages": messages})
 }
 
+// GetFullHistory returns messages from all of the user's sessions, with session boundaries marked
+func (h *Handlers) GetFullHistory(c *gin.Context) {
+	userID := c.GetInt("user_id") // From JWT middleware
+	
+	page, err := h.pagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	history, hasMore, err := h.chat.GetUserHistoryPage(userID, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get history"})
+		return
+	}
+	
+	setPageHeaders(c, page, hasMore)
+	c.JSON(http.StatusOK, gin.H{"messages": history})
+}
+
+// ResendMessage retries bridging one of the caller's failed messages
+func (h *Handlers) ResendMessage(c *gin.Context) {
+	userID := c.GetInt("user_id") // From JWT middleware
+	
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+	
+	msg, err := h.chat.ResendMessage(userID, messageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, chat.ErrMessageNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, chat.ErrNotResendable):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, chat.ErrBridgeUnavailable):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resend message"})
+		}
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"message": msg})
+}
+
+// EditMessage changes the content of one of the caller's messages
+func (h *Handlers) EditMessage(c *gin.Context) {
+	userID := c.GetInt("user_id") // From JWT middleware
+	
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+	
+	var req EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	msg, err := h.chat.EditMessage(userID, messageID, req.Message)
+	if err != nil {
+		switch {
+		case errors.Is(err, chat.ErrMessageNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, chat.ErrEmptyMessage):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit message"})
+		}
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"message": msg})
+}
+
+// DeleteMessage soft-deletes one of the caller's messages
+func (h *Handlers) DeleteMessage(c *gin.Context) {
+	userID := c.GetInt("user_id") // From JWT middleware
+	
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+	
+	msg, err := h.chat.DeleteMessage(userID, messageID)
+	if err != nil {
+		if errors.Is(err, chat.ErrMessageNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"message": msg})
+}
+
+// GetSession returns the status and message count of one of the caller's sessions
+func (h *Handlers) GetSession(c *gin.Context) {
+	userID := c.GetInt("user_id") // From JWT middleware
+	
+	sessionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+	
+	session, err := h.chat.GetUserSession(userID, sessionID)
+	if err != nil {
+		writeSessionError(c, err)
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"session": session})
+}
+
+// StartSession opens a new conversation for the caller
+func (h *Handlers) StartSession(c *gin.Context) {
+	userID := c.GetInt("user_id") // From JWT middleware
+	
+	session, err := h.chat.StartSession(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start session"})
+		return
+	}
+	
+	c.JSON(http.StatusCreated, gin.H{"session": session})
+}
+
+// writeSessionError maps session lookup errors to responses
+func writeSessionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, chat.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, chat.ErrSessionForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session"})
+	}
+}
+
+// UpdateMe updates the current user's preferences
+func (h *Handlers) UpdateMe(c *gin.Context) {
+	userID := c.GetInt("user_id") // From JWT middleware
+	
+	var req UpdateMeRequest
+	
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	if req.Locale == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
+	}
+	
+	user, err := h.auth.SetLocale(userID, *req.Locale)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid locale") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		}
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
 func (h *Handlers) JWTMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -141,9 +365,14 @@ func (h *Handlers) JWTMiddleware() gin.HandlerFunc {
 			return
 		}
 		
+		if err := h.auth.TouchToken(claims.ID, c.Request.UserAgent(), c.ClientIP()); err != nil {
+			log.Printf("Failed to record token use: %v", err)
+		}
+		
 		// Set user info in context
 		c.Set("user_id", claims.UserID)
 		c.Set("email", claims.Email)
+		c.Set("token_id", claims.ID)
 		c.Next()
 	}
 }
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ngenohkevin/veilsupport/internal/auth"
+)
+
+// Limits on a user import
+const (
+	maxImportRows  = 1000
+	maxImportBytes = 5 << 20
+)
+
+// ResetPasswordRequest sets a password using a token from a reset link
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+// ParseUserCSV reads users to import, one per line: email, then optionally a
+// display name and a JSON object of metadata. A first line starting with
+// "email" is treated as a header.
+func ParseUserCSV(r io.Reader) ([]auth.ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var rows []auth.ImportRow
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), "email") {
+			continue
+		}
+		if len(record) > 3 {
+			return nil, fmt.Errorf("line %d: expected at most 3 columns, got %d", line, len(record))
+		}
+
+		row := auth.ImportRow{Line: line, Email: record[0]}
+		if len(record) > 1 {
+			row.DisplayName = record[1]
+		}
+		if len(record) > 2 && strings.TrimSpace(record[2]) != "" {
+			if err := json.Unmarshal([]byte(record[2]), &row.Metadata); err != nil {
+				return nil, fmt.Errorf("line %d: metadata must be a JSON object", line)
+			}
+		}
+		rows = append(rows, row)
+
+		if len(rows) > maxImportRows {
+			return nil, fmt.Errorf("too many rows: at most %d users can be imported at once", maxImportRows)
+		}
+	}
+	return rows, nil
+}
+
+// ImportUsers pre-provisions users from a CSV, sent either as the request
+// body or as the "file" field of a multipart form. Every created user gets a
+// reset link to choose their password. The response reports each row.
+func (h *Handlers) ImportUsers(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+
+	var body io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file"})
+			return
+		}
+		opened, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unreadable file"})
+			return
+		}
+		defer opened.Close()
+		body = opened
+	}
+
+	rows, err := ParseUserCSV(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(rows) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No users to import"})
+		return
+	}
+
+	results, err := h.auth.ImportUsers(rows)
+	if errors.Is(err, auth.ErrRegistrationClosed) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Import failed, no users were created"})
+		return
+	}
+
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Status]++
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"created":    counts[auth.ImportCreated],
+		"duplicates": counts[auth.ImportDuplicate],
+		"invalid":    counts[auth.ImportInvalid],
+		"results":    results,
+	})
+}
+
+// ResetPassword sets a new password from a reset link and logs the user in
+func (h *Handlers) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, token, err := h.auth.ResetPassword(req.Token, req.Password)
+	if errors.Is(err, auth.ErrInvalidResetToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password reset failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":  user,
+		"token": token,
+	})
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/ngenohkevin/veilsupport/internal/auth"
+	"github.com/ngenohkevin/veilsupport/internal/chat"
+	"github.com/ngenohkevin/veilsupport/internal/db"
+)
+
+// InboundSignatureHeader carries the hex HMAC-SHA256 of the timestamp, a
+// ".", and the request body, keyed with the inbound secret and prefixed with
+// "sha256="
+const InboundSignatureHeader = "X-Signature-256"
+
+// InboundTimestampHeader carries the Unix time, in seconds, the request was
+// signed at
+const InboundTimestampHeader = "X-Signature-Timestamp"
+
+// InboundMaxSkew is how far a request's timestamp may be from the current
+// time, limiting how long a captured request can be replayed
+const InboundMaxSkew = 5 * time.Minute
+
+// maxInboundBody bounds how much of an inbound request is read
+const maxInboundBody = 1 << 20
+
+// InboundRequest is a message from an external channel such as email
+type InboundRequest struct {
+	UserEmail string `json:"user_email" binding:"required,email"`
+	Content   string `json:"content" binding:"required"`
+	Source    string `json:"source" binding:"required"` // e.g. "email"
+}
+
+// SetInboundWebhook enables POST /api/inbound, verifying requests with the
+// given secret. When createUsers is set, messages for unknown emails register
+// a new user instead of being rejected.
+func (h *Handlers) SetInboundWebhook(secret string, createUsers bool) {
+	h.inboundSecret = []byte(secret)
+	h.inboundCreateUsers = createUsers
+}
+
+// SignInbound returns the signature header value for an inbound request
+// body sent with the given timestamp header value
+func SignInbound(secret, timestamp string, body []byte) string {
+	return "sha256=" + hex.EncodeToString(inboundMAC([]byte(secret), timestamp, body))
+}
+
+func inboundMAC(secret []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// validInboundSignature checks the request's signature against its
+// timestamp and body, and that the timestamp is recent
+func (h *Handlers) validInboundSignature(signature, timestamp string, body []byte) bool {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := time.Since(time.Unix(seconds, 0))
+	if skew > InboundMaxSkew || skew < -InboundMaxSkew {
+		return false
+	}
+
+	hexSum, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	sum, err := hex.DecodeString(hexSum)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(sum, inboundMAC(h.inboundSecret, timestamp, body))
+}
+
+// Inbound injects a message from an external channel into a user's
+// conversation as if they had sent it
+func (h *Handlers) Inbound(c *gin.Context) {
+	if len(h.inboundSecret) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inbound messages are not enabled"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxInboundBody))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request"})
+		return
+	}
+	if !h.validInboundSignature(c.GetHeader(InboundSignatureHeader), c.GetHeader(InboundTimestampHeader), body) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return
+	}
+
+	var req InboundRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, created, err := h.auth.FindOrCreateUser(req.UserEmail, h.inboundCreateUsers, db.Metadata{"source": req.Source})
+	switch {
+	case errors.Is(err, auth.ErrUnknownUser):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, auth.ErrRegistrationClosed):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find user"})
+		return
+	}
+
+	err = h.chat.SendMessage(c.Request.Context(), user.ID, req.Content)
+	if errors.Is(err, chat.ErrEmptyMessage) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, chat.ErrQuotaExceeded) || errors.Is(err, chat.ErrRateLimited) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		return
+	}
+	log.Printf("Inbound %s message accepted for user %d", req.Source, user.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":       "sent",
+		"user_id":      user.ID,
+		"user_created": created,
+	})
+}
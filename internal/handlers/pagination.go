@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ngenohkevin/veilsupport/internal/db"
+)
+
+// Page size defaults, overridable with SetPageSizes
+const (
+	defaultPageSize = 50
+	defaultMaxPage  = 200
+)
+
+// ParsePagination reads the limit and offset query parameters. A missing
+// limit falls back to defaultSize and one above maxSize is clamped to it.
+// Non-numeric values, negative ones and a zero limit are rejected.
+func ParsePagination(c *gin.Context, defaultSize, maxSize int) (db.Page, error) {
+	page := db.Page{Limit: defaultSize}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return db.Page{}, fmt.Errorf("invalid limit: %q", value)
+		}
+		page.Limit = limit
+	}
+	if page.Limit > maxSize {
+		page.Limit = maxSize
+	}
+
+	if value := c.Query("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return db.Page{}, fmt.Errorf("invalid offset: %q", value)
+		}
+		page.Offset = offset
+	}
+
+	return page, nil
+}
+
+// setPageHeaders tells the client which page it got, since the limit may
+// have been defaulted or clamped, and whether there is another one
+func setPageHeaders(c *gin.Context, page db.Page, hasMore bool) {
+	c.Header("X-Page-Limit", strconv.Itoa(page.Limit))
+	c.Header("X-Page-Offset", strconv.Itoa(page.Offset))
+	c.Header("X-Page-Has-More", strconv.FormatBool(hasMore))
+}
+
+// SetPageSizes configures the page size used when a list request doesn't
+// give one, and the largest it may ask for
+func (h *Handlers) SetPageSizes(defaultSize, maxSize int) {
+	if maxSize < 1 {
+		maxSize = defaultMaxPage
+	}
+	if defaultSize < 1 {
+		defaultSize = defaultPageSize
+	}
+	if defaultSize > maxSize {
+		defaultSize = maxSize
+	}
+	h.pageSize = defaultSize
+	h.maxPageSize = maxSize
+}
+
+// pagination parses the request's pagination using the configured sizes
+func (h *Handlers) pagination(c *gin.Context) (db.Page, error) {
+	return ParsePagination(c, h.pageSize, h.maxPageSize)
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ngenohkevin/veilsupport/internal/auth"
+	"github.com/ngenohkevin/veilsupport/internal/db"
+)
+
+// loginSession is one of the caller's active tokens, as listed to them
+type loginSession struct {
+	db.AuthToken
+	Current bool `json:"current"` // The token making the request
+}
+
+// ListMySessions lists where the caller is logged in: one entry per active token
+func (h *Handlers) ListMySessions(c *gin.Context) {
+	userID := c.GetInt("user_id") // From JWT middleware
+	current := c.GetString("token_id")
+
+	page, err := h.pagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, hasMore, err := h.auth.ListTokens(userID, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+
+	sessions := make([]loginSession, 0, len(tokens))
+	for _, token := range tokens {
+		sessions = append(sessions, loginSession{AuthToken: token, Current: token.ID == current})
+	}
+	setPageHeaders(c, page, hasMore)
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeMySession logs the caller out of one session. Revoking the current
+// one works too and logs out the caller.
+func (h *Handlers) RevokeMySession(c *gin.Context) {
+	userID := c.GetInt("user_id") // From JWT middleware
+
+	if err := h.auth.RevokeToken(userID, c.Param("id")); err != nil {
+		if errors.Is(err, auth.ErrTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revoked": 1})
+}
+
+// RevokeOtherSessions logs the caller out everywhere except the current session
+func (h *Handlers) RevokeOtherSessions(c *gin.Context) {
+	userID := c.GetInt("user_id") // From JWT middleware
+
+	revoked, err := h.auth.RevokeOtherTokens(userID, c.GetString("token_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
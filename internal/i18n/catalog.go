@@ -0,0 +1,131 @@
+package i18n
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Keys for system messages sent to users
+const (
+	KeyWelcome = "welcome"
+	KeySurvey  = "survey" // Takes the survey URL
+)
+
+// builtin holds the translations shipped with VeilSupport
+var builtin = map[string]map[string]string{
+	"en": {
+		KeyWelcome: "Welcome to VeilSupport! An agent will be with you shortly.",
+		KeySurvey:  "Thanks for contacting us! How did we do? %s",
+	},
+	"es": {
+		KeyWelcome: "¡Bienvenido a VeilSupport! Un agente te atenderá en breve.",
+		KeySurvey:  "¡Gracias por contactarnos! ¿Qué tal lo hicimos? %s",
+	},
+	"fr": {
+		KeyWelcome: "Bienvenue sur VeilSupport ! Un agent va vous répondre sous peu.",
+		KeySurvey:  "Merci de nous avoir contactés ! Qu'avez-vous pensé de notre aide ? %s",
+	},
+	"de": {
+		KeyWelcome: "Willkommen bei VeilSupport! Ein Mitarbeiter ist gleich für Sie da.",
+		KeySurvey:  "Danke für Ihre Anfrage! Wie zufrieden waren Sie mit uns? %s",
+	},
+}
+
+// Catalog renders system messages in a user's locale, falling back to the
+// default locale when a translation is missing
+type Catalog struct {
+	defaultLocale string
+	messages      map[string]map[string]string // locale -> key -> text
+	mu            sync.RWMutex
+}
+
+// NewCatalog creates a catalog with the built-in translations
+func NewCatalog(defaultLocale string) *Catalog {
+	c := &Catalog{
+		defaultLocale: Normalize(defaultLocale),
+		messages:      make(map[string]map[string]string),
+	}
+	if c.defaultLocale == "" {
+		c.defaultLocale = "en"
+	}
+
+	for locale, messages := range builtin {
+		for key, text := range messages {
+			c.Add(locale, key, text)
+		}
+	}
+	return c
+}
+
+// DefaultLocale returns the locale used when a user has no preference
+func (c *Catalog) DefaultLocale() string {
+	return c.defaultLocale
+}
+
+// Add registers or replaces a translation
+func (c *Catalog) Add(locale, key, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	locale = Normalize(locale)
+	if c.messages[locale] == nil {
+		c.messages[locale] = make(map[string]string)
+	}
+	c.messages[locale][key] = text
+}
+
+// Render returns the message for key in the given locale. It tries the exact
+// locale, then its base language ("pt-br" -> "pt"), then the default locale,
+// and finally returns the key itself. Args are applied with fmt.Sprintf.
+func (c *Catalog) Render(locale, key string, args ...interface{}) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, candidate := range c.candidates(locale) {
+		if text, ok := c.messages[candidate][key]; ok {
+			if len(args) > 0 {
+				return fmt.Sprintf(text, args...)
+			}
+			return text
+		}
+	}
+	return key
+}
+
+func (c *Catalog) candidates(locale string) []string {
+	locale = Normalize(locale)
+
+	var candidates []string
+	if locale != "" {
+		candidates = append(candidates, locale)
+		if base, _, found := strings.Cut(locale, "-"); found {
+			candidates = append(candidates, base)
+		}
+	}
+	return append(candidates, c.defaultLocale)
+}
+
+// Normalize lowercases a locale and uses "-" as the separator ("pt_BR" -> "pt-br")
+func Normalize(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+// Valid reports whether locale looks like a language tag such as "en" or "pt-BR"
+func Valid(locale string) bool {
+	parts := strings.Split(Normalize(locale), "-")
+	if len(parts[0]) < 2 || len(parts[0]) > 3 {
+		return false
+	}
+	for i, part := range parts {
+		if i > 0 && (len(part) < 2 || len(part) > 8) {
+			return false
+		}
+		for _, r := range part {
+			if !(r >= 'a' && r <= 'z') && !(i > 0 && r >= '0' && r <= '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package redact
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Mode selects how much of a message body is written to logs
+type Mode string
+
+const (
+	ModeRedact   Mode = "redact"   // Log only the body's length
+	ModeTruncate Mode = "truncate" // Log the first few characters
+	ModeFull     Mode = "full"     // Log the whole body, for local debugging
+)
+
+// truncateLength is how many characters ModeTruncate keeps
+const truncateLength = 20
+
+// mode is shared by every package that logs message bodies
+var mode atomic.Value
+
+func init() {
+	mode.Store(ModeRedact)
+}
+
+// ParseMode converts a configuration value to a Mode. Empty means redact.
+func ParseMode(value string) (Mode, error) {
+	switch Mode(strings.ToLower(strings.TrimSpace(value))) {
+	case "", ModeRedact:
+		return ModeRedact, nil
+	case ModeTruncate:
+		return ModeTruncate, nil
+	case ModeFull:
+		return ModeFull, nil
+	}
+	return ModeRedact, fmt.Errorf("unknown log redaction mode: %s", value)
+}
+
+// SetMode sets how message bodies are logged from now on
+func SetMode(m Mode) {
+	mode.Store(m)
+}
+
+// Body returns a message body as it should appear in logs
+func Body(body string) string {
+	length := utf8.RuneCountInString(body)
+	switch mode.Load().(Mode) {
+	case ModeFull:
+		return body
+	case ModeTruncate:
+		if length <= truncateLength {
+			return body
+		}
+		return fmt.Sprintf("%s… (%d chars)", string([]rune(body)[:truncateLength]), length)
+	}
+	return fmt.Sprintf("[redacted, %d chars]", length)
+}
@@ -0,0 +1,47 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Policy bounds how an operation is retried
+type Policy struct {
+	Attempts int           // Total attempts; less than 1 means 1
+	Backoff  time.Duration // Delay before the first retry, doubled for each one after
+	Timeout  time.Duration // Per attempt; 0 leaves attempts bounded only by ctx
+}
+
+// Do runs attempt until it succeeds, reports that a failure isn't worth
+// retrying, the attempts run out or ctx is cancelled. Errors are prefixed
+// with step, e.g. "upload failed after 3 attempt(s): ...".
+func Do(ctx context.Context, p Policy, step string, attempt func(ctx context.Context) (retry bool, err error)) error {
+	delay := p.Backoff
+	for n := 1; ; n++ {
+		retry, err := run(ctx, p.Timeout, attempt)
+		if err == nil {
+			return nil
+		}
+		if !retry || n >= p.Attempts {
+			return fmt.Errorf("%s failed after %d attempt(s): %w", step, n, err)
+		}
+
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-ctx.Done():
+			return fmt.Errorf("%s cancelled: %w", step, ctx.Err())
+		}
+	}
+}
+
+// run makes one attempt, with its own deadline when timeout is set
+func run(ctx context.Context, timeout time.Duration, attempt func(ctx context.Context) (bool, error)) (bool, error) {
+	if timeout <= 0 {
+		return attempt(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return attempt(ctx)
+}
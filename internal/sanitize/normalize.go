@@ -0,0 +1,26 @@
+package sanitize
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Three or more line breaks, i.e. more than one blank line in a row
+var blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+
+// Normalize tidies whitespace for display: line endings become "\n",
+// trailing whitespace is removed from each line, runs of blank lines are
+// collapsed into one and the message is trimmed. Indentation is kept.
+func Normalize(content string) string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.ReplaceAll(content, "\r", "\n")
+
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\f\v")
+	}
+	content = strings.Join(lines, "\n")
+
+	content = blankLinesPattern.ReplaceAllString(content, "\n\n")
+	return strings.TrimSpace(content)
+}
@@ -0,0 +1,62 @@
+package sanitize
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Mode selects how HTML in message content is neutralized. Both escape and
+// strip produce text for HTML clients, with "&" escaped too; plain-text
+// clients such as XMPP ones are better served by off, the default.
+type Mode string
+
+const (
+	ModeOff    Mode = "off"    // Store content as-is
+	ModeEscape Mode = "escape" // Escape markup characters so markup renders as text
+	ModeStrip  Mode = "strip"  // Remove tags, dropping script/style blocks entirely
+)
+
+var (
+	// Script-like blocks are removed together with their contents
+	blockPattern   = regexp.MustCompile(`(?is)<(script|style|iframe|object|embed)\b[^>]*>.*?</(script|style|iframe|object|embed)\s*>`)
+	commentPattern = regexp.MustCompile(`(?s)<!--.*?-->`)
+	// Only things that look like real tags, so "1 < 2" is left alone
+	tagPattern = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	// An unterminated tag opener left behind after stripping
+	openerPattern = regexp.MustCompile(`<([a-zA-Z!/?])`)
+
+	escaper    = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	ampEscaper = strings.NewReplacer("&", "&amp;")
+)
+
+// ParseMode converts a configuration value to a Mode. Empty means off.
+func ParseMode(value string) (Mode, error) {
+	switch Mode(strings.ToLower(strings.TrimSpace(value))) {
+	case "", ModeOff:
+		return ModeOff, nil
+	case ModeEscape:
+		return ModeEscape, nil
+	case ModeStrip:
+		return ModeStrip, nil
+	}
+	return ModeOff, fmt.Errorf("unknown sanitize mode: %s", value)
+}
+
+// Content neutralizes HTML in content according to mode. Off returns it
+// unchanged.
+func Content(mode Mode, content string) string {
+	switch mode {
+	case ModeEscape:
+		return escaper.Replace(content)
+	case ModeStrip:
+		content = blockPattern.ReplaceAllString(content, "")
+		content = commentPattern.ReplaceAllString(content, "")
+		content = tagPattern.ReplaceAllString(content, "")
+		// Anything left that could still open a tag is escaped, after any
+		// "&" so the result can't be mistaken for an entity the user wrote
+		content = ampEscaper.Replace(content)
+		return openerPattern.ReplaceAllString(content, "&lt;$1")
+	}
+	return content
+}
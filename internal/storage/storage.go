@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotStored is returned when deleting a URL this backend didn't store,
+// such as a link to an admin's XMPP upload server
+var ErrNotStored = errors.New("file is not in this storage")
+
+// Backend stores uploaded files and hands out the URLs they are served from
+type Backend interface {
+	Save(name string, data []byte) (string, error)
+	Delete(url string) error
+}
+
+// Local stores files in a directory on disk, served under a URL prefix
+type Local struct {
+	dir       string
+	urlPrefix string
+}
+
+// NewLocal creates a backend that writes to dir and serves files as urlPrefix+name
+func NewLocal(dir, urlPrefix string) *Local {
+	return &Local{dir: dir, urlPrefix: urlPrefix}
+}
+
+// Save writes the file and returns its URL
+func (l *Local) Save(name string, data []byte) (string, error) {
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(l.dir, name), data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	return l.urlPrefix + name, nil
+}
+
+// Delete removes the file behind url. A file that is already gone isn't an error.
+func (l *Local) Delete(url string) error {
+	name, ok := strings.CutPrefix(url, l.urlPrefix)
+	// Never let a URL point outside the directory
+	if !ok || name == "" || name != filepath.Base(name) || name == ".." {
+		return fmt.Errorf("%w: %s", ErrNotStored, url)
+	}
+
+	if err := os.Remove(filepath.Join(l.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
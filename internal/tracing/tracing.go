@@ -0,0 +1,97 @@
+// Package tracing records OpenTelemetry spans along the request path, from
+// the HTTP handler through the chat service to database writes and XMPP
+// sends. Until Setup installs an exporter every span is a no-op.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporter selects where spans are sent
+type Exporter string
+
+const (
+	ExporterNone   Exporter = "none"   // Spans aren't recorded
+	ExporterStdout Exporter = "stdout" // Written to stdout, for local debugging
+	ExporterOTLP   Exporter = "otlp"   // Sent over OTLP/HTTP, set up by the standard OTEL_EXPORTER_OTLP_* variables
+)
+
+// instrumentationName identifies the spans this service records
+const instrumentationName = "github.com/ngenohkevin/veilsupport"
+
+// ParseExporter converts a configuration value to an Exporter. Empty means none.
+func ParseExporter(value string) (Exporter, error) {
+	switch Exporter(strings.ToLower(strings.TrimSpace(value))) {
+	case "", ExporterNone:
+		return ExporterNone, nil
+	case ExporterStdout:
+		return ExporterStdout, nil
+	case ExporterOTLP:
+		return ExporterOTLP, nil
+	}
+	return ExporterNone, fmt.Errorf("unknown tracing exporter: %s", value)
+}
+
+// Setup installs a global tracer provider that sends spans to exporter and
+// propagates trace context in W3C headers. The returned function flushes
+// any buffered spans and should be called on shutdown. With ExporterNone
+// nothing is installed and spans stay no-ops.
+func Setup(ctx context.Context, exporter Exporter) (func(context.Context) error, error) {
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	default:
+		return func(context.Context) error { return nil }, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", exporter, err)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "veilsupport")),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Start starts a span that is a child of any span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends a span, marking it failed when err is set
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
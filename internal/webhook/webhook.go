@@ -0,0 +1,106 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ngenohkevin/veilsupport/internal/retry"
+)
+
+// Event is the JSON body POSTed to the webhook
+type Event struct {
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// Client delivers events to a single webhook URL, retrying failed attempts
+// with exponential backoff
+type Client struct {
+	url         string
+	httpClient  *http.Client
+	maxAttempts int
+	backoff     time.Duration // Delay before the first retry, doubled for each one after
+	wg          sync.WaitGroup
+}
+
+// New creates a client for the given URL with 3 attempts and a 1s initial backoff
+func New(url string) *Client {
+	return &Client{
+		url:         url,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		maxAttempts: 3,
+		backoff:     time.Second,
+	}
+}
+
+// SetRetries sets how many times delivery is attempted and the delay before
+// the first retry
+func (c *Client) SetRetries(maxAttempts int, backoff time.Duration) {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	c.maxAttempts = maxAttempts
+	c.backoff = backoff
+}
+
+// Send delivers an event, retrying until it is accepted, the attempts run out
+// or ctx is cancelled. Client errors other than 429 aren't retried.
+func (c *Client) Send(ctx context.Context, eventType string, data interface{}) error {
+	body, err := json.Marshal(Event{
+		Type:      eventType,
+		Timestamp: time.Now(),
+		Data:      data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook event: %w", err)
+	}
+
+	policy := retry.Policy{Attempts: c.maxAttempts, Backoff: c.backoff}
+	return retry.Do(ctx, policy, "webhook delivery", func(ctx context.Context) (bool, error) {
+		return c.post(ctx, body)
+	})
+}
+
+// SendAsync delivers an event in the background, logging if it fails
+func (c *Client) SendAsync(eventType string, data interface{}) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		if err := c.Send(context.Background(), eventType, data); err != nil {
+			log.Printf("Webhook %s: %v", eventType, err)
+		}
+	}()
+}
+
+// Wait blocks until all background deliveries have finished
+func (c *Client) Wait() {
+	c.wg.Wait()
+}
+
+// post makes one delivery attempt and reports whether a failure is worth retrying
+func (c *Client) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("unexpected status %d", resp.StatusCode)
+}
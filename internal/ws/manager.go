@@ -1,17 +1,24 @@
 package ws
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 type Manager struct {
-	clients map[int]*Client // userID -> client
-	mu      sync.RWMutex
+	clients     map[int]*Client // userID -> client
+	admins      map[int]*Client // userID -> admin dashboard client
+	idleTimeout time.Duration   // 0 disables idle reaping
+	reaped      atomic.Int64
+	offline     offlineQueue // Messages for users who aren't connected
+	onDelivered DeliveryHandler // nil unless delivery receipts are on
+	mu          sync.RWMutex
 }
 
 type Client struct {
@@ -19,15 +26,29 @@ type Client struct {
 	conn   *websocket.Conn
 	send   chan []byte
 	manager *Manager
+	admin   bool
+	flushing bool // Still being sent its offline queue; guarded by manager.mu
+	lastActivity atomic.Int64 // Unix nanoseconds of the last message from the peer
 }
 
 func NewManager() *Manager {
 	return &Manager{
 		clients: make(map[int]*Client),
+		admins:  make(map[int]*Client),
+		offline: newOfflineQueue(),
 	}
 }
 
 func (m *Manager) AddClient(userID int, conn *websocket.Conn) {
+	m.addClient(userID, conn, false)
+}
+
+// AddAdminClient registers an admin dashboard connection that receives admin broadcasts
+func (m *Manager) AddAdminClient(userID int, conn *websocket.Conn) {
+	m.addClient(userID, conn, true)
+}
+
+func (m *Manager) addClient(userID int, conn *websocket.Conn, admin bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
@@ -36,9 +57,19 @@ func (m *Manager) AddClient(userID int, conn *websocket.Conn) {
 		conn:    conn,
 		send:    make(chan []byte, 256),
 		manager: m,
+		admin:   admin,
 	}
+	client.touch()
 	
-	m.clients[userID] = client
+	clients := m.clients
+	if admin {
+		clients = m.admins
+	}
+	// A newer connection replaces the old one, which is closed so its pumps stop
+	if old, ok := clients[userID]; ok {
+		m.removeClientLocked(old)
+	}
+	clients[userID] = client
 	go client.writePump()
 	go client.readPump()
 	
@@ -48,31 +79,98 @@ func (m *Manager) AddClient(userID int, conn *websocket.Conn) {
 	}
 	data, _ := json.Marshal(confirmMsg)
 	client.send <- data
+	
+	// Anything an earlier connection didn't acknowledge is sent again
+	if !admin {
+		m.offline.resend(userID)
+	}
+	if !admin && m.offline.unsent(userID) {
+		client.flushing = true
+		go m.flushOffline(client)
+	}
 }
 
 func (m *Manager) RemoveClient(userID int) {
+	m.mu.RLock()
+	client, ok := m.clients[userID]
+	m.mu.RUnlock()
+	
+	if ok {
+		m.removeClient(client)
+	}
+}
+
+// removeClient closes and forgets the given client, unless it has already
+// been replaced by a newer connection for the same user. It reports whether
+// the client was removed.
+func (m *Manager) removeClient(client *Client) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.removeClientLocked(client)
+}
+
+// removeClientLocked is removeClient for callers already holding m.mu
+func (m *Manager) removeClientLocked(client *Client) bool {
+	clients := m.clients
+	if client.admin {
+		clients = m.admins
+	}
 	
-	if client, ok := m.clients[userID]; ok {
+	if current, ok := clients[client.userID]; ok && current == client {
 		close(client.send)
 		client.conn.Close()
-		delete(m.clients, userID)
+		delete(clients, client.userID)
+		return true
 	}
+	return false
 }
 
+// SendToUser sends a message to the user's connection. Messages for users
+// who aren't connected, or are still catching up, are queued when an offline
+// queue is enabled (see SetOfflineQueue) and dropped otherwise. With delivery
+// receipts on, stored messages also stay queued until the client
+// acknowledges them.
 func (m *Manager) SendToUser(userID int, message []byte) {
-	m.mu.RLock()
+	m.mu.Lock()
+	queued := queuedMessage{data: message}
+	if m.onDelivered != nil {
+		queued.id = receiptID(message)
+	}
+	
 	client, ok := m.clients[userID]
-	m.mu.RUnlock()
+	if !ok || client.flushing {
+		m.offline.push(userID, queued)
+		m.mu.Unlock()
+		return
+	}
+	if queued.id != 0 {
+		queued.sent = true
+		m.offline.push(userID, queued)
+	}
+	m.trySend(client, message)
+	m.mu.Unlock()
+}
+
+// BroadcastToAdmins sends a message to every connected admin dashboard
+func (m *Manager) BroadcastToAdmins(message []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	
-	if ok {
-		select {
-		case client.send <- message:
-		default:
-			// Client buffer full, close
-			m.RemoveClient(userID)
-		}
+	for _, client := range m.admins {
+		m.trySend(client, message)
+	}
+}
+
+// trySend hands a message to the client's write pump without blocking,
+// closing the client if its buffer is full. The caller must hold m.mu:
+// removeClient closes the send channel under the same lock, so holding it
+// is what keeps this from sending on a closed channel.
+func (m *Manager) trySend(client *Client, message []byte) {
+	select {
+	case client.send <- message:
+	default:
+		// Client buffer full, close
+		m.removeClientLocked(client)
 	}
 }
 
@@ -82,6 +180,80 @@ func (m *Manager) GetClientCount() int {
 	return len(m.clients)
 }
 
+// GetAdminCount returns the number of connected admin dashboards
+func (m *Manager) GetAdminCount() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.admins)
+}
+
+// SetIdleTimeout sets how long a client may go without sending anything
+// before it is reaped. Pongs don't count as activity. 0 disables reaping.
+func (m *Manager) SetIdleTimeout(timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.idleTimeout = timeout
+}
+
+// GetReapedCount returns how many idle connections have been reaped
+func (m *Manager) GetReapedCount() int {
+	return int(m.reaped.Load())
+}
+
+// ReapIdle closes every connection that has been idle longer than the idle
+// timeout and returns how many were closed
+func (m *Manager) ReapIdle() int {
+	m.mu.RLock()
+	timeout := m.idleTimeout
+	var idle []*Client
+	if timeout > 0 {
+		cutoff := time.Now().Add(-timeout).UnixNano()
+		for _, clients := range []map[int]*Client{m.clients, m.admins} {
+			for _, client := range clients {
+				if client.lastActivity.Load() < cutoff {
+					idle = append(idle, client)
+				}
+			}
+		}
+	}
+	m.mu.RUnlock()
+	
+	reaped := 0
+	for _, client := range idle {
+		if m.removeClient(client) {
+			log.Printf("Reaped idle WebSocket connection for user %d", client.userID)
+			reaped++
+		}
+	}
+	
+	m.reaped.Add(int64(reaped))
+	return reaped
+}
+
+// StartReaper periodically reaps idle connections until ctx is cancelled.
+// It returns immediately if no idle timeout is set.
+func (m *Manager) StartReaper(ctx context.Context) {
+	m.mu.RLock()
+	timeout := m.idleTimeout
+	m.mu.RUnlock()
+	if timeout <= 0 {
+		return
+	}
+	
+	// Check twice per timeout so a client is never kept much past it
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-ticker.C:
+			m.ReapIdle()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 const (
 	// Time allowed to write a message to the peer.
 	writeWait = 10 * time.Second
@@ -98,7 +270,7 @@ const (
 
 func (c *Client) readPump() {
 	defer func() {
-		c.manager.RemoveClient(c.userID)
+		c.manager.removeClient(c)
 		c.conn.Close()
 	}()
 	
@@ -110,16 +282,25 @@ func (c *Client) readPump() {
 	})
 	
 	for {
-		_, _, err := c.conn.ReadMessage()
+		_, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("error: %v", err)
 			}
 			break
 		}
+		c.touch()
+		if !c.admin {
+			c.manager.receive(c, message)
+		}
 	}
 }
 
+// touch records activity from the peer
+func (c *Client) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
 func (c *Client) writePump() {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
@@ -0,0 +1,178 @@
+package ws
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Defaults for flushing an offline queue when its user reconnects
+const (
+	DefaultFlushBatch = 20
+	DefaultFlushDelay = 50 * time.Millisecond
+)
+
+// offlineQueue holds messages for users who aren't connected, oldest first.
+// With delivery receipts on it also holds messages that were sent but not
+// yet acknowledged. It is guarded by the manager's mutex.
+type offlineQueue struct {
+	messages map[int][]queuedMessage // userID -> queued messages
+	limit    int                     // Per user; 0 disables queueing
+	batch    int                     // Messages sent at a time when flushing
+	delay    time.Duration           // Pause between batches
+}
+
+// queuedMessage is a message waiting in a user's queue
+type queuedMessage struct {
+	data []byte
+	id   int  // Stored message ID awaiting a receipt; 0 if none is expected
+	sent bool // Written to the current connection, waiting for its receipt
+}
+
+func newOfflineQueue() offlineQueue {
+	return offlineQueue{
+		messages: make(map[int][]queuedMessage),
+		batch:    DefaultFlushBatch,
+		delay:    DefaultFlushDelay,
+	}
+}
+
+// push queues a message, dropping the user's oldest once they have limit
+func (q *offlineQueue) push(userID int, message queuedMessage) {
+	if q.limit <= 0 {
+		return
+	}
+
+	queue := append(q.messages[userID], message)
+	if len(queue) > q.limit {
+		queue = queue[len(queue)-q.limit:]
+	}
+	q.messages[userID] = queue
+}
+
+// unsent reports whether any of the user's messages still need sending
+func (q *offlineQueue) unsent(userID int) bool {
+	for _, message := range q.messages[userID] {
+		if !message.sent {
+			return true
+		}
+	}
+	return false
+}
+
+// acknowledge removes the message with the given ID from the user's queue
+func (q *offlineQueue) acknowledge(userID, messageID int) {
+	queue := q.messages[userID]
+	for i, message := range queue {
+		if message.id == messageID {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) == 0 {
+		delete(q.messages, userID)
+	} else {
+		q.messages[userID] = queue
+	}
+}
+
+// resend marks every message in the user's queue as unsent, so messages the
+// last connection never acknowledged are sent again on the next one
+func (q *offlineQueue) resend(userID int) {
+	for i := range q.messages[userID] {
+		q.messages[userID][i].sent = false
+	}
+}
+
+// receiptTypes are the frames carrying a stored message that clients
+// acknowledge with a delivery receipt
+var receiptTypes = map[string]bool{"message": true, "system": true}
+
+// receiptID returns the stored message ID a client will acknowledge
+// receiving message with, or 0 if it isn't expected to
+func receiptID(message []byte) int {
+	var frame struct {
+		Type      string `json:"type"`
+		MessageID int    `json:"message_id"`
+	}
+	if json.Unmarshal(message, &frame) != nil || !receiptTypes[frame.Type] {
+		return 0
+	}
+	return frame.MessageID
+}
+
+// SetOfflineQueue keeps up to limit messages for each user who isn't
+// connected. When they reconnect the queue is sent batch messages at a time
+// with delay between batches, so a long absence doesn't flood the client.
+// Messages sent meanwhile wait their turn, keeping everything in order.
+// A limit of 0 disables queueing; a batch of 0 uses DefaultFlushBatch.
+func (m *Manager) SetOfflineQueue(limit, batch int, delay time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if batch <= 0 {
+		batch = DefaultFlushBatch
+	}
+	m.offline.limit = limit
+	m.offline.batch = batch
+	m.offline.delay = delay
+	if limit <= 0 {
+		m.offline.messages = make(map[int][]queuedMessage)
+	}
+}
+
+// GetQueuedCount returns how many messages are waiting for the user,
+// including any sent but not yet acknowledged
+func (m *Manager) GetQueuedCount(userID int) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.offline.messages[userID])
+}
+
+// flushOffline sends the client its queued messages in batches. Until none
+// are left unsent the client is marked flushing, so SendToUser queues behind
+// it rather than jumping ahead. Messages awaiting a receipt stay queued once
+// sent; the rest are removed.
+func (m *Manager) flushOffline(c *Client) {
+	for {
+		m.mu.Lock()
+		if m.clients[c.userID] != c {
+			// Disconnected or replaced; the rest waits for the next connection
+			m.mu.Unlock()
+			return
+		}
+
+		// Nothing else writes to a flushing client, so this never blocks
+		room := min(m.offline.batch, cap(c.send)-len(c.send))
+		var kept []queuedMessage
+		remaining := 0
+		for _, message := range m.offline.messages[c.userID] {
+			if !message.sent && room > 0 {
+				c.send <- message.data
+				room--
+				if message.id == 0 {
+					continue
+				}
+				message.sent = true
+			}
+			if !message.sent {
+				remaining++
+			}
+			kept = append(kept, message)
+		}
+
+		if len(kept) == 0 {
+			delete(m.offline.messages, c.userID)
+		} else {
+			m.offline.messages[c.userID] = kept
+		}
+		if remaining == 0 {
+			c.flushing = false
+			m.mu.Unlock()
+			return
+		}
+		delay := m.offline.delay
+		m.mu.Unlock()
+
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,47 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// DeliveryHandler records that a user's client acknowledged receiving a
+// stored message
+type DeliveryHandler func(userID, messageID int) error
+
+// SetDeliveryReceipts asks clients to acknowledge each "message" and "system"
+// frame by sending {"type":"delivered","message_id":...} once they've
+// processed it. Acknowledged messages leave the user's queue and are passed
+// to handler; unacknowledged ones are sent again when the user reconnects,
+// as long as an offline queue is enabled. A nil handler turns receipts off.
+func (m *Manager) SetDeliveryReceipts(handler DeliveryHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onDelivered = handler
+}
+
+// receive handles a frame sent by a user's client. Anything other than a
+// delivery receipt is ignored.
+func (m *Manager) receive(c *Client, message []byte) {
+	var frame struct {
+		Type      string `json:"type"`
+		MessageID int    `json:"message_id"`
+	}
+	if json.Unmarshal(message, &frame) != nil || frame.Type != "delivered" || frame.MessageID <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	handler := m.onDelivered
+	if handler != nil {
+		m.offline.acknowledge(c.userID, frame.MessageID)
+	}
+	m.mu.Unlock()
+
+	if handler == nil {
+		return
+	}
+	if err := handler(c.userID, frame.MessageID); err != nil {
+		log.Printf("Failed to record delivery of message %d to user %d: %v", frame.MessageID, c.userID, err)
+	}
+}
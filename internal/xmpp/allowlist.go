@@ -0,0 +1,46 @@
+package xmpp
+
+import (
+	"errors"
+	"strings"
+
+	"mellium.im/xmpp/jid"
+)
+
+// ErrNotAdmin is returned when a reply comes from a JID that isn't an allowed admin
+var ErrNotAdmin = errors.New("sender is not an allowed admin")
+
+// Allowlist is the set of bare JIDs allowed to reply to users as admins.
+// An empty allowlist allows nobody.
+type Allowlist map[string]bool
+
+// NewAllowlist builds an allowlist from JIDs in any form; resources are
+// ignored and blank or unparseable entries are skipped
+func NewAllowlist(jids []string) Allowlist {
+	allowlist := make(Allowlist)
+	for _, s := range jids {
+		if bare := BareJID(s); bare != "" {
+			allowlist[bare] = true
+		}
+	}
+	return allowlist
+}
+
+// Allows reports whether from, a full or bare JID, may reply as an admin
+func (a Allowlist) Allows(from string) bool {
+	bare := BareJID(from)
+	return bare != "" && a[bare]
+}
+
+// BareJID normalizes a JID and strips its resource, returning "" if it is invalid
+func BareJID(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	addr, err := jid.Parse(s)
+	if err != nil {
+		return ""
+	}
+	return addr.Bare().String()
+}
@@ -0,0 +1,84 @@
+package xmpp
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// NSOOB is the XEP-0066 Out of Band Data namespace used to share file URLs
+const NSOOB = "jabber:x:oob"
+
+// messageStanza is the subset of an incoming <message/> we care about
+type messageStanza struct {
+	XMLName xml.Name `xml:"message"`
+	From    string   `xml:"from,attr"`
+	To      string   `xml:"to,attr"`
+	Body    string   `xml:"body"`
+	OOB     []struct {
+		URL string `xml:"url"`
+	} `xml:"jabber:x:oob x"`
+}
+
+// ParseMessage decodes a raw <message/> stanza, collecting any file URLs it
+// carries into Attachments
+func ParseMessage(data []byte) (XMPPMessage, error) {
+	var stanza messageStanza
+	if err := xml.Unmarshal(data, &stanza); err != nil {
+		return XMPPMessage{}, fmt.Errorf("failed to parse message stanza: %w", err)
+	}
+
+	var oobURLs []string
+	for _, oob := range stanza.OOB {
+		oobURLs = append(oobURLs, oob.URL)
+	}
+
+	return XMPPMessage{
+		From:        stanza.From,
+		To:          stanza.To,
+		Body:        stanza.Body,
+		Attachments: ExtractAttachments(stanza.Body, oobURLs),
+	}, nil
+}
+
+// ExtractAttachments returns the file URLs in a message: every OOB URL, any
+// aesgcm:// link (OMEMO encrypted upload) in the body, and an https link
+// when it is the whole body, which is how clients share HTTP uploads.
+// Duplicates are dropped.
+func ExtractAttachments(body string, oobURLs []string) []string {
+	var attachments []string
+	seen := make(map[string]bool)
+	add := func(raw string) {
+		raw = strings.TrimSpace(raw)
+		if raw == "" || seen[raw] || !isAttachmentURL(raw) {
+			return
+		}
+		seen[raw] = true
+		attachments = append(attachments, raw)
+	}
+
+	for _, raw := range oobURLs {
+		add(raw)
+	}
+
+	body = strings.TrimSpace(body)
+	if strings.HasPrefix(body, "https://") && !strings.ContainsAny(body, " \t\n") {
+		add(body)
+	}
+	for _, word := range strings.Fields(body) {
+		if strings.HasPrefix(word, "aesgcm://") {
+			add(word)
+		}
+	}
+
+	return attachments
+}
+
+func isAttachmentURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	return parsed.Scheme == "https" || parsed.Scheme == "aesgcm"
+}
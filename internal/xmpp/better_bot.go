@@ -2,7 +2,6 @@ package xmpp
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/xml"
 	"errors"
 	"fmt"
@@ -27,12 +26,30 @@ type BetterBotClient struct {
 	password     string
 	server       string
 	adminJID     string
-	session      *xmpp.Session
+	session      Session
 	connected    bool
 	activeUsers  map[int]*UserSession
+	tls          TLSOptions
+	replyHandler ReplyHandler
+	vipHandler   VIPHandler
 	mu           sync.RWMutex
 }
 
+// ReplyHandler delivers an admin reply to a website user
+type ReplyHandler func(userID int, message string) error
+
+// VIPHandler marks (or unmarks) a website user as a VIP
+type VIPHandler func(userID int, vip bool) error
+
+// ErrNoReplyHandler is returned for replies when no ReplyHandler is set
+var ErrNoReplyHandler = errors.New("no reply handler set, replies can't be delivered")
+
+// ReplyResult is the outcome of delivering a reply to one user
+type ReplyResult struct {
+	UserID int
+	Err    error
+}
+
 // UserSession tracks an active user conversation
 type UserSession struct {
 	UserID        int
@@ -52,9 +69,41 @@ func NewBetterBotClient(botJID, password, server, adminJID string) *BetterBotCli
 		server:      server,
 		adminJID:    adminJID,
 		activeUsers: make(map[int]*UserSession),
+		tls:         DefaultTLSOptions(),
 	}
 }
 
+// SetTLSOptions overrides the TLS settings used for new connections
+func (b *BetterBotClient) SetTLSOptions(opts TLSOptions) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tls = opts
+}
+
+// SetReplyHandler sets how admin replies reach users. Without a handler,
+// every reply fails with ErrNoReplyHandler.
+func (b *BetterBotClient) SetReplyHandler(handler ReplyHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.replyHandler = handler
+}
+
+// SetVIPHandler sets how the /vip command records VIPs. Without a handler
+// the command is unavailable.
+func (b *BetterBotClient) SetVIPHandler(handler VIPHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.vipHandler = handler
+}
+
+// SetSession attaches an established session and marks the bot connected
+func (b *BetterBotClient) SetSession(session Session) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.session = session
+	b.connected = session != nil
+}
+
 // Connect establishes XMPP connection
 func (b *BetterBotClient) Connect(ctx context.Context) error {
 	b.mu.Lock()
@@ -71,10 +120,7 @@ func (b *BetterBotClient) Connect(ctx context.Context) error {
 
 	log.Printf("Bot: Connecting to %s as %s", b.server, b.botJID)
 
-	tlsConfig := &tls.Config{
-		ServerName:         addr.Domain().String(),
-		InsecureSkipVerify: true,
-	}
+	tlsConfig := b.tls.Config(addr.Domain().String())
 
 	session, err := xmpp.DialClientSession(
 		ctx, addr,
@@ -208,6 +254,84 @@ func (b *BetterBotClient) ParseAdminReply(message string) (int, string, error) {
 	return userID, replyText, nil
 }
 
+// ParseMultiReply extracts user IDs and message from a /reply-multi command
+func (b *BetterBotClient) ParseMultiReply(command string) ([]int, string, error) {
+	// Format: /reply-multi ID1,ID2,ID3 message
+	// Example: /reply-multi 101,102 The outage has been fixed
+	
+	re := regexp.MustCompile(`^/reply-multi\s+([\d,\s]*\d)\s+(\S.*)`)
+	matches := re.FindStringSubmatch(strings.TrimSpace(command))
+	
+	if len(matches) != 3 {
+		return nil, "", fmt.Errorf("invalid format. Use: /reply-multi ID1,ID2,ID3 message")
+	}
+	
+	seen := make(map[int]bool)
+	var userIDs []int
+	for _, part := range strings.Split(matches[1], ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		userID, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid user ID: %s", part)
+		}
+		if !seen[userID] {
+			seen[userID] = true
+			userIDs = append(userIDs, userID)
+		}
+	}
+	
+	return userIDs, matches[2], nil
+}
+
+// ReplyToUsers sends the same reply to each user, continuing past failures
+func (b *BetterBotClient) ReplyToUsers(userIDs []int, message string) []ReplyResult {
+	results := make([]ReplyResult, 0, len(userIDs))
+	for _, userID := range userIDs {
+		results = append(results, ReplyResult{
+			UserID: userID,
+			Err:    b.deliverReply(userID, message),
+		})
+	}
+	return results
+}
+
+// deliverReply sends a reply to a single user through the reply handler
+func (b *BetterBotClient) deliverReply(userID int, message string) error {
+	b.mu.RLock()
+	handler := b.replyHandler
+	b.mu.RUnlock()
+	
+	if handler == nil {
+		return ErrNoReplyHandler
+	}
+	return handler(userID, message)
+}
+
+// formatReplyResults summarizes a multi-user reply for the admin
+func formatReplyResults(results []ReplyResult) string {
+	var sb strings.Builder
+	sent := 0
+	for _, result := range results {
+		if result.Err == nil {
+			sent++
+		}
+	}
+	
+	sb.WriteString(fmt.Sprintf("📨 Reply sent to %d of %d users\n", sent, len(results)))
+	for _, result := range results {
+		if result.Err != nil {
+			sb.WriteString(fmt.Sprintf("❌ User %d: %v\n", result.UserID, result.Err))
+		} else {
+			sb.WriteString(fmt.Sprintf("✅ User %d\n", result.UserID))
+		}
+	}
+	
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
 // SendSystemMessage sends a system notification to admin
 func (b *BetterBotClient) SendSystemMessage(message string) error {
 	if !b.connected || b.session == nil {
@@ -273,6 +397,8 @@ func (b *BetterBotClient) HandleCommand(command string) error {
 /list - Show active users
 /info USER_ID - User details
 /clear USER_ID - Clear user session
+/reply-multi ID1,ID2 message - Reply to several users
+/vip USER_ID [off] - Prioritize a user's messages
 /help - Show this help
 
 REPLY FORMAT:
@@ -303,6 +429,16 @@ REPLY FORMAT:
 		b.mu.Unlock()
 		return b.SendSystemMessage(fmt.Sprintf("Cleared session for user %d", userID))
 		
+	case "/vip":
+		return b.handleVIP(parts)
+		
+	case "/reply-multi":
+		userIDs, reply, err := b.ParseMultiReply(command)
+		if err != nil {
+			return b.SendSystemMessage(fmt.Sprintf("Error: %v", err))
+		}
+		return b.SendSystemMessage(formatReplyResults(b.ReplyToUsers(userIDs, reply)))
+		
 	default:
 		// Not a command, might be a reply
 		if strings.HasPrefix(command, "@") {
@@ -310,6 +446,9 @@ REPLY FORMAT:
 			if err != nil {
 				return b.SendSystemMessage(fmt.Sprintf("Error: %v", err))
 			}
+			if err := b.deliverReply(userID, reply); err != nil {
+				return b.SendSystemMessage(fmt.Sprintf("❌ Reply to user %d failed: %v", userID, err))
+			}
 			return b.SendSystemMessage(fmt.Sprintf("✅ Reply sent to user %d: %s", userID, reply))
 		}
 	}
@@ -317,6 +456,33 @@ REPLY FORMAT:
 	return nil
 }
 
+// handleVIP runs "/vip USER_ID", or "/vip USER_ID off" to undo it
+func (b *BetterBotClient) handleVIP(parts []string) error {
+	if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != "off") {
+		return b.SendSystemMessage("Usage: /vip USER_ID [off]")
+	}
+	userID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return b.SendSystemMessage("Invalid user ID")
+	}
+	
+	b.mu.RLock()
+	handler := b.vipHandler
+	b.mu.RUnlock()
+	if handler == nil {
+		return b.SendSystemMessage("VIP marking is not available")
+	}
+	
+	vip := len(parts) == 2
+	if err := handler(userID, vip); err != nil {
+		return b.SendSystemMessage(fmt.Sprintf("❌ Couldn't update user %d: %v", userID, err))
+	}
+	if vip {
+		return b.SendSystemMessage(fmt.Sprintf("⭐ User %d is now a VIP", userID))
+	}
+	return b.SendSystemMessage(fmt.Sprintf("User %d is no longer a VIP", userID))
+}
+
 // sendUserInfo sends detailed info about a user
 func (b *BetterBotClient) sendUserInfo(userID int) error {
 	b.mu.RLock()
@@ -1,35 +1,45 @@
 package xmpp
 
 import (
+	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ngenohkevin/veilsupport/internal/redact"
 	"mellium.im/sasl"
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
 	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/ping"
 	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/upload"
 )
 
 type XMPPClient struct {
 	jid       string
 	password  string
 	server    string
-	session   *xmpp.Session
+	session   Session
 	connected bool
+	tls       TLSOptions
+	uploads   string    // XEP-0363 upload service JID
+	uploader  *Uploader // Set with SetUploadService
+	keepAlive time.Duration
+	lastSent  atomic.Int64 // Unix nanoseconds of the last message sent
 	mu        sync.RWMutex
 }
 
 type XMPPMessage struct {
-	From string
-	To   string
-	Body string
+	From        string
+	To          string
+	Body        string
+	Attachments []string // File URLs shared with the message (see ParseMessage)
 }
 
 func NewXMPPClient(jidStr, password, server string) *XMPPClient {
@@ -37,14 +47,88 @@ func NewXMPPClient(jidStr, password, server string) *XMPPClient {
 		jid:      jidStr,
 		password: password,
 		server:   server,
+		tls:       DefaultTLSOptions(),
+		keepAlive: DefaultKeepAlive,
 	}
 }
 
-func (c *XMPPClient) ConnectWithContext(ctx context.Context) error {
+// SetTLSOptions overrides the TLS settings used for new connections
+func (c *XMPPClient) SetTLSOptions(opts TLSOptions) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.tls = opts
+}
 
-	if c.connected && c.session != nil {
+// SetUploadService enables file sharing through the XEP-0363 upload service
+// at service (e.g. upload.example.com) with the given timeouts and retries
+func (c *XMPPClient) SetUploadService(service string, opts UploadOptions) {
+	uploader := NewUploader(c)
+	uploader.SetOptions(opts)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.uploads = service
+	c.uploader = uploader
+}
+
+// RequestSlot asks the upload service for a slot over the current session
+func (c *XMPPClient) RequestSlot(ctx context.Context, file upload.File) (upload.Slot, error) {
+	c.mu.RLock()
+	session := c.session
+	connected := c.connected
+	service := c.uploads
+	c.mu.RUnlock()
+
+	if service == "" {
+		return upload.Slot{}, errors.New("no upload service configured")
+	}
+	if !connected || session == nil {
+		return upload.Slot{}, errors.New("not connected to XMPP server")
+	}
+	iqSession, ok := session.(*xmpp.Session)
+	if !ok {
+		return upload.Slot{}, errors.New("session does not support IQ requests")
+	}
+	to, err := jid.Parse(service)
+	if err != nil {
+		return upload.Slot{}, fmt.Errorf("invalid upload service JID: %w", err)
+	}
+	return upload.GetSlot(ctx, file, to, iqSession)
+}
+
+// Upload shares a file through the upload service and returns its download URL
+func (c *XMPPClient) Upload(ctx context.Context, name, contentType string, data []byte) (string, error) {
+	c.mu.RLock()
+	uploader := c.uploader
+	c.mu.RUnlock()
+
+	if uploader == nil {
+		return "", errors.New("no upload service configured")
+	}
+	return uploader.Upload(ctx, name, contentType, data)
+}
+
+// SetSession attaches an established session and marks the client connected.
+// Useful for tests that substitute a fake session.
+func (c *XMPPClient) SetSession(session Session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.session = session
+	c.connected = session != nil
+}
+
+// dialTimeout bounds connecting, negotiating and sending initial presence
+const dialTimeout = 30 * time.Second
+
+// ConnectWithContext dials a new session unless one is already connected.
+// The dial happens without holding mu, so a slow server doesn't block sends
+// or IsConnected; if another connect wins meanwhile, the new session is closed.
+func (c *XMPPClient) ConnectWithContext(ctx context.Context) error {
+	c.mu.RLock()
+	connected := c.connected && c.session != nil
+	tlsOptions := c.tls
+	c.mu.RUnlock()
+	if connected {
 		return nil
 	}
 
@@ -57,10 +141,10 @@ func (c *XMPPClient) ConnectWithContext(ctx context.Context) error {
 	log.Printf("XMPP: Connecting to %s as %s", c.server, c.jid)
 
 	// Create TLS config
-	tlsConfig := &tls.Config{
-		ServerName:         addr.Domain().String(),
-		InsecureSkipVerify: true, // For testing - in production use proper certificates
-	}
+	tlsConfig := tlsOptions.Config(addr.Domain().String())
+
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
 
 	// Connect to XMPP server with proper configuration
 	conn, err := xmpp.DialClientSession(
@@ -80,6 +164,12 @@ func (c *XMPPClient) ConnectWithContext(ctx context.Context) error {
 		return fmt.Errorf("failed to send presence: %w", err)
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.connected && c.session != nil {
+		conn.Close()
+		return nil
+	}
 	c.session = conn
 	c.connected = true
 	
@@ -93,6 +183,29 @@ func (c *XMPPClient) IsConnected() bool {
 	return c.connected && c.session != nil
 }
 
+// dropSession closes and forgets a session that failed to send, so
+// IsConnected reports the outage and the reconnector dials a new one. A
+// session that has already been replaced is left alone.
+func (c *XMPPClient) dropSession(session Session, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.session != session {
+		return
+	}
+	session.Close()
+	c.session = nil
+	c.connected = false
+	log.Printf("XMPP: Connection lost: %v", err)
+}
+
+// hasSession reports whether session is still the client's current session
+func (c *XMPPClient) hasSession(session Session) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.connected && c.session == session
+}
+
 func (c *XMPPClient) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -160,10 +273,12 @@ func (c *XMPPClient) SendMessage(to, body string) error {
 	
 	err = session.Send(ctx, messageWithBody)
 	if err != nil {
+		c.dropSession(session, err)
 		return fmt.Errorf("failed to send message: %w", err)
 	}
 	
-	log.Printf("XMPP: Message sent from %s to %s: %s", c.jid, to, body)
+	c.markActive()
+	log.Printf("XMPP: Message sent from %s to %s: %s", c.jid, to, redact.Body(body))
 	return nil
 }
 
@@ -202,10 +317,12 @@ func (c *XMPPClient) SendMessageSimple(to, body string) error {
 	
 	err = session.Send(ctx, encoder.TokenReader())
 	if err != nil {
+		c.dropSession(session, err)
 		return fmt.Errorf("failed to send message: %w", err)
 	}
 	
-	log.Printf("XMPP: Message sent from %s to %s: %s", c.jid, to, body)
+	c.markActive()
+	log.Printf("XMPP: Message sent from %s to %s: %s", c.jid, to, redact.Body(body))
 	return nil
 }
 
@@ -221,25 +338,102 @@ func (c *XMPPClient) Listen(ctx context.Context, messages chan<- XMPPMessage, er
 
 	log.Println("XMPP: Starting message listener")
 
-	// Create a simple handler for incoming messages
+	// Incoming messages are read by session.Serve; meanwhile the connection
+	// is kept alive with XEP-0199 pings and, when idle, whitespace. A failed
+	// ping or read drops the session and ends the listener, so the
+	// reconnector can replace it.
+	served := make(chan error, 1)
+	go func() { served <- serveMessages(ctx, session, messages) }()
+
+	pings := time.NewTicker(1 * time.Second)
+	defer pings.Stop()
+	
+	c.mu.RLock()
+	keepAlive := c.keepAlive
+	c.mu.RUnlock()
+	var keepAlives <-chan time.Time
+	if keepAlive > 0 {
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+		keepAlives = ticker.C
+	}
+	
 	for {
 		select {
 		case <-ctx.Done():
 			log.Println("XMPP: Listener stopped by context")
 			return ctx.Err()
-		default:
-			// This is a simplified listener - in production you'd use session.Serve
-			// For now, we'll just keep the connection alive
-			time.Sleep(1 * time.Second)
-			
-			// Periodic ping to keep connection alive
-			if c.IsConnected() {
-				pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
-				_ = c.session.Send(pingCtx, stanza.IQ{Type: stanza.GetIQ}.Wrap(nil))
-				cancel()
+		case err := <-served:
+			// Serve ends without error when the stream closes cleanly;
+			// leave it to the pings to notice
+			served = nil
+			if err != nil && ctx.Err() == nil {
+				c.dropSession(session, err)
+				return fmt.Errorf("XMPP connection lost: %w", err)
+			}
+		case <-pings.C:
+			if !c.hasSession(session) {
+				return errors.New("XMPP session closed")
 			}
+			pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+			err := session.Send(pingCtx, ping.IQ{IQ: stanza.IQ{Type: stanza.GetIQ}}.TokenReader())
+			cancel()
+			if err != nil && ctx.Err() == nil {
+				c.dropSession(session, err)
+				return fmt.Errorf("XMPP connection lost: %w", err)
+			}
+		case <-keepAlives:
+			if err := c.sendKeepAlive(keepAlive); err != nil {
+				log.Printf("XMPP: Keep-alive failed: %v", err)
+			}
+		}
+	}
+}
+
+// serveMessages reads stanzas from session until it ends, parsing each
+// incoming message with ParseMessage and passing on those with a body or
+// attachments. Other stanzas and error messages are ignored.
+func serveMessages(ctx context.Context, session Session, messages chan<- XMPPMessage) error {
+	return session.Serve(xmpp.HandlerFunc(func(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+		if start.Name.Local != "message" || messageType(start) == stanza.ErrorMessage {
+			return nil
+		}
+
+		var buf bytes.Buffer
+		enc := xml.NewEncoder(&buf)
+		element := xmlstream.MultiReader(xmlstream.Token(start.Copy()), xmlstream.Inner(t), xmlstream.Token(start.End()))
+		if _, err := xmlstream.Copy(enc, element); err != nil {
+			return fmt.Errorf("failed to read message stanza: %w", err)
+		}
+		if err := enc.Flush(); err != nil {
+			return fmt.Errorf("failed to read message stanza: %w", err)
+		}
+
+		msg, err := ParseMessage(buf.Bytes())
+		if err != nil {
+			log.Printf("XMPP: Ignoring malformed message: %v", err)
+			return nil
+		}
+		if msg.Body == "" && len(msg.Attachments) == 0 {
+			return nil
+		}
+
+		select {
+		case messages <- msg:
+		case <-ctx.Done():
+		}
+		return nil
+	}))
+}
+
+// messageType returns the type attribute of a <message/> start element
+func messageType(start *xml.StartElement) stanza.MessageType {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "type" && attr.Name.Space == "" {
+			return stanza.MessageType(attr.Value)
 		}
 	}
+	return stanza.NormalMessage
 }
 
 func (c *XMPPClient) GetJID() string {
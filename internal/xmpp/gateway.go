@@ -2,12 +2,13 @@ package xmpp
 
 import (
 	"context"
-	"crypto/tls"
+	"encoding/base64"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"log"
-	"strings"
+	"regexp"
+	"strconv"
 	"sync"
 	"time"
 
@@ -25,18 +26,35 @@ type GatewayClient struct {
 	password  string           // Bot's password
 	server    string           // XMPP server
 	adminJIDs []string         // Admin JIDs to receive messages
-	session   *xmpp.Session    // XMPP session
+	session   Session          // XMPP session
 	connected bool             // Connection status
 	userMap   map[int]UserInfo // Map of userID to user info
+	tls       TLSOptions       // TLS settings for the connection
+	confirm   bool             // Confirm routed admin replies back to the admin
+	threads   bool             // Give each user's messages their own thread and subject
+	admins    Allowlist        // Who may reply to users, from adminJIDs
 	mu        sync.RWMutex     // Mutex for thread safety
+
+	presenceGrace time.Duration       // How long a user must stay offline before admins are told
+	offlineTimers map[int]*time.Timer // Pending unavailable presences, by user ID
+	announced     map[int]bool        // Online state admins were last sent, by user ID
 }
 
+// defaultPresenceGrace absorbs reconnects from flaky connections such as mobile
+const defaultPresenceGrace = 5 * time.Second
+
+// Reasons an admin reply could not be routed
+var (
+	ErrUnknownRecipient = errors.New("could not determine target user from admin message")
+	ErrUserNotFound     = errors.New("user not found")
+)
+
 // UserInfo represents a web user in the XMPP context
 type UserInfo struct {
 	UserID      int
 	Email       string
 	DisplayName string
-	ResourceID  string // e.g., "user_123_john"
+	ResourceID  string // e.g., "user_123_am9obg" (see EncodeResourceID)
 	IsOnline    bool
 	LastSeen    time.Time
 }
@@ -59,10 +77,56 @@ func NewGatewayClient(botJID, password, server string, adminJIDs []string) *Gate
 		password:  password,
 		server:    server,
 		adminJIDs: adminJIDs,
+		admins:    NewAllowlist(adminJIDs),
 		userMap:   make(map[int]UserInfo),
+		tls:       DefaultTLSOptions(),
+
+		presenceGrace: defaultPresenceGrace,
+		offlineTimers: make(map[int]*time.Timer),
+		announced:     make(map[int]bool),
 	}
 }
 
+// SetTLSOptions overrides the TLS settings used for new connections
+func (g *GatewayClient) SetTLSOptions(opts TLSOptions) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.tls = opts
+}
+
+// SetReplyConfirmations enables telling the admin whether each reply was routed to its user
+func (g *GatewayClient) SetReplyConfirmations(enabled bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.confirm = enabled
+}
+
+// SetThreads makes messages carry a thread and subject per user (see
+// ThreadID), so agents' clients group each user's messages together instead
+// of interleaving everyone in one chat
+func (g *GatewayClient) SetThreads(enabled bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.threads = enabled
+}
+
+// SetPresenceGrace sets how long a user must stay offline before admins get
+// an unavailable presence. Going back online within the grace period sends
+// nothing, so flapping connections don't spam admins. 0 reports every change.
+func (g *GatewayClient) SetPresenceGrace(grace time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.presenceGrace = grace
+}
+
+// SetSession attaches an established session and marks the gateway connected
+func (g *GatewayClient) SetSession(session Session) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.session = session
+	g.connected = session != nil
+}
+
 // Connect establishes connection to XMPP server as the bot
 func (g *GatewayClient) Connect(ctx context.Context) error {
 	g.mu.Lock()
@@ -81,10 +145,7 @@ func (g *GatewayClient) Connect(ctx context.Context) error {
 	log.Printf("Gateway: Connecting to %s as bot %s", g.server, g.botJID)
 
 	// TLS config
-	tlsConfig := &tls.Config{
-		ServerName:         addr.Domain().String(),
-		InsecureSkipVerify: true,
-	}
+	tlsConfig := g.tls.Config(addr.Domain().String())
 
 	// Connect to XMPP server
 	session, err := xmpp.DialClientSession(
@@ -197,6 +258,17 @@ func (g *GatewayClient) sendMessageAsUser(user UserInfo, toJID, body string, att
 		bodyStart,
 	)
 	
+	g.mu.RLock()
+	threads := g.threads
+	g.mu.RUnlock()
+	if threads {
+		bodyContent = xmlstream.MultiReader(
+			textElement("subject", fmt.Sprintf("%s <%s>", user.DisplayName, user.Email)),
+			bodyContent,
+			textElement("thread", ThreadID(user.UserID)),
+		)
+	}
+	
 	// Wrap the message with body content
 	messageWithBody := msg.Wrap(bodyContent)
 	
@@ -210,12 +282,59 @@ func (g *GatewayClient) sendMessageAsUser(user UserInfo, toJID, body string, att
 	return nil
 }
 
+// SendText sends a plain message from the bot itself rather than on behalf
+// of a user, such as a notice for an admin
+func (g *GatewayClient) SendText(toJID, body string) error {
+	recipientJID, err := jid.Parse(toJID)
+	if err != nil {
+		return fmt.Errorf("invalid recipient JID: %w", err)
+	}
+
+	g.mu.RLock()
+	session := g.session
+	connected := g.connected
+	g.mu.RUnlock()
+	if !connected || session == nil {
+		return errors.New("gateway not connected to XMPP server")
+	}
+
+	msg := stanza.Message{
+		To:   recipientJID,
+		Type: stanza.ChatMessage,
+		ID:   fmt.Sprintf("notice_%d", time.Now().UnixNano()),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := session.Send(ctx, msg.Wrap(textElement("body", body))); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return nil
+}
+
+// ThreadID is the thread a user's messages are sent in when threads are enabled
+func ThreadID(userID int) string {
+	return fmt.Sprintf("veilsupport_user_%d", userID)
+}
+
+// textElement is an element holding only text, such as <subject>
+func textElement(name, text string) xml.TokenReader {
+	return xmlstream.Wrap(xmlstream.Token(xml.CharData(text)), xml.StartElement{Name: xml.Name{Local: name}})
+}
+
 // HandleAdminReply processes replies from admin to web users
-func (g *GatewayClient) HandleAdminReply(_, body string) (*GatewayMessage, error) {
+func (g *GatewayClient) HandleAdminReply(from, body string) (*GatewayMessage, error) {
+	// Anyone can message the bot; only admins get to talk to users
+	if !g.admins.Allows(from) {
+		log.Printf("Gateway: Dropping reply from %s, not an admin", from)
+		return nil, fmt.Errorf("%w: %s", ErrNotAdmin, from)
+	}
+
 	// Extract user ID from the message thread or context
 	userID := g.extractUserIDFromMessage(body)
 	if userID == 0 {
-		return nil, fmt.Errorf("could not determine target user from admin message")
+		return nil, ErrUnknownRecipient
 	}
 
 	g.mu.RLock()
@@ -223,7 +342,7 @@ func (g *GatewayClient) HandleAdminReply(_, body string) (*GatewayMessage, error
 	g.mu.RUnlock()
 
 	if !exists {
-		return nil, fmt.Errorf("user %d not found", userID)
+		return nil, fmt.Errorf("%w: %d", ErrUserNotFound, userID)
 	}
 
 	// Create gateway message for routing to web user
@@ -232,6 +351,7 @@ func (g *GatewayClient) HandleAdminReply(_, body string) (*GatewayMessage, error
 		UserEmail:   user.Email,
 		DisplayName: user.DisplayName,
 		Body:        body,
+		Attachments: ExtractAttachments(userMentionPattern.ReplaceAllString(body, ""), nil),
 		FromAdmin:   true,
 		Timestamp:   time.Now(),
 	}
@@ -240,7 +360,81 @@ func (g *GatewayClient) HandleAdminReply(_, body string) (*GatewayMessage, error
 	return gwMsg, nil
 }
 
-// SetUserOnline updates user's online status
+// ConfirmReply tells the admin whether their reply reached the user. A nil
+// routeErr sends a short "delivered" message; otherwise an error stanza
+// explains what went wrong. Nothing is sent unless confirmations are enabled.
+func (g *GatewayClient) ConfirmReply(adminJID string, userID int, routeErr error) error {
+	g.mu.RLock()
+	confirm := g.confirm
+	g.mu.RUnlock()
+
+	if !confirm {
+		return nil
+	}
+	if !g.IsConnected() {
+		return errors.New("gateway not connected to XMPP server")
+	}
+
+	confirmation, err := ReplyConfirmation(adminJID, userID, routeErr)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := g.session.Send(ctx, confirmation); err != nil {
+		return fmt.Errorf("failed to send reply confirmation: %w", err)
+	}
+	return nil
+}
+
+// ReplyConfirmation builds the stanza sent back to an admin after routing their reply
+func ReplyConfirmation(adminJID string, userID int, routeErr error) (xml.TokenReader, error) {
+	to, err := jid.Parse(adminJID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid admin JID: %w", err)
+	}
+
+	body := func(text string) xml.TokenReader {
+		return xmlstream.Wrap(xmlstream.Token(xml.CharData(text)), xml.StartElement{Name: xml.Name{Local: "body"}})
+	}
+
+	if routeErr == nil {
+		msg := stanza.Message{
+			To:   to,
+			Type: stanza.ChatMessage,
+			ID:   fmt.Sprintf("confirm_%d_%d", userID, time.Now().UnixNano()),
+		}
+		return msg.Wrap(body(fmt.Sprintf("✅ Delivered to user %d", userID))), nil
+	}
+
+	stanzaErr := stanza.Error{
+		Type:      stanza.Cancel,
+		Condition: stanza.InternalServerError,
+		Text:      map[string]string{"": routeErr.Error()},
+	}
+	switch {
+	case errors.Is(routeErr, ErrUnknownRecipient):
+		stanzaErr.Type = stanza.Modify
+		stanzaErr.Condition = stanza.BadRequest
+	case errors.Is(routeErr, ErrUserNotFound):
+		stanzaErr.Condition = stanza.ItemNotFound
+	}
+
+	msg := stanza.Message{
+		To:   to,
+		Type: stanza.ErrorMessage,
+		ID:   fmt.Sprintf("confirm_%d_%d", userID, time.Now().UnixNano()),
+	}
+	return msg.Wrap(xmlstream.MultiReader(
+		body(fmt.Sprintf("❌ Reply not delivered: %v", routeErr)),
+		stanzaErr.TokenReader(),
+	)), nil
+}
+
+// SetUserOnline updates user's online status. Admins are told about a change
+// only once it sticks: see SetPresenceGrace.
 func (g *GatewayClient) SetUserOnline(userID int, online bool) error {
 	g.mu.Lock()
 	defer g.mu.Unlock()
@@ -254,23 +448,66 @@ func (g *GatewayClient) SetUserOnline(userID int, online bool) error {
 	user.LastSeen = time.Now()
 	g.userMap[userID] = user
 
-	// Send presence update to admins
-	if g.connected && g.session != nil {
-		presenceType := stanza.AvailablePresence
-		if !online {
-			presenceType = stanza.UnavailablePresence
+	if online {
+		// Back before the grace period ran out: admins never saw them leave
+		if timer, pending := g.offlineTimers[userID]; pending {
+			timer.Stop()
+			delete(g.offlineTimers, userID)
+		}
+		if !g.announced[userID] {
+			g.announcePresence(user, true)
 		}
+		return nil
+	}
 
-		for _, adminJID := range g.adminJIDs {
-			if err := g.sendPresenceUpdate(user, adminJID, presenceType); err != nil {
-				log.Printf("Gateway: Failed to send presence to %s: %v", adminJID, err)
+	if g.presenceGrace <= 0 {
+		g.announcePresence(user, false)
+		return nil
+	}
+	if _, pending := g.offlineTimers[userID]; !pending {
+		var timer *time.Timer
+		timer = time.AfterFunc(g.presenceGrace, func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+
+			// Superseded by coming back online (and maybe leaving again)
+			if g.offlineTimers[userID] != timer {
+				return
 			}
-		}
+			delete(g.offlineTimers, userID)
+			if user, exists := g.userMap[userID]; exists && !user.IsOnline {
+				g.announcePresence(user, false)
+			}
+		})
+		g.offlineTimers[userID] = timer
 	}
 
 	return nil
 }
 
+// announcePresence sends a user's presence to every admin and records it.
+// Must be called with g.mu held.
+func (g *GatewayClient) announcePresence(user UserInfo, online bool) {
+	if announced, ok := g.announced[user.UserID]; ok && announced == online {
+		return
+	}
+	if !g.connected || g.session == nil {
+		return
+	}
+
+	presenceType := stanza.AvailablePresence
+	if !online {
+		presenceType = stanza.UnavailablePresence
+	}
+
+	for _, adminJID := range g.adminJIDs {
+		if err := g.sendPresenceUpdate(user, adminJID, presenceType); err != nil {
+			log.Printf("Gateway: Failed to send presence to %s: %v", adminJID, err)
+		}
+	}
+	g.announced[user.UserID] = online
+}
+
 // sendPresenceUpdate sends presence information about a user
 func (g *GatewayClient) sendPresenceUpdate(user UserInfo, toJID string, presenceType stanza.PresenceType) error {
 	recipientJID, err := jid.Parse(toJID)
@@ -302,38 +539,67 @@ func (g *GatewayClient) sendPresenceUpdate(user UserInfo, toJID string, presence
 
 // generateResourceID creates a unique resource ID for a user
 func (g *GatewayClient) generateResourceID(userID int, displayName string) string {
-	// Clean display name for use in resource
-	cleaned := strings.ToLower(displayName)
-	cleaned = strings.ReplaceAll(cleaned, " ", "_")
-	cleaned = strings.ReplaceAll(cleaned, "@", "_")
+	return EncodeResourceID(userID, displayName)
+}
 
-	// Format: user_ID_name
-	return fmt.Sprintf("user_%d_%s", userID, cleaned)
+// EncodeResourceID builds a user's XMPP resource as "user_ID_NAME", where NAME
+// is the display name in unpadded base64url. The encoding is reversible with
+// ParseResourceID, and distinct display names always give distinct resources.
+func EncodeResourceID(userID int, displayName string) string {
+	return fmt.Sprintf("user_%d_%s", userID, base64.RawURLEncoding.EncodeToString([]byte(displayName)))
 }
 
+// ParseResourceID reverses EncodeResourceID
+func ParseResourceID(resourceID string) (int, string, error) {
+	matches := resourceIDPattern.FindStringSubmatch(resourceID)
+	if matches == nil {
+		return 0, "", fmt.Errorf("invalid resource ID: %s", resourceID)
+	}
+
+	userID, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid user ID in resource ID: %s", resourceID)
+	}
+
+	displayName, err := base64.RawURLEncoding.DecodeString(matches[2])
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid display name in resource ID: %s", resourceID)
+	}
+
+	return userID, string(displayName), nil
+}
+
+var (
+	resourceIDPattern  = regexp.MustCompile(`^user_(\d+)_([A-Za-z0-9_-]*)$`)
+	userMentionPattern = regexp.MustCompile(`@user_(\d+)`)
+)
+
 // extractUserIDFromMessage attempts to extract user ID from admin's reply
 func (g *GatewayClient) extractUserIDFromMessage(body string) int {
-	// Look for patterns like "user_123" or "@user_123" or reply context
+	// Look for patterns like "@user_123" (optionally a full resource ID)
 	// This is a simplified version - in production, you'd track conversation threads
-
-	// For now, admin should reply with @user_ID format
-	if strings.Contains(body, "@user_") {
-		var userID int
-		_, err := fmt.Sscanf(body, "%*s@user_%d", &userID)
-		if err == nil {
-			return userID
-		}
+	matches := userMentionPattern.FindStringSubmatch(body)
+	if matches == nil {
+		return 0
 	}
 
-	return 0
+	userID, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return 0
+	}
+	return userID
 }
 
-
 // Close closes the gateway connection
 func (g *GatewayClient) Close() error {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
+	for userID, timer := range g.offlineTimers {
+		timer.Stop()
+		delete(g.offlineTimers, userID)
+	}
+
 	if g.session != nil {
 		// Send unavailable presence
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
@@ -0,0 +1,73 @@
+package xmpp
+
+import (
+	"encoding/xml"
+	"errors"
+	"time"
+
+	"mellium.im/xmlstream"
+)
+
+// DefaultKeepAlive is shorter than the idle timeout of most NATs and Tor circuits
+const DefaultKeepAlive = 30 * time.Second
+
+// whitespaceWriter is implemented by sessions that can write between stanzas,
+// as *xmpp.Session does
+type whitespaceWriter interface {
+	TokenWriter() xmlstream.TokenWriteFlushCloser
+}
+
+// SetKeepAlive sets how long the connection may sit idle before a single
+// space is sent to keep NATs and proxies from dropping it. 0 disables it.
+func (c *XMPPClient) SetKeepAlive(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keepAlive = interval
+}
+
+// markActive records that a stanza was just sent, postponing the next keep-alive
+func (c *XMPPClient) markActive() {
+	c.lastSent.Store(time.Now().UnixNano())
+}
+
+// sendKeepAlive writes whitespace unless a stanza was sent within the last
+// interval
+func (c *XMPPClient) sendKeepAlive(interval time.Duration) error {
+	if time.Since(time.Unix(0, c.lastSent.Load())) < interval {
+		return nil
+	}
+
+	c.mu.RLock()
+	session := c.session
+	connected := c.connected
+	c.mu.RUnlock()
+	if !connected || session == nil {
+		return nil
+	}
+
+	err := sendWhitespace(session)
+	if err != nil && !errors.Is(err, errNoWhitespace) {
+		c.dropSession(session, err)
+	}
+	return err
+}
+
+// errNoWhitespace means the session can't send keep-alives, which says
+// nothing about whether it is still connected
+var errNoWhitespace = errors.New("session can't write whitespace keep-alives")
+
+// sendWhitespace writes a single space between stanzas. Servers ignore it
+// (RFC 6120 §4.6.1) but it counts as traffic to anything in between.
+func sendWhitespace(session Session) error {
+	writer, ok := session.(whitespaceWriter)
+	if !ok {
+		return errNoWhitespace
+	}
+
+	w := writer.TokenWriter()
+	defer w.Close()
+	if err := w.EncodeToken(xml.CharData(" ")); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,194 @@
+package xmpp
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Outage describes how long the XMPP connection has been failing
+type Outage struct {
+	Attempts  int           // Consecutive failed connection attempts
+	DownFor   time.Duration // Time since the first failed attempt
+	LastError error
+}
+
+// AlertFunc is called once per outage when it crosses the alert threshold
+type AlertFunc func(Outage)
+
+// Reconnector keeps an XMPP connection up, retrying with exponential backoff
+// and raising an alert when the connection stays down too long. It never
+// gives up; the alert only makes the outage visible to operators.
+type Reconnector struct {
+	connect     func(ctx context.Context) error
+	isConnected func() bool
+
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	checkInterval time.Duration
+
+	alertAttempts int           // Alert after this many failed attempts (0 disables)
+	alertAfter    time.Duration // Alert after being down this long (0 disables)
+	alert         AlertFunc
+	onConnect     func()
+
+	attempts  int
+	downSince time.Time
+	lastError error
+	alerted   bool
+	mu        sync.Mutex
+}
+
+// NewReconnector creates a reconnector around the given connect and status
+// functions, e.g. XMPPClient.ConnectWithContext and XMPPClient.IsConnected
+func NewReconnector(connect func(ctx context.Context) error, isConnected func() bool) *Reconnector {
+	return &Reconnector{
+		connect:       connect,
+		isConnected:   isConnected,
+		minBackoff:    time.Second,
+		maxBackoff:    time.Minute,
+		checkInterval: 10 * time.Second,
+		alertAttempts: 10,
+		alert: func(outage Outage) {
+			log.Printf("ALERT: XMPP has been down for %s (%d failed attempts): %v",
+				outage.DownFor.Round(time.Second), outage.Attempts, outage.LastError)
+		},
+	}
+}
+
+// SetBackoff sets the delay after the first failure and the cap it doubles up to
+func (r *Reconnector) SetBackoff(min, max time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.minBackoff = min
+	r.maxBackoff = max
+}
+
+// SetCheckInterval sets how often a healthy connection is checked
+func (r *Reconnector) SetCheckInterval(interval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkInterval = interval
+}
+
+// SetAlertThreshold sets when an outage is alerted on: after the given number
+// of failed attempts or after being down for the given duration, whichever
+// comes first. Zero disables that condition.
+func (r *Reconnector) SetAlertThreshold(attempts int, downFor time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alertAttempts = attempts
+	r.alertAfter = downFor
+}
+
+// SetAlertHandler replaces the default alert, which logs the outage
+func (r *Reconnector) SetAlertHandler(alert AlertFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alert = alert
+}
+
+// SetOnConnect registers a callback run after every successful connection
+func (r *Reconnector) SetOnConnect(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onConnect = fn
+}
+
+// Outage returns the current outage, or nil while connected
+func (r *Reconnector) Outage() *Outage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.attempts == 0 {
+		return nil
+	}
+	return &Outage{Attempts: r.attempts, DownFor: time.Since(r.downSince), LastError: r.lastError}
+}
+
+// Attempt makes a single connection attempt and updates the outage state,
+// firing the alert if the threshold has just been crossed
+func (r *Reconnector) Attempt(ctx context.Context) error {
+	err := r.connect(ctx)
+
+	r.mu.Lock()
+	if err == nil {
+		if r.attempts > 0 {
+			log.Printf("XMPP: Reconnected after %d failed attempts (down %s)",
+				r.attempts, time.Since(r.downSince).Round(time.Second))
+		}
+		r.attempts = 0
+		r.lastError = nil
+		r.alerted = false
+		onConnect := r.onConnect
+		r.mu.Unlock()
+
+		if onConnect != nil {
+			onConnect()
+		}
+		return nil
+	}
+
+	if r.attempts == 0 {
+		r.downSince = time.Now()
+	}
+	r.attempts++
+	r.lastError = err
+	outage := Outage{Attempts: r.attempts, DownFor: time.Since(r.downSince), LastError: err}
+
+	var alert AlertFunc
+	if !r.alerted && r.thresholdReached(outage) {
+		r.alerted = true
+		alert = r.alert
+	}
+	r.mu.Unlock()
+
+	log.Printf("XMPP: Connection attempt %d failed: %v", outage.Attempts, err)
+	if alert != nil {
+		alert(outage)
+	}
+	return err
+}
+
+func (r *Reconnector) thresholdReached(outage Outage) bool {
+	if r.alertAttempts > 0 && outage.Attempts >= r.alertAttempts {
+		return true
+	}
+	return r.alertAfter > 0 && outage.DownFor >= r.alertAfter
+}
+
+// backoff returns the delay before the next attempt
+func (r *Reconnector) backoff() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delay := r.minBackoff
+	for i := 1; i < r.attempts && delay < r.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.maxBackoff {
+		delay = r.maxBackoff
+	}
+	return delay
+}
+
+// Run keeps the connection up until ctx is cancelled
+func (r *Reconnector) Run(ctx context.Context) {
+	for {
+		r.mu.Lock()
+		wait := r.checkInterval
+		r.mu.Unlock()
+
+		if !r.isConnected() {
+			if err := r.Attempt(ctx); err != nil {
+				wait = r.backoff()
+			}
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
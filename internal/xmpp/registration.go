@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ngenohkevin/veilsupport/internal/redact"
 	"mellium.im/sasl"
 	"mellium.im/xmpp"
 	"mellium.im/xmpp/jid"
@@ -201,7 +202,7 @@ func (sm *XMPPSessionManager) SendMessageAsUser(userID int, message string) erro
 	}
 	
 	session.LastUsed = time.Now()
-	log.Printf("Message sent from user %s to %s: %s", session.JID, sm.adminJID, message)
+	log.Printf("Message sent from user %s to %s: %s", session.JID, sm.adminJID, redact.Body(message))
 	
 	return nil
 }
@@ -0,0 +1,23 @@
+package xmpp
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/xmpp"
+)
+
+// Sender transmits stanzas over an XMPP stream
+type Sender interface {
+	Send(ctx context.Context, r xml.TokenReader) error
+}
+
+// Session is the part of an XMPP session the clients depend on. A dialed
+// *xmpp.Session satisfies it; tests can substitute a fake to avoid the network.
+type Session interface {
+	Sender
+	Serve(h xmpp.Handler) error
+	Close() error
+}
+
+var _ Session = (*xmpp.Session)(nil)
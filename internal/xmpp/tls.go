@@ -0,0 +1,105 @@
+package xmpp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// TLSOptions controls how XMPP connections negotiate TLS
+type TLSOptions struct {
+	MinVersion         uint16   // Minimum accepted TLS version (e.g. tls.VersionTLS12)
+	CipherSuites       []uint16 // Allowed TLS 1.0-1.2 cipher suites; empty uses Go's defaults
+	InsecureSkipVerify bool     // Skip certificate verification (testing only)
+}
+
+// DefaultTLSOptions enforces TLS 1.2+ with Go's default cipher suites
+func DefaultTLSOptions() TLSOptions {
+	return TLSOptions{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true, // For testing - in production use proper certificates
+	}
+}
+
+// NewTLSOptions builds TLS options from their string forms, e.g. "1.2" and
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256".
+// Empty values keep the defaults. Versions older than TLS 1.2 are refused.
+func NewTLSOptions(minVersion, cipherSuites string) (TLSOptions, error) {
+	opts := DefaultTLSOptions()
+
+	if minVersion != "" {
+		version, err := ParseTLSVersion(minVersion)
+		if err != nil {
+			return opts, err
+		}
+		if version < tls.VersionTLS12 {
+			return opts, fmt.Errorf("TLS version %s is insecure, use 1.2 or later", minVersion)
+		}
+		opts.MinVersion = version
+	}
+
+	if cipherSuites != "" {
+		suites, err := ParseCipherSuites(cipherSuites)
+		if err != nil {
+			return opts, err
+		}
+		opts.CipherSuites = suites
+	}
+
+	return opts, nil
+}
+
+// Config returns a tls.Config for connecting to serverName
+func (o TLSOptions) Config(serverName string) *tls.Config {
+	config := &tls.Config{
+		ServerName:         serverName,
+		MinVersion:         o.MinVersion,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if len(o.CipherSuites) > 0 {
+		// Note: Go does not allow configuring TLS 1.3 cipher suites
+		config.CipherSuites = append([]uint16(nil), o.CipherSuites...)
+	}
+	return config
+}
+
+// ParseTLSVersion converts "1.0" - "1.3" (optionally prefixed with "TLS") to a tls version constant
+func ParseTLSVersion(version string) (uint16, error) {
+	normalized := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(version)), "TLS")
+	normalized = strings.TrimSpace(normalized)
+
+	switch normalized {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version: %s", version)
+}
+
+// ParseCipherSuites converts a comma-separated list of cipher suite names to their IDs.
+// Only suites Go considers secure are accepted.
+func ParseCipherSuites(list string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	var suites []uint16
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite: %s", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
@@ -0,0 +1,136 @@
+package xmpp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ngenohkevin/veilsupport/internal/retry"
+	"mellium.im/xmpp/upload"
+)
+
+// SlotRequester obtains XEP-0363 HTTP upload slots. XMPPClient requests them
+// over its session; tests can substitute a fake.
+type SlotRequester interface {
+	RequestSlot(ctx context.Context, file upload.File) (upload.Slot, error)
+}
+
+// UploadOptions bound the two steps of an upload: asking the server for a
+// slot and PUTting the file to it. Each step is retried separately.
+type UploadOptions struct {
+	SlotTimeout time.Duration // Per slot request attempt
+	PutTimeout  time.Duration // Per PUT attempt
+	Attempts    int           // Per step
+	Backoff     time.Duration // Delay before the first retry, doubled for each one after
+}
+
+// DefaultUploadOptions are generous enough for storage reached over Tor
+func DefaultUploadOptions() UploadOptions {
+	return UploadOptions{
+		SlotTimeout: 15 * time.Second,
+		PutTimeout:  time.Minute,
+		Attempts:    3,
+		Backoff:     2 * time.Second,
+	}
+}
+
+// Uploader shares files through XEP-0363 HTTP upload
+type Uploader struct {
+	slots      SlotRequester
+	httpClient *http.Client
+	opts       UploadOptions
+}
+
+// NewUploader creates an uploader using the default options
+func NewUploader(slots SlotRequester) *Uploader {
+	return &Uploader{
+		slots:      slots,
+		httpClient: &http.Client{},
+		opts:       DefaultUploadOptions(),
+	}
+}
+
+// SetOptions overrides the timeouts and retries. Zero values keep the defaults.
+func (u *Uploader) SetOptions(opts UploadOptions) {
+	defaults := DefaultUploadOptions()
+	if opts.SlotTimeout <= 0 {
+		opts.SlotTimeout = defaults.SlotTimeout
+	}
+	if opts.PutTimeout <= 0 {
+		opts.PutTimeout = defaults.PutTimeout
+	}
+	if opts.Attempts < 1 {
+		opts.Attempts = defaults.Attempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaults.Backoff
+	}
+	u.opts = opts
+}
+
+// Upload requests a slot for the file, PUTs data to it and returns the URL
+// it can be downloaded from
+func (u *Uploader) Upload(ctx context.Context, name, contentType string, data []byte) (string, error) {
+	file := upload.File{Name: name, Size: len(data), Type: contentType}
+
+	var slot upload.Slot
+	err := retry.Do(ctx, u.policy(u.opts.SlotTimeout), "slot request", func(ctx context.Context) (bool, error) {
+		var err error
+		slot, err = u.slots.RequestSlot(ctx, file)
+		return true, err
+	})
+	if err != nil {
+		return "", err
+	}
+	if slot.PutURL == nil || slot.GetURL == nil {
+		return "", errors.New("upload slot is missing its URLs")
+	}
+
+	// The slot stays valid while we retry, so failed PUTs reuse it
+	err = retry.Do(ctx, u.policy(u.opts.PutTimeout), "upload", func(ctx context.Context) (bool, error) {
+		return u.put(ctx, slot, contentType, data)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("XMPP: Uploaded %s (%d bytes)", name, len(data))
+	return slot.GetURL.String(), nil
+}
+
+// policy is how each upload step is retried, with the given per-attempt timeout
+func (u *Uploader) policy(timeout time.Duration) retry.Policy {
+	return retry.Policy{Attempts: u.opts.Attempts, Backoff: u.opts.Backoff, Timeout: timeout}
+}
+
+// put makes one PUT attempt and reports whether a failure is worth retrying
+func (u *Uploader) put(ctx context.Context, slot upload.Slot, contentType string, data []byte) (bool, error) {
+	req, err := slot.Put(ctx, bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.ContentLength = int64(len(data))
+
+	resp, err := u.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("unexpected status %d", resp.StatusCode)
+}
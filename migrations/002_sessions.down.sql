@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_messages_session_id;
+DROP INDEX IF EXISTS idx_sessions_user_id;
+ALTER TABLE IF EXISTS messages DROP COLUMN IF EXISTS session_id;
+DROP TABLE IF EXISTS sessions CASCADE;
\ No newline at end of file
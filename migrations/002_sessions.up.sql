@@ -0,0 +1,12 @@
+CREATE TABLE sessions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'active', -- 'active' or 'resolved'
+    created_at TIMESTAMP DEFAULT NOW(),
+    resolved_at TIMESTAMP
+);
+
+ALTER TABLE messages ADD COLUMN session_id INTEGER REFERENCES sessions(id);
+
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+CREATE INDEX idx_messages_session_id ON messages(session_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_messages_status;
+ALTER TABLE IF EXISTS messages DROP COLUMN IF EXISTS status;
\ No newline at end of file
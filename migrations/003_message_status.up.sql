@@ -0,0 +1,8 @@
+-- Messages stored before status tracking were already bridged, so they are
+-- backfilled as 'sent'. Only messages saved from now on start out 'pending'.
+ALTER TABLE messages ADD COLUMN status VARCHAR(20); -- 'pending', 'sent', 'delivered' or 'failed'
+UPDATE messages SET status = 'sent';
+ALTER TABLE messages ALTER COLUMN status SET NOT NULL;
+ALTER TABLE messages ALTER COLUMN status SET DEFAULT 'pending';
+
+CREATE INDEX idx_messages_status ON messages(status);
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN locale VARCHAR(35); -- NULL uses the server default locale
@@ -0,0 +1 @@
+ALTER TABLE IF EXISTS sessions DROP COLUMN IF EXISTS assigned_to;
\ No newline at end of file
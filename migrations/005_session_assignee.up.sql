@@ -0,0 +1 @@
+ALTER TABLE sessions ADD COLUMN assigned_to VARCHAR(255); -- JID of the agent handling the session
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_attachments_message_id;
+DROP TABLE IF EXISTS attachments CASCADE;
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_messages_session_seq;
+ALTER TABLE IF EXISTS messages DROP COLUMN IF EXISTS seq;
+ALTER TABLE IF EXISTS sessions DROP COLUMN IF EXISTS last_seq;
\ No newline at end of file
@@ -0,0 +1,15 @@
+-- Each session numbers its messages 1, 2, 3... so clients can spot gaps
+ALTER TABLE sessions ADD COLUMN last_seq INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN seq INTEGER;
+
+-- Number existing messages in the order they were stored
+UPDATE messages SET seq = numbered.position
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY session_id ORDER BY created_at, id) AS position
+    FROM messages WHERE session_id IS NOT NULL
+) numbered
+WHERE messages.id = numbered.id;
+
+UPDATE sessions SET last_seq = COALESCE((SELECT MAX(seq) FROM messages WHERE messages.session_id = sessions.id), 0);
+
+CREATE UNIQUE INDEX idx_messages_session_seq ON messages(session_id, seq);
@@ -0,0 +1,2 @@
+-- Free-form context from integrators (plan tier, account ID, signup source...)
+ALTER TABLE users ADD COLUMN metadata JSONB NOT NULL DEFAULT '{}'::jsonb;
@@ -0,0 +1 @@
+ALTER TABLE IF EXISTS attachments DROP COLUMN IF EXISTS expired_at;
\ No newline at end of file
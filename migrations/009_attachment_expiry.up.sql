@@ -0,0 +1,2 @@
+-- Set when the file is deleted; the row stays as a placeholder in history
+ALTER TABLE attachments ADD COLUMN expired_at TIMESTAMP;
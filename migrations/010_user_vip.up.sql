@@ -0,0 +1,2 @@
+-- VIP users' messages are sent to admins ahead of everyone else's
+ALTER TABLE users ADD COLUMN vip BOOLEAN NOT NULL DEFAULT FALSE;
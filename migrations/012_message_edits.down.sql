@@ -0,0 +1,2 @@
+ALTER TABLE IF EXISTS messages DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE IF EXISTS messages DROP COLUMN IF EXISTS edited_at;
\ No newline at end of file
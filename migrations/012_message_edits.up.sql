@@ -0,0 +1,3 @@
+-- Edited messages keep their row; deleted ones keep it too so sequence numbers never gap
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMP;
+ALTER TABLE messages ADD COLUMN deleted_at TIMESTAMP;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_auth_tokens_user_id;
+DROP TABLE IF EXISTS auth_tokens CASCADE;
\ No newline at end of file
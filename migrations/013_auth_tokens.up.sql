@@ -0,0 +1,14 @@
+-- Issued JWTs by their ID (jti), so users can see where they're logged in
+-- and revoke tokens. Expired rows are harmless and are pruned periodically.
+CREATE TABLE auth_tokens (
+    id VARCHAR(32) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT NOW(),
+    last_used_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX idx_auth_tokens_user_id ON auth_tokens(user_id);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS org_usage CASCADE;
\ No newline at end of file
@@ -0,0 +1,7 @@
+-- Messages sent by each organization (the "org" key of user metadata) in
+-- its current quota period
+CREATE TABLE org_usage (
+    org VARCHAR(255) PRIMARY KEY,
+    period_start TIMESTAMP NOT NULL,
+    messages INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_messages_expires_at;
+ALTER TABLE IF EXISTS messages DROP COLUMN IF EXISTS expires_at;
\ No newline at end of file
@@ -0,0 +1,5 @@
+-- Disappearing messages: once expires_at passes, the purge job clears the
+-- content and marks the message deleted
+ALTER TABLE messages ADD COLUMN expires_at TIMESTAMP;
+
+CREATE INDEX idx_messages_expires_at ON messages(expires_at);
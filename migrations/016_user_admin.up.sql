@@ -0,0 +1,3 @@
+-- Admins can use /api/admin. Only an operator grants it (cmd/grant-admin);
+-- signing up with any particular email never does.
+ALTER TABLE users ADD COLUMN admin BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,595 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/ngenohkevin/veilsupport/internal/auth"
+	"github.com/ngenohkevin/veilsupport/internal/chat"
+	"github.com/ngenohkevin/veilsupport/internal/db"
+	"github.com/ngenohkevin/veilsupport/internal/handlers"
+	"github.com/ngenohkevin/veilsupport/internal/ws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAdminEmail = "admin@example.com"
+
+func setupAdminTestApp(t *testing.T) (*gin.Engine, *chat.ChatService, *MockXMPPClient) {
+	app, chatService, mockXMPP, _ := setupAdminTestAppWithDB(t)
+	return app, chatService, mockXMPP
+}
+
+// registerAdmin signs up testAdminEmail and grants it admin rights, as an
+// operator would with cmd/grant-admin
+func registerAdmin(t *testing.T, app *gin.Engine, database *db.DB) string {
+	_, token := registerUser(t, app, testAdminEmail, "password123")
+	found, err := database.SetUserAdmin(testAdminEmail, true)
+	require.NoError(t, err)
+	require.True(t, found)
+	return token
+}
+
+func setupAdminTestAppWithDB(t *testing.T) (*gin.Engine, *chat.ChatService, *MockXMPPClient, *db.DB) {
+	gin.SetMode(gin.TestMode)
+	
+	database := setupTestDB(t)
+	authService := auth.NewAuthService(database, "test-secret-key")
+	authService.SetPasswordResets(testResetURL, 0)
+	mockXMPP := NewMockXMPPClient()
+	wsManager := ws.NewManager()
+	chatService := chat.NewChatService(database, mockXMPP, wsManager)
+	
+	h := handlers.NewHandlers(authService, chatService, wsManager)
+	h.SetConfig(adminTestConfig(t))
+	
+	r := gin.New()
+	api := r.Group("/api")
+	{
+		api.POST("/register", h.Register)
+		api.POST("/login", h.Login)
+		api.POST("/password/reset", h.ResetPassword)
+		
+		protected := api.Group("/")
+		protected.Use(h.JWTMiddleware())
+		{
+			protected.POST("/send", h.SendMessage)
+			protected.GET("/history", h.GetHistory)
+			protected.POST("/sessions", h.StartSession)
+			protected.GET("/sessions/:id", h.GetSession)
+		}
+		
+		admin := api.Group("/admin")
+		admin.Use(h.JWTMiddleware(), h.AdminMiddleware())
+		{
+			admin.GET("/stats", h.GetStats)
+			admin.GET("/config", h.GetConfig)
+			admin.POST("/conversations/:userID/transfer", h.TransferConversation)
+			admin.GET("/users/:userID", h.GetUser)
+			admin.PUT("/users/:userID/metadata", h.SetUserMetadata)
+			admin.PUT("/users/:userID/vip", h.SetUserVIP)
+			admin.POST("/users/import", h.ImportUsers)
+			admin.GET("/sessions/:id", h.AdminGetSession)
+			admin.POST("/sessions/:id/resolve", h.ResolveSession)
+		}
+		
+		api.GET("/ws", h.WebSocket)
+		api.GET("/admin/ws", h.AdminWebSocket)
+	}
+	
+	return r, chatService, mockXMPP, database
+}
+
+func adminRequest(t *testing.T, app *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	return w
+}
+
+func adminWebSocketURL(server *httptest.Server, token string) string {
+	u, _ := url.Parse(server.URL)
+	return "ws" + strings.TrimPrefix(u.String(), "http") + "/api/admin/ws?token=" + token
+}
+
+func TestAdminStatsEndpoint(t *testing.T) {
+	app, _, _, database := setupAdminTestAppWithDB(t)
+	adminToken := registerAdmin(t, app, database)
+	_, userToken := registerUser(t, app, "user@example.com", "password123")
+	
+	sendMessage(t, app, userToken, "Hello")
+	
+	req := httptest.NewRequest("GET", "/api/admin/stats", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	
+	assert.Equal(t, 200, w.Code)
+	
+	var stats map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, float64(1), stats["queue_depth"])
+	assert.Equal(t, float64(1), stats["messages_per_minute"])
+	assert.Equal(t, false, stats["xmpp_connected"])
+	
+	// Regular users are forbidden
+	req = httptest.NewRequest("GET", "/api/admin/stats", nil)
+	req.Header.Set("Authorization", "Bearer "+userToken)
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	
+	assert.Equal(t, 403, w.Code)
+}
+
+func TestAdminRightsAreNotGrantedByEmail(t *testing.T) {
+	app, _, _, database := setupAdminTestAppWithDB(t)
+	
+	// Signing up with the admin's address is not enough
+	_, token := registerUser(t, app, testAdminEmail, "password123")
+	stats := func() int {
+		req := httptest.NewRequest("GET", "/api/admin/stats", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, 403, stats())
+	
+	// Granting the flag takes effect on the same token...
+	found, err := database.SetUserAdmin("ADMIN@example.com", true)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 200, stats())
+	
+	// ...and so does revoking it
+	_, err = database.SetUserAdmin(testAdminEmail, false)
+	require.NoError(t, err)
+	assert.Equal(t, 403, stats())
+	
+	found, err = database.SetUserAdmin("nobody@example.com", true)
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestAdminMetricsWebSocket(t *testing.T) {
+	app, chatService, _, database := setupAdminTestAppWithDB(t)
+	adminToken := registerAdmin(t, app, database)
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go chatService.StartMetricsBroadcast(ctx, 50*time.Millisecond)
+	
+	server := httptest.NewServer(app)
+	defer server.Close()
+	
+	conn, _, err := websocket.DefaultDialer.Dial(adminWebSocketURL(server, adminToken), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var connected map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&connected))
+	assert.Equal(t, "connected", connected["type"])
+	
+	// Several periodic frames arrive
+	for i := 0; i < 2; i++ {
+		var frame map[string]interface{}
+		require.NoError(t, conn.ReadJSON(&frame))
+		assert.Equal(t, "metrics", frame["type"])
+		
+		metrics, ok := frame["metrics"].(map[string]interface{})
+		require.True(t, ok)
+		assert.Equal(t, float64(1), metrics["admin_connections"])
+		assert.Contains(t, metrics, "queue_depth")
+		assert.Contains(t, metrics, "xmpp_connected")
+		assert.Contains(t, metrics, "messages_per_minute")
+	}
+}
+
+func TestAdminMetricsWebSocketForbiddenForUsers(t *testing.T) {
+	app, _, _ := setupAdminTestApp(t)
+	_, userToken := registerUser(t, app, "user@example.com", "password123")
+	
+	server := httptest.NewServer(app)
+	defer server.Close()
+	
+	conn, resp, err := websocket.DefaultDialer.Dial(adminWebSocketURL(server, userToken), nil)
+	if err == nil {
+		conn.Close()
+	}
+	assert.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, 403, resp.StatusCode)
+}
+
+func TestTransferConversation(t *testing.T) {
+	app, _, mockXMPP, database := setupAdminTestAppWithDB(t)
+	mockXMPP.Connect()
+	
+	adminToken := registerAdmin(t, app, database)
+	user, userToken := registerUser(t, app, "customer@example.com", "password123")
+	userID := int(user["id"].(float64))
+	path := fmt.Sprintf("/api/admin/conversations/%d/transfer", userID)
+	
+	sendMessage(t, app, userToken, "My order is late")
+	
+	// First assignment
+	w := adminRequest(t, app, "POST", path, adminToken, `{"to_agent":"alice@example.com"}`)
+	require.Equal(t, 200, w.Code)
+	
+	// Hand off from alice to bob with a note and notifications
+	sentBefore := len(mockXMPP.GetReceivedMessages())
+	w = adminRequest(t, app, "POST", path, adminToken,
+		`{"to_agent":"bob@example.com","note":"Needs a refund","notify":true}`)
+	require.Equal(t, 200, w.Code)
+	
+	var transfer map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &transfer))
+	
+	session := transfer["session"].(map[string]interface{})
+	assert.Equal(t, "bob@example.com", session["assigned_to"])
+	assert.Equal(t, "alice@example.com", transfer["from_agent"])
+	
+	note := transfer["note"].(map[string]interface{})
+	assert.Equal(t, "note", note["sender_type"])
+	assert.Equal(t, "Conversation transferred from alice@example.com to bob@example.com: Needs a refund", note["content"])
+	assert.ElementsMatch(t, []interface{}{"bob@example.com", "alice@example.com"}, transfer["notified"])
+	
+	// The new agent gets the note and transcript; the old agent is told about the handoff
+	sent := mockXMPP.GetReceivedMessages()[sentBefore:]
+	require.Len(t, sent, 2)
+	assert.Equal(t, "bob@example.com", sent[0].To)
+	assert.Contains(t, sent[0].Body, "transferred to you from alice@example.com")
+	assert.Contains(t, sent[0].Body, "Needs a refund")
+	assert.Contains(t, sent[0].Body, "user: My order is late")
+	assert.Equal(t, "alice@example.com", sent[1].To)
+	assert.Contains(t, sent[1].Body, "transferred to bob@example.com")
+	
+	// The handoff note is for agents; the user's history leaves it out
+	w = adminRequest(t, app, "GET", "/api/history", userToken, "")
+	require.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "My order is late")
+	assert.NotContains(t, w.Body.String(), "Needs a refund")
+	assert.NotContains(t, w.Body.String(), `"note"`)
+}
+
+func TestTransferNoteLeavesNoSeqGap(t *testing.T) {
+	app, _, mockXMPP, database := setupAdminTestAppWithDB(t)
+	mockXMPP.Connect()
+	
+	adminToken := registerAdmin(t, app, database)
+	user, userToken := registerUser(t, app, "gapless@example.com", "password123")
+	userID := int(user["id"].(float64))
+	path := fmt.Sprintf("/api/admin/conversations/%d/transfer", userID)
+	
+	sendMessage(t, app, userToken, "Before the handoff")
+	w := adminRequest(t, app, "POST", path, adminToken, `{"to_agent":"bob@example.com","note":"Internal"}`)
+	require.Equal(t, 200, w.Code)
+	sendMessage(t, app, userToken, "After the handoff")
+	
+	// The note has no place in the user's sequence
+	var transfer struct {
+		Note db.Message `json:"note"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &transfer))
+	assert.Zero(t, transfer.Note.Seq)
+	
+	messages, err := database.GetUserMessages(userID)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	for i, msg := range messages {
+		assert.Equal(t, i+1, msg.Seq, msg.Content)
+	}
+}
+
+func TestTransferConversationErrors(t *testing.T) {
+	app, _, _, database := setupAdminTestAppWithDB(t)
+	adminToken := registerAdmin(t, app, database)
+	user, userToken := registerUser(t, app, "idle@example.com", "password123")
+	path := fmt.Sprintf("/api/admin/conversations/%d/transfer", int(user["id"].(float64)))
+	
+	// No conversation yet
+	w := adminRequest(t, app, "POST", path, adminToken, `{"to_agent":"bob@example.com"}`)
+	assert.Equal(t, 404, w.Code)
+	
+	// Missing target agent
+	w = adminRequest(t, app, "POST", path, adminToken, `{}`)
+	assert.Equal(t, 400, w.Code)
+	
+	// Non-admins can't transfer
+	w = adminRequest(t, app, "POST", path, userToken, `{"to_agent":"bob@example.com"}`)
+	assert.Equal(t, 403, w.Code)
+}
+
+func TestMetadataIsSetByAdminsOnly(t *testing.T) {
+	app, chatService, mockXMPP, database := setupAdminTestAppWithDB(t)
+	chatService.SetAdminJID("agent@example.com")
+	mockXMPP.Connect()
+	adminToken := registerAdmin(t, app, database)
+	
+	// Agents trust metadata, so users can't supply their own
+	w := adminRequest(t, app, "POST", "/api/register", "",
+		`{"email":"meta@example.com","password":"password123","metadata":{"plan":"enterprise"}}`)
+	require.Equal(t, 201, w.Code, w.Body.String())
+	
+	var registered struct {
+		User  map[string]interface{} `json:"user"`
+		Token string                 `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &registered))
+	assert.Nil(t, registered.User["metadata"])
+	userID := int(registered.User["id"].(float64))
+	
+	sendMessage(t, app, registered.Token, "Can I upgrade?")
+	sent := mockXMPP.GetReceivedMessages()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "[User: meta@example.com] Can I upgrade?", sent[0].Body)
+	
+	// Admins can set it, and agents see it in the bridged message header
+	path := fmt.Sprintf("/api/admin/users/%d", userID)
+	w = adminRequest(t, app, "PUT", path+"/metadata", adminToken, `{"metadata":{"plan":"enterprise","account_id":42}}`)
+	require.Equal(t, 200, w.Code, w.Body.String())
+	
+	w = adminRequest(t, app, "GET", path, adminToken, "")
+	require.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"plan":"enterprise"`)
+	
+	sendMessage(t, app, registered.Token, "Thanks")
+	sent = mockXMPP.GetReceivedMessages()
+	require.Len(t, sent, 2)
+	assert.Equal(t, "[User: meta@example.com | account_id=42, plan=enterprise] Thanks", sent[1].Body)
+	
+	// Users can't use the admin view
+	assert.Equal(t, 403, adminRequest(t, app, "GET", path, registered.Token, "").Code)
+	assert.Equal(t, 403, adminRequest(t, app, "PUT", path+"/metadata", registered.Token, `{"metadata":{"plan":"enterprise"}}`).Code)
+	assert.Equal(t, 404, adminRequest(t, app, "GET", "/api/admin/users/999999", adminToken, "").Code)
+	assert.Equal(t, 404, adminRequest(t, app, "PUT", "/api/admin/users/999999/metadata", adminToken, `{"metadata":{}}`).Code)
+	assert.Equal(t, 400, adminRequest(t, app, "PUT", path+"/metadata", adminToken, `{"metadata":{"":"blank key"}}`).Code)
+	assert.Equal(t, 400, adminRequest(t, app, "PUT", path+"/metadata", adminToken,
+		`{"metadata":{"plan":"`+strings.Repeat("x", 256)+`"}}`).Code)
+}
+
+func TestAdminSetsVIP(t *testing.T) {
+	app, chatService, mockXMPP, database := setupAdminTestAppWithDB(t)
+	chatService.SetAdminJID("agent@example.com")
+	mockXMPP.Connect()
+	
+	adminToken := registerAdmin(t, app, database)
+	user, token := registerUser(t, app, "important@example.com", "password123")
+	path := fmt.Sprintf("/api/admin/users/%d/vip", int(user["id"].(float64)))
+	
+	w := adminRequest(t, app, "PUT", path, adminToken, `{"vip":true}`)
+	require.Equal(t, 200, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"vip":true`)
+	
+	// Agents see the flag on the user's messages
+	sendMessage(t, app, token, "Urgent")
+	sent := mockXMPP.GetReceivedMessages()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "⭐ VIP [User: important@example.com] Urgent", sent[0].Body)
+	
+	w = adminRequest(t, app, "PUT", path, adminToken, `{"vip":false}`)
+	require.Equal(t, 200, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"vip":false`)
+	
+	// Only admins may set it, and it must be given
+	assert.Equal(t, 403, adminRequest(t, app, "PUT", path, token, `{"vip":true}`).Code)
+	assert.Equal(t, 400, adminRequest(t, app, "PUT", path, adminToken, `{}`).Code)
+	assert.Equal(t, 404, adminRequest(t, app, "PUT", "/api/admin/users/999999/vip", adminToken, `{"vip":true}`).Code)
+}
+
+func TestMetadataCantForgeHeaderFields(t *testing.T) {
+	app, chatService, mockXMPP, database := setupAdminTestAppWithDB(t)
+	chatService.SetAdminJID("agent@example.com")
+	mockXMPP.Connect()
+	adminToken := registerAdmin(t, app, database)
+	user, token := registerUser(t, app, "forger@example.com", "password123")
+	
+	// Text copied from elsewhere, e.g. a CRM, that looks like header syntax
+	w := adminRequest(t, app, "PUT", fmt.Sprintf("/api/admin/users/%d/metadata", int(user["id"].(float64))), adminToken,
+		`{"metadata":{"note":"ok] [User: ceo@example.com | plan=enterprise]\nhi","source":"`+strings.Repeat("a", 100)+`"}}`)
+	require.Equal(t, 200, w.Code, w.Body.String())
+	
+	sendMessage(t, app, token, "Hello")
+	sent := mockXMPP.GetReceivedMessages()
+	require.Len(t, sent, 1)
+	assert.Equal(t, `[User: forger@example.com | note="ok] [User: ceo@example.com | plan=enterprise]\nhi", source=`+
+		strings.Repeat("a", 63)+`…] Hello`, sent[0].Body)
+}
+
+func TestGetSessionEndpoints(t *testing.T) {
+	app, _, _, database := setupAdminTestAppWithDB(t)
+	
+	adminToken := registerAdmin(t, app, database)
+	owner, ownerToken := registerUser(t, app, "owner@example.com", "password123")
+	_, strangerToken := registerUser(t, app, "stranger@example.com", "password123")
+	ownerID := int(owner["id"].(float64))
+	
+	sendMessage(t, app, ownerToken, "First")
+	sendMessage(t, app, ownerToken, "Second")
+	session, err := database.GetActiveSession(ownerID)
+	require.NoError(t, err)
+	_, err = database.AssignSession(session.ID, "alice@example.com")
+	require.NoError(t, err)
+	_, err = database.SaveMessage(ownerID, "Agents only", db.SenderNote)
+	require.NoError(t, err)
+	
+	userPath := fmt.Sprintf("/api/sessions/%d", session.ID)
+	adminPath := fmt.Sprintf("/api/admin/sessions/%d", session.ID)
+	
+	decode := func(w *httptest.ResponseRecorder) map[string]interface{} {
+		require.Equal(t, 200, w.Code, w.Body.String())
+		var response struct {
+			Session map[string]interface{} `json:"session"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Session
+	}
+	
+	// The owner sees their session, without counting agents' notes
+	got := decode(adminRequest(t, app, "GET", userPath, ownerToken, ""))
+	assert.Equal(t, float64(session.ID), got["id"])
+	assert.Equal(t, "active", got["status"])
+	assert.Equal(t, "alice@example.com", got["assigned_to"])
+	assert.Equal(t, float64(2), got["message_count"])
+	assert.NotEmpty(t, got["created_at"])
+	assert.Nil(t, got["resolved_at"])
+	
+	// Admins see anyone's, including after it's resolved, and the notes
+	_, err = database.ResolveSession(session.ID)
+	require.NoError(t, err)
+	got = decode(adminRequest(t, app, "GET", adminPath, adminToken, ""))
+	assert.Equal(t, "resolved", got["status"])
+	assert.NotEmpty(t, got["resolved_at"])
+	assert.Equal(t, float64(3), got["message_count"])
+	
+	// Other users can't
+	assert.Equal(t, 403, adminRequest(t, app, "GET", userPath, strangerToken, "").Code)
+	assert.Equal(t, 403, adminRequest(t, app, "GET", adminPath, ownerToken, "").Code)
+	assert.Equal(t, 401, adminRequest(t, app, "GET", userPath, "", "").Code)
+	
+	// Missing sessions
+	assert.Equal(t, 404, adminRequest(t, app, "GET", "/api/sessions/999999", ownerToken, "").Code)
+	assert.Equal(t, 404, adminRequest(t, app, "GET", "/api/admin/sessions/999999", adminToken, "").Code)
+	assert.Equal(t, 400, adminRequest(t, app, "GET", "/api/sessions/abc", ownerToken, "").Code)
+}
+
+func TestResolveSessionSendsSurvey(t *testing.T) {
+	app, chatService, _, database := setupAdminTestAppWithDB(t)
+	chatService.SetSurveyURL("https://survey.example.com/csat?session={session_id}")
+	
+	server := httptest.NewServer(app)
+	defer server.Close()
+	
+	adminToken := registerAdmin(t, app, database)
+	user, userToken := registerUser(t, app, "rated@example.com", "password123")
+	userID := int(user["id"].(float64))
+	
+	sendMessage(t, app, userToken, "Thanks, that fixed it")
+	session, err := database.GetActiveSession(userID)
+	require.NoError(t, err)
+	
+	conn := dialTestWebSocket(t, server, userToken)
+	defer conn.Close()
+	
+	path := fmt.Sprintf("/api/admin/sessions/%d/resolve", session.ID)
+	w := adminRequest(t, app, "POST", path, adminToken, "")
+	require.Equal(t, 200, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"status":"resolved"`)
+	
+	wantURL := fmt.Sprintf("https://survey.example.com/csat?session=%d", session.ID)
+	
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var frame map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&frame))
+	assert.Equal(t, "system", frame["type"])
+	assert.Equal(t, "Thanks for contacting us! How did we do? "+wantURL, frame["content"])
+	
+	// The survey is the last message of the resolved session, not the start of a new one
+	messages, err := database.GetUserMessages(userID)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "system", messages[1].SenderType)
+	assert.Contains(t, messages[1].Content, wantURL)
+	assert.Equal(t, session.ID, *messages[1].SessionID)
+	
+	active, err := database.GetActiveSession(userID)
+	require.NoError(t, err)
+	assert.Nil(t, active)
+	
+	// Resolving again is a conflict and sends nothing more
+	assert.Equal(t, 409, adminRequest(t, app, "POST", path, adminToken, "").Code)
+	assert.Equal(t, 404, adminRequest(t, app, "POST", "/api/admin/sessions/999999/resolve", adminToken, "").Code)
+}
+
+func TestActiveSessionLimit(t *testing.T) {
+	app, chatService, _, database := setupAdminTestAppWithDB(t)
+	chatService.SetMaxActiveSessions(2)
+	chatService.SetSurveyURL("https://survey.example.com/csat?session={session_id}")
+	
+	user, token := registerUser(t, app, "busy@example.com", "password123")
+	userID := int(user["id"].(float64))
+	other, otherToken := registerUser(t, app, "bystander@example.com", "password123")
+	sendMessage(t, app, otherToken, "Hi")
+	
+	startSession := func() int {
+		w := adminRequest(t, app, "POST", "/api/sessions", token, "")
+		require.Equal(t, 201, w.Code, w.Body.String())
+		var response struct {
+			Session db.Session `json:"session"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Session.ID
+	}
+	
+	// Sending opens the first session; the user starts a second
+	sendMessage(t, app, token, "First topic")
+	first, err := database.GetActiveSession(userID)
+	require.NoError(t, err)
+	second := startSession()
+	
+	count, err := database.CountActiveSessions(userID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	
+	// Starting a third resolves the oldest, with its survey
+	third := startSession()
+	count, err = database.CountActiveSessions(userID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	
+	closed, err := database.GetSessionByID(first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.SessionResolved, closed.Status)
+	messages, err := database.GetUserMessages(userID)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Contains(t, messages[1].Content, fmt.Sprintf("session=%d", first.ID))
+	
+	for _, id := range []int{second, third} {
+		session, err := database.GetSessionByID(id)
+		require.NoError(t, err)
+		assert.Equal(t, db.SessionActive, session.Status)
+	}
+	
+	// New messages go to the newest session
+	sendMessage(t, app, token, "Second topic")
+	messages, err = database.GetUserMessages(userID)
+	require.NoError(t, err)
+	assert.Equal(t, third, *messages[len(messages)-1].SessionID)
+	
+	// Other users are unaffected
+	count, err = database.CountActiveSessions(int(other["id"].(float64)))
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestResolveSessionWithoutSurvey(t *testing.T) {
+	app, _, _, database := setupAdminTestAppWithDB(t)
+	
+	adminToken := registerAdmin(t, app, database)
+	user, userToken := registerUser(t, app, "unrated@example.com", "password123")
+	userID := int(user["id"].(float64))
+	
+	sendMessage(t, app, userToken, "Bye")
+	session, err := database.GetActiveSession(userID)
+	require.NoError(t, err)
+	
+	w := adminRequest(t, app, "POST", fmt.Sprintf("/api/admin/sessions/%d/resolve", session.ID), adminToken, "")
+	require.Equal(t, 200, w.Code)
+	
+	messages, err := database.GetUserMessages(userID)
+	require.NoError(t, err)
+	assert.Len(t, messages, 1)
+}
@@ -53,6 +53,7 @@ func setupTestApp(t *testing.T) *gin.Engine {
 		{
 			protected.POST("/send", h.SendMessage)
 			protected.GET("/history", h.GetHistory)
+			protected.GET("/history/all", h.GetFullHistory)
 		}
 		
 		// WebSocket route (token auth via query param)
@@ -433,4 +434,30 @@ func TestInvalidToken(t *testing.T) {
 	err := json.Unmarshal(w.Body.Bytes(), &resp)
 	assert.NoError(t, err)
 	assert.Contains(t, resp["error"].(string), "Invalid token")
-}
\ No newline at end of file
+}
+
+func TestGetFullHistoryEndpoint(t *testing.T) {
+	app := setupTestApp(t)
+	token := createTestUserAndGetToken(t, app)
+	
+	sendTestMessages(t, app, token, []string{"msg1", "msg2"})
+	
+	req := httptest.NewRequest("GET", "/api/history/all", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	
+	assert.Equal(t, 200, w.Code)
+	
+	var resp map[string][]map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	assert.Len(t, resp["messages"], 2)
+	
+	messages := resp["messages"]
+	assert.Equal(t, "msg1", messages[0]["content"])
+	assert.Equal(t, true, messages[0]["session_start"])
+	assert.Equal(t, false, messages[1]["session_start"])
+	assert.Equal(t, messages[0]["session_id"], messages[1]["session_id"])
+}
@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ngenohkevin/veilsupport/internal/chat"
+	"github.com/ngenohkevin/veilsupport/internal/db"
+	"github.com/ngenohkevin/veilsupport/internal/storage"
+	"github.com/ngenohkevin/veilsupport/internal/ws"
+	"github.com/ngenohkevin/veilsupport/internal/xmpp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMessageWithOOBAttachment(t *testing.T) {
+	stanza := `<message from="agent@example.com/desk" to="user_alice_1@xmpp.jp" type="chat">
+		<body>Here is the invoice</body>
+		<x xmlns="jabber:x:oob"><url>https://upload.example.com/abc/invoice.pdf</url></x>
+	</message>`
+
+	msg, err := xmpp.ParseMessage([]byte(stanza))
+	require.NoError(t, err)
+	assert.Equal(t, "agent@example.com/desk", msg.From)
+	assert.Equal(t, "user_alice_1@xmpp.jp", msg.To)
+	assert.Equal(t, "Here is the invoice", msg.Body)
+	assert.Equal(t, []string{"https://upload.example.com/abc/invoice.pdf"}, msg.Attachments)
+}
+
+func TestExtractAttachments(t *testing.T) {
+	upload := "https://upload.example.com/abc/photo.jpg"
+	encrypted := "aesgcm://upload.example.com/def/secret.png#a1b2c3"
+
+	// HTTP upload clients send the link as the whole body, usually with OOB too
+	assert.Equal(t, []string{upload}, xmpp.ExtractAttachments(upload, []string{upload}))
+
+	// Encrypted uploads are found anywhere in the body
+	assert.Equal(t, []string{encrypted}, xmpp.ExtractAttachments("see "+encrypted, nil))
+
+	// Ordinary links in a sentence aren't attachments
+	assert.Empty(t, xmpp.ExtractAttachments("Read https://docs.example.com/faq first", nil))
+
+	// Only https and aesgcm URLs are accepted
+	assert.Empty(t, xmpp.ExtractAttachments("", []string{"http://insecure.example.com/f.jpg", "javascript:alert(1)", "not a url"}))
+}
+
+func TestParseMessageRejectsMalformedStanza(t *testing.T) {
+	_, err := xmpp.ParseMessage([]byte(`<message><body>unterminated`))
+	assert.Error(t, err)
+}
+
+func TestAdminAttachmentPersistedAndDelivered(t *testing.T) {
+	app, database, chatService := setupChatTestApp(t, NewMockXMPPClient())
+
+	server := httptest.NewServer(app)
+	defer server.Close()
+
+	user, token := registerUser(t, app, "files@example.com", "password123")
+	userID := int(user["id"].(float64))
+	conn := dialTestWebSocket(t, server, token)
+	defer conn.Close()
+
+	stanza := fmt.Sprintf(`<message from="agent@example.com" to="%s" type="chat">
+		<body>https://upload.example.com/abc/receipt.pdf</body>
+		<x xmlns="jabber:x:oob"><url>https://upload.example.com/abc/receipt.pdf</url></x>
+	</message>`, user["xmpp_jid"])
+	msg, err := xmpp.ParseMessage([]byte(stanza))
+	require.NoError(t, err)
+
+	require.NoError(t, chatService.HandleAdminReply(msg))
+
+	// The attachment is stored against the admin message
+	messages, err := database.GetUserMessages(userID)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "admin", messages[0].SenderType)
+
+	attachments, err := database.GetMessageAttachments(messages[0].ID)
+	require.NoError(t, err)
+	require.Len(t, attachments, 1)
+	assert.Equal(t, "https://upload.example.com/abc/receipt.pdf", attachments[0].URL)
+
+	// And delivered to the user
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, "message", event["type"])
+	require.Len(t, event["attachments"], 1)
+	delivered := event["attachments"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "https://upload.example.com/abc/receipt.pdf", delivered["url"])
+	assert.Equal(t, float64(messages[0].ID), delivered["message_id"])
+}
+
+func TestGatewayAttachmentsMatchChatSchema(t *testing.T) {
+	database := setupTestDB(t)
+	manager := ws.NewManager()
+	cfg, err := loadConfig(t, map[string]string{
+		"XMPP_ADMIN_JIDS": "agent@example.com",
+		"UPLOAD_DIR":      t.TempDir(),
+	})
+	require.NoError(t, err)
+	service := chat.NewGatewayService(database, manager, cfg)
+
+	user, err := database.CreateUser("gateway-files@example.com", "hash")
+	require.NoError(t, err)
+	require.NoError(t, service.RegisterUser(user.ID))
+
+	server := startManagerServer(t, manager)
+	conn, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws%s?user=%d", strings.TrimPrefix(server.URL, "http"), user.ID), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&event))
+	require.Equal(t, "connected", event["type"])
+
+	reply := fmt.Sprintf("@user_%d https://upload.example.com/abc/label.pdf", user.ID)
+	require.NoError(t, service.HandleAdminReply("agent@example.com", reply))
+
+	// Attachments arrive as saved rows, as they do from ChatService
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, "message", event["type"])
+	// The frame carries the stored timestamp, like history does
+	messages, err := database.GetUserMessages(user.ID)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	createdAt, err := time.Parse(time.RFC3339Nano, event["created_at"].(string))
+	require.NoError(t, err)
+	assert.True(t, messages[0].CreatedAt.Equal(createdAt))
+	require.Len(t, event["attachments"], 1)
+	delivered := event["attachments"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "https://upload.example.com/abc/label.pdf", delivered["url"])
+	assert.Equal(t, event["message_id"], delivered["message_id"])
+	assert.NotZero(t, delivered["id"])
+}
+
+func TestExpireAttachmentsRemovesOldFiles(t *testing.T) {
+	app, database, chatService := setupChatTestApp(t, NewMockXMPPClient())
+	dir := t.TempDir()
+	backend := storage.NewLocal(dir, "/uploads/")
+	chatService.SetAttachmentStorage(backend)
+
+	user, _ := registerUser(t, app, "expiry@example.com", "password123")
+	msg, err := database.SaveMessage(int(user["id"].(float64)), "Here are the files", "user")
+	require.NoError(t, err)
+
+	oldURL, err := backend.Save("old.pdf", []byte("old"))
+	require.NoError(t, err)
+	oldFile, err := database.SaveAttachment(msg.ID, oldURL)
+	require.NoError(t, err)
+	// Hosted on the admin's upload server, so it can only be marked
+	external, err := database.SaveAttachment(msg.ID, "https://upload.example.com/abc/old.jpg")
+	require.NoError(t, err)
+
+	time.Sleep(300 * time.Millisecond)
+	recentURL, err := backend.Save("recent.pdf", []byte("recent"))
+	require.NoError(t, err)
+	recent, err := database.SaveAttachment(msg.ID, recentURL)
+	require.NoError(t, err)
+
+	expired, err := chatService.ExpireAttachments(200 * time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 2, expired)
+
+	// Only the old file is gone from storage
+	_, err = os.Stat(filepath.Join(dir, "old.pdf"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "recent.pdf"))
+	assert.NoError(t, err)
+
+	attachments, err := database.GetMessageAttachments(msg.ID)
+	require.NoError(t, err)
+	require.Len(t, attachments, 3)
+	byID := map[int]db.Attachment{}
+	for _, attachment := range attachments {
+		byID[attachment.ID] = attachment
+	}
+
+	// Expired rows stay behind as placeholders
+	for _, id := range []int{oldFile.ID, external.ID} {
+		assert.NotNil(t, byID[id].ExpiredAt, id)
+		assert.Empty(t, byID[id].URL, id)
+	}
+	assert.Nil(t, byID[recent.ID].ExpiredAt)
+	assert.Equal(t, recentURL, byID[recent.ID].URL)
+
+	// Running again finds nothing new
+	expired, err = chatService.ExpireAttachments(200 * time.Millisecond)
+	require.NoError(t, err)
+	assert.Zero(t, expired)
+}
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	backend := storage.NewLocal(dir, "/uploads/")
+
+	url, err := backend.Save("report.txt", []byte("contents"))
+	require.NoError(t, err)
+	assert.Equal(t, "/uploads/report.txt", url)
+	data, err := os.ReadFile(filepath.Join(dir, "report.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "contents", string(data))
+
+	require.NoError(t, backend.Delete(url))
+	_, err = os.Stat(filepath.Join(dir, "report.txt"))
+	assert.True(t, os.IsNotExist(err))
+	// Deleting twice is fine
+	assert.NoError(t, backend.Delete(url))
+
+	for _, foreign := range []string{"https://upload.example.com/a/b.jpg", "/uploads/../secret", "/uploads/a/b", "/uploads/"} {
+		assert.ErrorIs(t, backend.Delete(foreign), storage.ErrNotStored, foreign)
+	}
+}
@@ -6,6 +6,8 @@ import (
 
 	"github.com/ngenohkevin/veilsupport/internal/auth"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func setupAuthService(t *testing.T) *auth.AuthService {
@@ -40,29 +42,35 @@ func TestPasswordHashing(t *testing.T) {
 
 func TestJWTGeneration(t *testing.T) {
 	authService := setupAuthService(t)
+	// Issued tokens are recorded against a real user
+	user, _, err := authService.Register("test@example.com", "password123")
+	require.NoError(t, err)
 	
-	token, err := authService.GenerateToken(123, "test@example.com")
+	token, err := authService.GenerateToken(user.ID, "test@example.com")
 	assert.NoError(t, err)
 	assert.NotEmpty(t, token)
 	
 	// Validate the generated token
 	claims, err := authService.ValidateToken(token)
 	assert.NoError(t, err)
-	assert.Equal(t, 123, claims.UserID)
+	assert.Equal(t, user.ID, claims.UserID)
 	assert.Equal(t, "test@example.com", claims.Email)
 }
 
 func TestJWTValidation(t *testing.T) {
 	authService := setupAuthService(t)
 	
+	user, _, err := authService.Register("user@test.com", "password123")
+	require.NoError(t, err)
+	
 	// Generate a valid token
-	token, err := authService.GenerateToken(456, "user@test.com")
+	token, err := authService.GenerateToken(user.ID, "user@test.com")
 	assert.NoError(t, err)
 	
 	// Validate it
 	claims, err := authService.ValidateToken(token)
 	assert.NoError(t, err)
-	assert.Equal(t, 456, claims.UserID)
+	assert.Equal(t, user.ID, claims.UserID)
 	assert.Equal(t, "user@test.com", claims.Email)
 	
 	// Test invalid token
@@ -96,6 +104,32 @@ func TestRegistration(t *testing.T) {
 	assert.Contains(t, err.Error(), "already registered")
 }
 
+func TestRegistrationCapacity(t *testing.T) {
+	authService := setupAuthService(t)
+	authService.SetMaxUsers(2)
+	
+	// Registering up to the cap works
+	_, _, err := authService.Register("first@example.com", "password123")
+	assert.NoError(t, err)
+	_, _, err = authService.Register("second@example.com", "password123")
+	assert.NoError(t, err)
+	
+	// The next registration is rejected
+	user, token, err := authService.Register("third@example.com", "password123")
+	assert.ErrorIs(t, err, auth.ErrRegistrationClosed)
+	assert.Nil(t, user)
+	assert.Empty(t, token)
+	
+	// Existing users can still log in
+	_, _, err = authService.Login("first@example.com", "password123")
+	assert.NoError(t, err)
+	
+	// Removing the cap reopens registration
+	authService.SetMaxUsers(0)
+	_, _, err = authService.Register("third@example.com", "password123")
+	assert.NoError(t, err)
+}
+
 func TestLogin(t *testing.T) {
 	authService := setupAuthService(t)
 	
@@ -156,4 +190,105 @@ func TestPasswordComplexity(t *testing.T) {
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestJWTSecretRotation(t *testing.T) {
+	// Token validation doesn't touch the database
+	oldService := auth.NewAuthService(nil, "old-secret")
+	oldToken, err := oldService.GenerateToken(789, "rotate@example.com")
+	assert.NoError(t, err)
+	
+	// After rotation, tokens signed with the old key remain valid
+	rotated := auth.NewAuthService(nil, "new-secret")
+	rotated.SetPreviousSecrets([]string{"older-secret", "old-secret"})
+	
+	claims, err := rotated.ValidateToken(oldToken)
+	assert.NoError(t, err)
+	assert.Equal(t, 789, claims.UserID)
+	assert.Equal(t, "rotate@example.com", claims.Email)
+	
+	// New tokens are signed with the primary key only
+	newToken, err := rotated.GenerateToken(789, "rotate@example.com")
+	assert.NoError(t, err)
+	_, err = oldService.ValidateToken(newToken)
+	assert.Error(t, err)
+	
+	// Once the old key is dropped from the set, its tokens are rejected
+	retired := auth.NewAuthService(nil, "new-secret")
+	_, err = retired.ValidateToken(oldToken)
+	assert.Error(t, err)
+	
+	claims, err = retired.ValidateToken(newToken)
+	assert.NoError(t, err)
+	assert.Equal(t, 789, claims.UserID)
+	
+	// Stray whitespace around a previous secret doesn't change it
+	padded := auth.NewAuthService(nil, "new-secret")
+	padded.SetPreviousSecrets([]string{"", "  ", " old-secret\n"})
+	claims, err = padded.ValidateToken(oldToken)
+	assert.NoError(t, err)
+	assert.Equal(t, 789, claims.UserID)
+}
+
+func TestJWTUnknownKeyRejected(t *testing.T) {
+	stranger := auth.NewAuthService(nil, "someone-elses-secret")
+	token, err := stranger.GenerateToken(1, "stranger@example.com")
+	assert.NoError(t, err)
+	
+	service := auth.NewAuthService(nil, "new-secret")
+	service.SetPreviousSecrets([]string{"old-secret"})
+	
+	_, err = service.ValidateToken(token)
+	assert.Error(t, err)
+}
+
+func TestLoginUpgradesUnderCostHash(t *testing.T) {
+	database := setupTestDB(t)
+	authService := auth.NewAuthService(database, "test-secret-key")
+	
+	// Registered back when the cost was lower
+	authService.SetBcryptCost(bcrypt.MinCost)
+	_, _, err := authService.Register("legacy@example.com", "testpassword")
+	require.NoError(t, err)
+	
+	stored, err := database.GetUserByEmail("legacy@example.com")
+	require.NoError(t, err)
+	cost, err := bcrypt.Cost([]byte(stored.PasswordHash))
+	require.NoError(t, err)
+	require.Equal(t, bcrypt.MinCost, cost)
+	
+	// A wrong password leaves the hash alone
+	authService.SetBcryptCost(bcrypt.MinCost + 1)
+	_, _, err = authService.Login("legacy@example.com", "wrongpassword")
+	assert.Error(t, err)
+	unchanged, err := database.GetUserByEmail("legacy@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, stored.PasswordHash, unchanged.PasswordHash)
+	
+	// The next successful login rehashes at the new cost
+	_, token, err := authService.Login("legacy@example.com", "testpassword")
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+	
+	upgraded, err := database.GetUserByEmail("legacy@example.com")
+	require.NoError(t, err)
+	assert.NotEqual(t, stored.PasswordHash, upgraded.PasswordHash)
+	cost, err = bcrypt.Cost([]byte(upgraded.PasswordHash))
+	require.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost+1, cost)
+	
+	// The upgraded hash still authenticates and is left alone from now on
+	_, _, err = authService.Login("legacy@example.com", "testpassword")
+	require.NoError(t, err)
+	again, err := database.GetUserByEmail("legacy@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, upgraded.PasswordHash, again.PasswordHash)
+	
+	// Lowering the cost never downgrades existing hashes
+	authService.SetBcryptCost(bcrypt.MinCost)
+	_, _, err = authService.Login("legacy@example.com", "testpassword")
+	require.NoError(t, err)
+	again, err = database.GetUserByEmail("legacy@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, upgraded.PasswordHash, again.PasswordHash)
+}
@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ngenohkevin/veilsupport/internal/xmpp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBot() *xmpp.BetterBotClient {
+	return xmpp.NewBetterBotClient("bot@example.com", "password", "localhost:5222", "admin@example.com")
+}
+
+func TestParseMultiReply(t *testing.T) {
+	bot := newTestBot()
+
+	userIDs, message, err := bot.ParseMultiReply("/reply-multi 101,102, 103 The outage is fixed")
+	require.NoError(t, err)
+	assert.Equal(t, []int{101, 102, 103}, userIDs)
+	assert.Equal(t, "The outage is fixed", message)
+
+	// Duplicate IDs are only replied to once
+	userIDs, _, err = bot.ParseMultiReply("/reply-multi 7,7,8 hi")
+	require.NoError(t, err)
+	assert.Equal(t, []int{7, 8}, userIDs)
+
+	for _, invalid := range []string{
+		"/reply-multi",
+		"/reply-multi 101,102",
+		"/reply-multi abc hello",
+		"/reply-multi , hello",
+	} {
+		_, _, err := bot.ParseMultiReply(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestReplyToMultipleUsers(t *testing.T) {
+	bot := newTestBot()
+
+	delivered := map[int]string{}
+	bot.SetReplyHandler(func(userID int, message string) error {
+		delivered[userID] = message
+		return nil
+	})
+
+	results := bot.ReplyToUsers([]int{1, 2, 3}, "We're back online")
+
+	require.Len(t, results, 3)
+	for i, result := range results {
+		assert.Equal(t, i+1, result.UserID)
+		assert.NoError(t, result.Err)
+	}
+	assert.Equal(t, map[int]string{1: "We're back online", 2: "We're back online", 3: "We're back online"}, delivered)
+}
+
+func TestReplyToMultipleUsersReportsPartialFailure(t *testing.T) {
+	bot := newTestBot()
+
+	var attempted []int
+	bot.SetReplyHandler(func(userID int, message string) error {
+		attempted = append(attempted, userID)
+		if userID == 2 {
+			return errors.New("user not found")
+		}
+		return nil
+	})
+
+	results := bot.ReplyToUsers([]int{1, 2, 3}, "Update")
+
+	// A failure doesn't stop delivery to the remaining users
+	assert.Equal(t, []int{1, 2, 3}, attempted)
+	require.Len(t, results, 3)
+	assert.NoError(t, results[0].Err)
+	assert.EqualError(t, results[1].Err, "user not found")
+	assert.NoError(t, results[2].Err)
+}
+
+func TestReplyWithoutHandlerFails(t *testing.T) {
+	session := &fakeSession{}
+	bot := newTestBot()
+	bot.SetSession(session)
+
+	results := bot.ReplyToUsers([]int{42}, "Hello")
+	require.Len(t, results, 1)
+	assert.ErrorIs(t, results[0].Err, xmpp.ErrNoReplyHandler)
+
+	// The admin is told the reply went nowhere
+	require.NoError(t, bot.HandleCommand("@42 Hello"))
+	sent := session.stanzas()
+	require.Len(t, sent, 1)
+	assert.Contains(t, sent[0], "Reply to user 42 failed")
+}
+
+func TestMultiReplySummary(t *testing.T) {
+	session := &fakeSession{}
+	bot := newTestBot()
+	bot.SetSession(session)
+	bot.SetReplyHandler(func(userID int, message string) error {
+		if userID == 2 {
+			return errors.New("user not found")
+		}
+		return nil
+	})
+
+	require.NoError(t, bot.HandleCommand("/reply-multi 1,2,3 Update"))
+
+	sent := session.stanzas()
+	require.Len(t, sent, 1)
+	assert.Contains(t, sent[0], "📨 Reply sent to 2 of 3 users\n✅ User 1\n❌ User 2: user not found\n✅ User 3\n═")
+}
+
+func TestVIPCommand(t *testing.T) {
+	session := &fakeSession{}
+	bot := newTestBot()
+	bot.SetSession(session)
+
+	// Unavailable until the bot knows how to record VIPs
+	require.NoError(t, bot.HandleCommand("/vip 42"))
+	assert.Contains(t, session.stanzas()[0], "VIP marking is not available")
+
+	marked := map[int]bool{}
+	bot.SetVIPHandler(func(userID int, vip bool) error {
+		if userID == 13 {
+			return errors.New("user 13 not found")
+		}
+		marked[userID] = vip
+		return nil
+	})
+
+	require.NoError(t, bot.HandleCommand("/vip 42"))
+	require.NoError(t, bot.HandleCommand("/vip 7"))
+	require.NoError(t, bot.HandleCommand("/vip 7 off"))
+	assert.Equal(t, map[int]bool{42: true, 7: false}, marked)
+
+	sent := session.stanzas()
+	assert.Contains(t, sent[1], "User 42 is now a VIP")
+	assert.Contains(t, sent[3], "User 7 is no longer a VIP")
+
+	require.NoError(t, bot.HandleCommand("/vip 13"))
+	assert.Contains(t, session.stanzas()[4], "user 13 not found")
+
+	for _, invalid := range []string{"/vip", "/vip abc", "/vip 42 on", "/vip 42 off now"} {
+		require.NoError(t, bot.HandleCommand(invalid))
+	}
+	assert.Len(t, marked, 2)
+}
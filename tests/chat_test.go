@@ -0,0 +1,879 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/ngenohkevin/veilsupport/internal/auth"
+	"github.com/ngenohkevin/veilsupport/internal/chat"
+	"github.com/ngenohkevin/veilsupport/internal/db"
+	"github.com/ngenohkevin/veilsupport/internal/handlers"
+	"github.com/ngenohkevin/veilsupport/internal/i18n"
+	"github.com/ngenohkevin/veilsupport/internal/tracing"
+	"github.com/ngenohkevin/veilsupport/internal/ws"
+	"github.com/ngenohkevin/veilsupport/internal/xmpp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupChatTestApp builds an app whose chat service bridges through the given XMPP sender
+func setupChatTestApp(t *testing.T, sender chat.XMPPSender) (*gin.Engine, *db.DB, *chat.ChatService) {
+	gin.SetMode(gin.TestMode)
+	
+	database := setupTestDB(t)
+	authService := auth.NewAuthService(database, "test-secret-key")
+	wsManager := ws.NewManager()
+	chatService := chat.NewChatService(database, sender, wsManager)
+	chatService.SetAdminAllowlist([]string{"admin@example.com", "agent@example.com"})
+	h := handlers.NewHandlers(authService, chatService, wsManager)
+	
+	r := gin.New()
+	r.Use(tracing.Middleware())
+	api := r.Group("/api")
+	{
+		api.POST("/register", h.Register)
+		api.POST("/login", h.Login)
+		
+		protected := api.Group("/")
+		protected.Use(h.JWTMiddleware())
+		{
+			protected.POST("/send", h.SendMessage)
+			protected.POST("/attachments", h.UploadAttachment)
+			protected.GET("/history", h.GetHistory)
+			protected.GET("/history/all", h.GetFullHistory)
+			protected.PATCH("/me", h.UpdateMe)
+			protected.POST("/messages/:id/resend", h.ResendMessage)
+			protected.PATCH("/messages/:id", h.EditMessage)
+			protected.DELETE("/messages/:id", h.DeleteMessage)
+		}
+		
+		api.GET("/ws", h.WebSocket)
+	}
+	
+	return r, database, chatService
+}
+
+// dialTestWebSocket connects to the app's WebSocket endpoint and consumes the "connected" frame
+func dialTestWebSocket(t *testing.T, server *httptest.Server, token string) *websocket.Conn {
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	
+	wsURL := "ws" + strings.TrimPrefix(u.String(), "http") + "/api/ws?token=" + token
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&msg))
+	require.Equal(t, "connected", msg["type"])
+	
+	return conn
+}
+
+func TestSendAckOnSuccessfulBridge(t *testing.T) {
+	mockXMPP := NewMockXMPPClient()
+	mockXMPP.Connect()
+	
+	app, database, chatService := setupChatTestApp(t, mockXMPP)
+	chatService.SetAdminJID("admin@example.com")
+	chatService.SetSendAcks(true)
+	
+	server := httptest.NewServer(app)
+	defer server.Close()
+	
+	user, token := registerUser(t, app, "ack@example.com", "password123")
+	conn := dialTestWebSocket(t, server, token)
+	defer conn.Close()
+	
+	sendMessage(t, app, token, "Hello support")
+	
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, "ack", event["type"])
+	assert.Equal(t, "sent", event["status"])
+	
+	// The ack refers to the stored message, which is now marked sent
+	messages, err := database.GetUserMessages(int(user["id"].(float64)))
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, float64(messages[0].ID), event["message_id"])
+	assert.Equal(t, db.StatusSent, messages[0].Status)
+	assert.Len(t, mockXMPP.GetReceivedMessages(), 1)
+}
+
+func TestSendFailedEventOnBridgeError(t *testing.T) {
+	mockXMPP := NewMockXMPPClient()
+	mockXMPP.Connect()
+	mockXMPP.sendErr = errors.New("stream closed")
+	
+	app, database, chatService := setupChatTestApp(t, mockXMPP)
+	chatService.SetAdminJID("admin@example.com")
+	chatService.SetSendAcks(true)
+	
+	server := httptest.NewServer(app)
+	defer server.Close()
+	
+	user, token := registerUser(t, app, "failed@example.com", "password123")
+	conn := dialTestWebSocket(t, server, token)
+	defer conn.Close()
+	
+	sendMessage(t, app, token, "Hello support")
+	
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, "failed", event["type"])
+	
+	messages, err := database.GetUserMessages(int(user["id"].(float64)))
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, float64(messages[0].ID), event["message_id"])
+	assert.Equal(t, db.StatusFailed, messages[0].Status)
+}
+
+func TestSystemMessageUsesUserLocale(t *testing.T) {
+	app, database, chatService := setupChatTestApp(t, NewMockXMPPClient())
+	user, token := registerUser(t, app, "locale@example.com", "password123")
+	userID := int(user["id"].(float64))
+	
+	// Set the locale through the API
+	req := httptest.NewRequest("PATCH", "/api/me", strings.NewReader(`{"locale":"es"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+	
+	var resp map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "es", resp["user"]["locale"])
+	
+	msg, err := chatService.SendSystemMessage(userID, i18n.KeyWelcome)
+	require.NoError(t, err)
+	assert.Equal(t, "system", msg.SenderType)
+	assert.Equal(t, i18n.NewCatalog("en").Render("es", i18n.KeyWelcome), msg.Content)
+	
+	// Missing translations fall back to the server default
+	catalog := i18n.NewCatalog("en")
+	catalog.Add("sw", i18n.KeyWelcome, "Karibu!")
+	chatService.SetCatalog(catalog)
+	require.NoError(t, database.SetUserLocale(userID, "sw"))
+	
+	msg, err = chatService.SendSystemMessage(userID, i18n.KeyWelcome)
+	require.NoError(t, err)
+	assert.Equal(t, "Karibu!", msg.Content)
+	
+	msg, err = chatService.SendSystemMessage(userID, i18n.KeySurvey, "https://example.com/survey")
+	require.NoError(t, err)
+	assert.Equal(t, catalog.Render("en", i18n.KeySurvey, "https://example.com/survey"), msg.Content)
+}
+
+func TestWelcomeMessageOnNewSession(t *testing.T) {
+	app, database, chatService := setupChatTestApp(t, NewMockXMPPClient())
+	chatService.SetWelcomeMessages(true)
+	
+	user, token := registerUser(t, app, "welcome@example.com", "password123")
+	userID := int(user["id"].(float64))
+	require.NoError(t, database.SetUserLocale(userID, "fr"))
+	
+	sendMessage(t, app, token, "Bonjour")
+	sendMessage(t, app, token, "Encore moi")
+	
+	// Only the first message of the session triggers the welcome
+	messages, err := database.GetUserMessages(userID)
+	require.NoError(t, err)
+	require.Len(t, messages, 3)
+	assert.Equal(t, "Bonjour", messages[0].Content)
+	assert.Equal(t, "system", messages[1].SenderType)
+	assert.Equal(t, i18n.NewCatalog("en").Render("fr", i18n.KeyWelcome), messages[1].Content)
+	assert.Equal(t, "Encore moi", messages[2].Content)
+}
+
+func TestUpdateMeRejectsInvalidLocale(t *testing.T) {
+	app, _, _ := setupChatTestApp(t, NewMockXMPPClient())
+	_, token := registerUser(t, app, "badlocale@example.com", "password123")
+	
+	req := httptest.NewRequest("PATCH", "/api/me", strings.NewReader(`{"locale":"not a locale"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	
+	assert.Equal(t, 400, w.Code)
+}
+
+func resendMessage(t *testing.T, app *gin.Engine, token string, messageID int) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", fmt.Sprintf("/api/messages/%d/resend", messageID), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	return w
+}
+
+func TestResendFailedMessage(t *testing.T) {
+	mockXMPP := NewMockXMPPClient()
+	mockXMPP.Connect()
+	mockXMPP.sendErr = errors.New("stream closed")
+	
+	app, database, chatService := setupChatTestApp(t, mockXMPP)
+	chatService.SetAdminJID("admin@example.com")
+	user, token := registerUser(t, app, "resend@example.com", "password123")
+	userID := int(user["id"].(float64))
+	
+	sendMessage(t, app, token, "Are you there?")
+	messages, err := database.GetUserMessages(userID)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, db.StatusFailed, messages[0].Status)
+	
+	// The bridge recovers and the retry goes through
+	mockXMPP.sendErr = nil
+	w := resendMessage(t, app, token, messages[0].ID)
+	require.Equal(t, 200, w.Code, w.Body.String())
+	
+	var response struct {
+		Message db.Message `json:"message"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, messages[0].ID, response.Message.ID)
+	assert.Equal(t, db.StatusSent, response.Message.Status)
+	
+	stored, err := database.GetMessageByID(messages[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.StatusSent, stored.Status)
+	
+	received := mockXMPP.GetReceivedMessages()
+	require.Len(t, received, 1)
+	assert.Contains(t, received[0].Body, "Are you there?")
+}
+
+func TestResendReportsFailure(t *testing.T) {
+	mockXMPP := NewMockXMPPClient()
+	mockXMPP.Connect()
+	mockXMPP.sendErr = errors.New("stream closed")
+	
+	app, database, chatService := setupChatTestApp(t, mockXMPP)
+	chatService.SetAdminJID("admin@example.com")
+	user, token := registerUser(t, app, "stillbroken@example.com", "password123")
+	
+	sendMessage(t, app, token, "Anyone?")
+	messages, err := database.GetUserMessages(int(user["id"].(float64)))
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	
+	// A retry that fails again is an error, and the message stays resendable
+	w := resendMessage(t, app, token, messages[0].ID)
+	assert.Equal(t, 503, w.Code, w.Body.String())
+	stored, err := database.GetMessageByID(messages[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.StatusFailed, stored.Status)
+	
+	// So does one that can't reach the bridge at all
+	mockXMPP.connected = false
+	assert.Equal(t, 503, resendMessage(t, app, token, messages[0].ID).Code)
+	stored, err = database.GetMessageByID(messages[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.StatusFailed, stored.Status)
+}
+
+func TestConcurrentResendsSendOnce(t *testing.T) {
+	mockXMPP := NewMockXMPPClient()
+	mockXMPP.Connect()
+	mockXMPP.sendErr = errors.New("stream closed")
+	
+	app, database, chatService := setupChatTestApp(t, mockXMPP)
+	chatService.SetAdminJID("admin@example.com")
+	user, token := registerUser(t, app, "doubletap@example.com", "password123")
+	
+	sendMessage(t, app, token, "Double tap")
+	messages, err := database.GetUserMessages(int(user["id"].(float64)))
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	
+	mockXMPP.sendErr = nil
+	mockXMPP.sendDelay = 100 * time.Millisecond
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() { codes <- resendMessage(t, app, token, messages[0].ID).Code }()
+	}
+	got := []int{<-codes, <-codes}
+	
+	assert.ElementsMatch(t, []int{200, 409}, got)
+	assert.Len(t, mockXMPP.GetReceivedMessages(), 1)
+}
+
+func TestResendRejectsSentMessage(t *testing.T) {
+	mockXMPP := NewMockXMPPClient()
+	mockXMPP.Connect()
+	
+	app, database, chatService := setupChatTestApp(t, mockXMPP)
+	chatService.SetAdminJID("admin@example.com")
+	user, token := registerUser(t, app, "sent@example.com", "password123")
+	
+	sendMessage(t, app, token, "Hello")
+	messages, err := database.GetUserMessages(int(user["id"].(float64)))
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, db.StatusSent, messages[0].Status)
+	
+	w := resendMessage(t, app, token, messages[0].ID)
+	assert.Equal(t, 409, w.Code)
+	assert.Len(t, mockXMPP.GetReceivedMessages(), 1)
+	
+	// Someone else's message looks like it doesn't exist
+	_, otherToken := registerUser(t, app, "other@example.com", "password123")
+	assert.Equal(t, 404, resendMessage(t, app, otherToken, messages[0].ID).Code)
+	assert.Equal(t, 404, resendMessage(t, app, token, messages[0].ID+1000).Code)
+}
+
+func TestRapidMessagesProduceOneDigest(t *testing.T) {
+	mockXMPP := NewMockXMPPClient()
+	mockXMPP.Connect()
+	
+	app, database, chatService := setupChatTestApp(t, mockXMPP)
+	chatService.SetAdminJID("admin@example.com")
+	// Long enough that the window never closes on its own during the test
+	chatService.SetNotificationBatchWindow(time.Hour)
+	
+	user, token := registerUser(t, app, "burst1@example.com", "password123")
+	_, otherToken := registerUser(t, app, "burst2@example.com", "password123")
+	
+	sendMessage(t, app, token, "First")
+	sendMessage(t, app, token, "Second")
+	sendMessage(t, app, otherToken, "Third")
+	assert.Empty(t, mockXMPP.GetReceivedMessages())
+	
+	chatService.FlushNotifications()
+	
+	received := mockXMPP.GetReceivedMessages()
+	require.Len(t, received, 1)
+	assert.Equal(t, "admin@example.com", received[0].To)
+	assert.Contains(t, received[0].Body, "3 new messages from 2 users")
+	assert.Contains(t, received[0].Body, "burst1@example.com: 2 messages")
+	assert.Contains(t, received[0].Body, "burst2@example.com: 1 message")
+	assert.NotContains(t, received[0].Body, "First")
+	
+	// Digested messages count as bridged
+	messages, err := database.GetUserMessages(int(user["id"].(float64)))
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	for _, msg := range messages {
+		assert.Equal(t, db.StatusSent, msg.Status)
+	}
+}
+
+func TestSlowMessagesAreNotifiedIndividually(t *testing.T) {
+	mockXMPP := NewMockXMPPClient()
+	mockXMPP.Connect()
+	
+	app, _, chatService := setupChatTestApp(t, mockXMPP)
+	chatService.SetAdminJID("admin@example.com")
+	chatService.SetNotificationBatchWindow(50 * time.Millisecond)
+	
+	_, token := registerUser(t, app, "slow@example.com", "password123")
+	
+	sendMessage(t, app, token, "First")
+	require.Eventually(t, func() bool { return len(mockXMPP.GetReceivedMessages()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	
+	sendMessage(t, app, token, "Second")
+	require.Eventually(t, func() bool { return len(mockXMPP.GetReceivedMessages()) == 2 }, 2*time.Second, 10*time.Millisecond)
+	
+	received := mockXMPP.GetReceivedMessages()
+	assert.Equal(t, "[User: slow@example.com] First", received[0].Body)
+	assert.Equal(t, "[User: slow@example.com] Second", received[1].Body)
+}
+
+func getHistoryBySender(t *testing.T, app *gin.Engine, token, senderType string) *httptest.ResponseRecorder {
+	path := "/api/history"
+	if senderType != "" {
+		path += "?sender_type=" + senderType
+	}
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	return w
+}
+
+func TestHistorySenderTypeFilter(t *testing.T) {
+	app, database, _ := setupChatTestApp(t, NewMockXMPPClient())
+	user, token := registerUser(t, app, "filter@example.com", "password123")
+	userID := int(user["id"].(float64))
+	
+	sendMessage(t, app, token, "Question one")
+	_, err := database.SaveMessage(userID, "Answer one", "admin")
+	require.NoError(t, err)
+	sendMessage(t, app, token, "Question two")
+	
+	contents := func(w *httptest.ResponseRecorder) []string {
+		require.Equal(t, 200, w.Code, w.Body.String())
+		var response struct {
+			Messages []db.Message `json:"messages"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		result := []string{}
+		for _, msg := range response.Messages {
+			result = append(result, msg.Content)
+		}
+		return result
+	}
+	
+	assert.Equal(t, []string{"Question one", "Question two"}, contents(getHistoryBySender(t, app, token, "user")))
+	assert.Equal(t, []string{"Answer one"}, contents(getHistoryBySender(t, app, token, "admin")))
+	assert.Equal(t, []string{}, contents(getHistoryBySender(t, app, token, "system")))
+	assert.Equal(t, []string{"Question one", "Answer one", "Question two"}, contents(getHistoryBySender(t, app, token, "")))
+	
+	assert.Equal(t, 400, getHistoryBySender(t, app, token, "robot").Code)
+}
+
+func TestDrainOutboxDeliversPendingMessages(t *testing.T) {
+	// Messages sent while XMPP is down wait in the outbox
+	mockXMPP := NewMockXMPPClient()
+	app, database, chatService := setupChatTestApp(t, mockXMPP)
+	chatService.SetAdminJID("admin@example.com")
+	user, token := registerUser(t, app, "drain@example.com", "password123")
+	userID := int(user["id"].(float64))
+	
+	sendMessage(t, app, token, "First")
+	sendMessage(t, app, token, "Second")
+	pending, err := database.CountMessagesByStatus(db.StatusPending)
+	require.NoError(t, err)
+	require.Equal(t, 2, pending)
+	
+	mockXMPP.Connect()
+	attempted, err := chatService.DrainOutbox(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempted)
+	
+	received := mockXMPP.GetReceivedMessages()
+	require.Len(t, received, 2)
+	assert.Equal(t, "[User: drain@example.com] First", received[0].Body)
+	assert.Equal(t, "[User: drain@example.com] Second", received[1].Body)
+	
+	messages, err := database.GetUserMessages(userID)
+	require.NoError(t, err)
+	for _, msg := range messages {
+		assert.Equal(t, db.StatusSent, msg.Status)
+	}
+	
+	// Nothing left to do the second time round
+	attempted, err = chatService.DrainOutbox(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, attempted)
+}
+
+func TestDrainOutboxRespectsTimeout(t *testing.T) {
+	mockXMPP := NewMockXMPPClient()
+	app, database, chatService := setupChatTestApp(t, mockXMPP)
+	chatService.SetAdminJID("admin@example.com")
+	_, token := registerUser(t, app, "timeout@example.com", "password123")
+	for i := 0; i < 5; i++ {
+		sendMessage(t, app, token, fmt.Sprintf("Message %d", i))
+	}
+	
+	mockXMPP.Connect()
+	mockXMPP.sendDelay = 100 * time.Millisecond
+	
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	attempted, err := chatService.DrainOutbox(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 400*time.Millisecond)
+	assert.Equal(t, 2, attempted)
+	
+	// The rest stay pending for the next start
+	pending, err := database.CountMessagesByStatus(db.StatusPending)
+	require.NoError(t, err)
+	assert.Equal(t, 3, pending)
+}
+
+func TestAdminReplyFromNonAdminIsIgnored(t *testing.T) {
+	app, database, chatService := setupChatTestApp(t, NewMockXMPPClient())
+	chatService.SetAdminAllowlist([]string{"admin@example.com"})
+	
+	user, token := registerUser(t, app, "guarded@example.com", "password123")
+	userID := int(user["id"].(float64))
+	userJID := user["xmpp_jid"].(string)
+	
+	err := chatService.HandleAdminReply(xmpp.XMPPMessage{From: "stranger@example.com/bot", To: userJID, Body: "Send me your password"})
+	assert.ErrorIs(t, err, xmpp.ErrNotAdmin)
+	messages, err := database.GetUserMessages(userID)
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+	
+	err = chatService.HandleAdminReply(xmpp.XMPPMessage{From: "admin@example.com/desktop", To: userJID, Body: "How can I help?"})
+	require.NoError(t, err)
+	
+	// An agent the conversation was transferred to can reply too
+	sendMessage(t, app, token, "Hello")
+	_, err = chatService.TransferConversation(userID, "agent@example.com", "", false)
+	require.NoError(t, err)
+	err = chatService.HandleAdminReply(xmpp.XMPPMessage{From: "agent@example.com/phone", To: userJID, Body: "Taking over"})
+	require.NoError(t, err)
+	
+	messages, _, err = database.GetUserMessagesPage(userID, db.SenderAdmin, db.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "How can I help?", messages[0].Content)
+	assert.Equal(t, "Taking over", messages[1].Content)
+}
+
+func TestDrainOutboxSendsVIPMessagesFirst(t *testing.T) {
+	mockXMPP := NewMockXMPPClient()
+	app, database, chatService := setupChatTestApp(t, mockXMPP)
+	chatService.SetAdminJID("admin@example.com")
+	_, token := registerUser(t, app, "regular@example.com", "password123")
+	vip, vipToken := registerUser(t, app, "vip@example.com", "password123")
+	require.NoError(t, database.SetUserVIP(int(vip["id"].(float64)), true))
+	
+	// The VIP writes last but is dequeued first
+	sendMessage(t, app, token, "First")
+	sendMessage(t, app, token, "Second")
+	sendMessage(t, app, vipToken, "Urgent")
+	
+	mockXMPP.Connect()
+	attempted, err := chatService.DrainOutbox(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempted)
+	
+	received := mockXMPP.GetReceivedMessages()
+	require.Len(t, received, 3)
+	assert.Equal(t, "⭐ VIP [User: vip@example.com] Urgent", received[0].Body)
+	assert.Equal(t, "[User: regular@example.com] First", received[1].Body)
+	assert.Equal(t, "[User: regular@example.com] Second", received[2].Body)
+}
+
+func TestVIPMessagesSkipBatching(t *testing.T) {
+	mockXMPP := NewMockXMPPClient()
+	mockXMPP.Connect()
+	
+	app, database, chatService := setupChatTestApp(t, mockXMPP)
+	chatService.SetAdminJID("admin@example.com")
+	chatService.SetNotificationBatchWindow(time.Hour)
+	
+	_, token := registerUser(t, app, "waits@example.com", "password123")
+	vip, vipToken := registerUser(t, app, "jumps@example.com", "password123")
+	require.NoError(t, database.SetUserVIP(int(vip["id"].(float64)), true))
+	
+	sendMessage(t, app, token, "Can wait")
+	sendMessage(t, app, vipToken, "Can't wait")
+	
+	received := mockXMPP.GetReceivedMessages()
+	require.Len(t, received, 1)
+	assert.Equal(t, "⭐ VIP [User: jumps@example.com] Can't wait", received[0].Body)
+	
+	// Unmarking a VIP puts them back in the batch
+	require.NoError(t, database.SetUserVIP(int(vip["id"].(float64)), false))
+	sendMessage(t, app, vipToken, "Back in line")
+	assert.Len(t, mockXMPP.GetReceivedMessages(), 1)
+	
+	assert.Error(t, database.SetUserVIP(999999, true))
+}
+
+
+// sentMessageID returns the ID of the user's only stored message
+func sentMessageID(t *testing.T, database *db.DB, user map[string]interface{}) int {
+	messages, err := database.GetUserMessages(int(user["id"].(float64)))
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	return messages[0].ID
+}
+
+func TestEditedEventBroadcastToUser(t *testing.T) {
+	app, database, _ := setupChatTestApp(t, NewMockXMPPClient())
+	server := httptest.NewServer(app)
+	defer server.Close()
+	
+	user, token := registerUser(t, app, "editor@example.com", "password123")
+	conn := dialTestWebSocket(t, server, token)
+	defer conn.Close()
+	
+	sendMessage(t, app, token, "Helo support")
+	id := sentMessageID(t, database, user)
+	
+	w := adminRequest(t, app, "PATCH", fmt.Sprintf("/api/messages/%d", id), token, `{"message":"Hello support"}`)
+	require.Equal(t, 200, w.Code, w.Body.String())
+	
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, "edited", event["type"])
+	assert.Equal(t, float64(id), event["message_id"])
+	assert.Equal(t, float64(1), event["seq"])
+	assert.Equal(t, "Hello support", event["content"])
+	assert.NotEmpty(t, event["edited_at"])
+	
+	msg, err := database.GetMessageByID(id)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello support", msg.Content)
+	assert.NotNil(t, msg.EditedAt)
+	
+	// Blank edits are rejected rather than clearing the message
+	w = adminRequest(t, app, "PATCH", fmt.Sprintf("/api/messages/%d", id), token, `{"message":"   "}`)
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestDeletedEventBroadcastToUser(t *testing.T) {
+	app, database, _ := setupChatTestApp(t, NewMockXMPPClient())
+	server := httptest.NewServer(app)
+	defer server.Close()
+	
+	user, token := registerUser(t, app, "deleter@example.com", "password123")
+	conn := dialTestWebSocket(t, server, token)
+	defer conn.Close()
+	
+	sendMessage(t, app, token, "Oops, wrong chat")
+	id := sentMessageID(t, database, user)
+	
+	w := adminRequest(t, app, "DELETE", fmt.Sprintf("/api/messages/%d", id), token, "")
+	require.Equal(t, 200, w.Code, w.Body.String())
+	
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, map[string]interface{}{"type": "deleted", "message_id": float64(id), "seq": float64(1)}, event)
+	
+	// The row stays as a placeholder without its content
+	msg, err := database.GetMessageByID(id)
+	require.NoError(t, err)
+	assert.Empty(t, msg.Content)
+	assert.NotNil(t, msg.DeletedAt)
+	
+	// Deleted messages can't be edited or deleted again
+	w = adminRequest(t, app, "PATCH", fmt.Sprintf("/api/messages/%d", id), token, `{"message":"Back"}`)
+	assert.Equal(t, 404, w.Code)
+	w = adminRequest(t, app, "DELETE", fmt.Sprintf("/api/messages/%d", id), token, "")
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestEditAndDeleteOnlyOwnMessages(t *testing.T) {
+	app, database, _ := setupChatTestApp(t, NewMockXMPPClient())
+	server := httptest.NewServer(app)
+	defer server.Close()
+	
+	owner, ownerToken := registerUser(t, app, "owner@example.com", "password123")
+	_, otherToken := registerUser(t, app, "other@example.com", "password123")
+	ownerConn := dialTestWebSocket(t, server, ownerToken)
+	defer ownerConn.Close()
+	
+	sendMessage(t, app, ownerToken, "Mine")
+	id := sentMessageID(t, database, owner)
+	
+	w := adminRequest(t, app, "PATCH", fmt.Sprintf("/api/messages/%d", id), otherToken, `{"message":"Theirs"}`)
+	assert.Equal(t, 404, w.Code)
+	w = adminRequest(t, app, "DELETE", fmt.Sprintf("/api/messages/%d", id), otherToken, "")
+	assert.Equal(t, 404, w.Code)
+	
+	// Nothing changed, so the owner heard nothing
+	ownerConn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, _, err := ownerConn.ReadMessage()
+	assert.Error(t, err)
+	
+	msg, err := database.GetMessageByID(id)
+	require.NoError(t, err)
+	assert.Equal(t, "Mine", msg.Content)
+	assert.Nil(t, msg.EditedAt)
+}
+func TestAdminReplyFrameMatchesHistory(t *testing.T) {
+	app, _, chatService := setupChatTestApp(t, NewMockXMPPClient())
+	
+	server := httptest.NewServer(app)
+	defer server.Close()
+	
+	user, token := registerUser(t, app, "threaded@example.com", "password123")
+	conn := dialTestWebSocket(t, server, token)
+	defer conn.Close()
+	
+	sendMessage(t, app, token, "Where is my order?")
+	err := chatService.HandleAdminReply(xmpp.XMPPMessage{From: "admin@example.com", To: user["xmpp_jid"].(string), Body: "On its way"})
+	require.NoError(t, err)
+	
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, "message", event["type"])
+	
+	// The frame identifies the same row the client gets from history
+	history := getHistory(t, app, token)
+	require.Len(t, history, 2)
+	var reply map[string]interface{}
+	for _, msg := range history {
+		if msg["sender_type"] == "admin" {
+			reply = msg
+		}
+	}
+	require.NotNil(t, reply)
+	require.NotNil(t, reply["session_id"])
+	assert.Equal(t, reply["id"], event["id"])
+	assert.Equal(t, reply["id"], event["message_id"])
+	assert.Equal(t, reply["session_id"], event["session_id"])
+	assert.Equal(t, reply["seq"], event["seq"])
+	assert.Equal(t, float64(2), event["seq"])
+	assert.Equal(t, reply["created_at"], event["created_at"])
+}
+
+func TestDeliveryReceiptMarksReplyDelivered(t *testing.T) {
+	database := setupTestDB(t)
+	manager := ws.NewManager()
+	manager.SetOfflineQueue(10, 0, 0)
+	chatService := chat.NewChatService(database, NewMockXMPPClient(), manager)
+	chatService.SetAdminAllowlist([]string{"admin@example.com"})
+	manager.SetDeliveryReceipts(chatService.MarkDelivered)
+	
+	user, err := database.CreateUser("receipts@example.com", "hashedpass")
+	require.NoError(t, err)
+	own, err := database.SaveMessage(user.ID, "Where is my order?", db.SenderUser)
+	require.NoError(t, err)
+	
+	// A user can't mark their own messages delivered
+	assert.ErrorIs(t, chatService.MarkDelivered(user.ID, own.ID), chat.ErrMessageNotFound)
+	
+	// Replies sent while the user is away wait in the queue
+	require.NoError(t, chatService.HandleAdminReply(xmpp.XMPPMessage{From: "admin@example.com", To: user.XmppJID, Body: "On its way"}))
+	require.Equal(t, 1, manager.GetQueuedCount(user.ID))
+	messages, err := database.GetUserMessages(user.ID)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	reply := messages[1]
+	assert.Equal(t, db.StatusSent, reply.Status)
+	
+	server := startManagerServer(t, manager)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?user="+strconv.Itoa(user.ID), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, []int{reply.ID}, readFrames(t, conn, 1))
+	
+	// Still queued after sending, until the client confirms it
+	assert.Equal(t, 1, manager.GetQueuedCount(user.ID))
+	require.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "delivered", "message_id": reply.ID}))
+	
+	require.Eventually(t, func() bool {
+		msg, err := database.GetMessageByID(reply.ID)
+		return err == nil && msg.Status == db.StatusDelivered
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, manager.GetQueuedCount(user.ID))
+}
+
+func TestUnroutableReplyGoesToFallbackAdmin(t *testing.T) {
+	mockXMPP := NewMockXMPPClient()
+	mockXMPP.Connect()
+	app, database, chatService := setupChatTestApp(t, mockXMPP)
+	chatService.SetAdminAllowlist([]string{"admin@example.com"})
+	chatService.SetFallbackAdmin("supervisor@example.com")
+	
+	user, _ := registerUser(t, app, "routed@example.com", "password123")
+	
+	// A reply to a JID nobody has is forwarded rather than lost
+	err := chatService.HandleAdminReply(xmpp.XMPPMessage{From: "admin@example.com/desktop", To: "ghost@example.com", Body: "Your refund is approved"})
+	assert.ErrorIs(t, err, xmpp.ErrUnknownRecipient)
+	
+	sent := mockXMPP.GetReceivedMessages()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "supervisor@example.com", sent[0].To)
+	assert.Contains(t, sent[0].Body, "admin@example.com/desktop")
+	assert.Contains(t, sent[0].Body, "ghost@example.com")
+	assert.Contains(t, sent[0].Body, "Your refund is approved")
+	
+	// Strangers aren't relayed, and routable replies aren't copied
+	err = chatService.HandleAdminReply(xmpp.XMPPMessage{From: "stranger@example.com", To: "ghost@example.com", Body: "spam"})
+	assert.ErrorIs(t, err, xmpp.ErrUnknownRecipient)
+	err = chatService.HandleAdminReply(xmpp.XMPPMessage{From: "admin@example.com", To: user["xmpp_jid"].(string), Body: "Hi"})
+	require.NoError(t, err)
+	assert.Len(t, mockXMPP.GetReceivedMessages(), 1)
+	
+	messages, err := database.GetUserMessages(int(user["id"].(float64)))
+	require.NoError(t, err)
+	assert.Len(t, messages, 1)
+	
+	// With no admins configured nobody is relayed
+	chatService.SetAdminAllowlist(nil)
+	err = chatService.HandleAdminReply(xmpp.XMPPMessage{From: "admin@example.com", To: "ghost@example.com", Body: "Anyone?"})
+	assert.ErrorIs(t, err, xmpp.ErrUnknownRecipient)
+	assert.Len(t, mockXMPP.GetReceivedMessages(), 1)
+}
+
+func TestUnroutableReplyWithoutFallbackIsOnlyReported(t *testing.T) {
+	mockXMPP := NewMockXMPPClient()
+	mockXMPP.Connect()
+	_, _, chatService := setupChatTestApp(t, mockXMPP)
+	
+	err := chatService.HandleAdminReply(xmpp.XMPPMessage{From: "admin@example.com", To: "ghost@example.com", Body: "Hello?"})
+	assert.ErrorIs(t, err, xmpp.ErrUnknownRecipient)
+	assert.Empty(t, mockXMPP.GetReceivedMessages())
+}
+
+func TestExpiredMessagesArePurged(t *testing.T) {
+	app, database, chatService := setupChatTestApp(t, NewMockXMPPClient())
+	
+	server := httptest.NewServer(app)
+	defer server.Close()
+	
+	user, token := registerUser(t, app, "ephemeral@example.com", "password123")
+	userID := int(user["id"].(float64))
+	
+	send := func(body string) int {
+		req := httptest.NewRequest("POST", "/api/send", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w.Code
+	}
+	require.Equal(t, 200, send(`{"message":"My card is 4111 1111 1111 1111","ttl":1}`))
+	require.Equal(t, 200, send(`{"message":"Thanks for the help"}`))
+	assert.Equal(t, 400, send(`{"message":"Never","ttl":-1}`))
+	
+	messages, err := database.GetUserMessages(userID)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	require.NotNil(t, messages[0].ExpiresAt)
+	assert.Nil(t, messages[1].ExpiresAt)
+	// Expiry comes from the database's clock, like created_at
+	assert.WithinDuration(t, messages[0].CreatedAt.Add(time.Second), *messages[0].ExpiresAt, 50*time.Millisecond)
+	
+	// Nothing is due yet
+	purged, err := chatService.PurgeExpiredMessages()
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+	
+	conn := dialTestWebSocket(t, server, token)
+	defer conn.Close()
+	
+	time.Sleep(time.Until(*messages[0].ExpiresAt) + 100*time.Millisecond)
+	purged, err = chatService.PurgeExpiredMessages()
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	
+	// Connected clients are told to remove it
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, map[string]interface{}{"type": "deleted", "message_id": float64(messages[0].ID), "seq": float64(1)}, event)
+	
+	expired, err := database.GetMessageByID(messages[0].ID)
+	require.NoError(t, err)
+	assert.Empty(t, expired.Content)
+	assert.NotNil(t, expired.DeletedAt)
+	
+	// The normal message persists
+	kept, err := database.GetMessageByID(messages[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Thanks for the help", kept.Content)
+	assert.Nil(t, kept.DeletedAt)
+	
+	purged, err = chatService.PurgeExpiredMessages()
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+}
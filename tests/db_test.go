@@ -3,10 +3,14 @@ package tests
 import (
 	"context"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/ngenohkevin/veilsupport/internal/db"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func setupTestDB(t *testing.T) *db.DB {
@@ -31,43 +35,72 @@ func setupTestDB(t *testing.T) *db.DB {
 }
 
 func cleanupTestDB(t *testing.T, database *db.DB) {
-	// Drop tables if they exist
-	_, err := database.GetConn().Exec(context.Background(), "DROP TABLE IF EXISTS messages CASCADE")
-	assert.NoError(t, err)
-	_, err = database.GetConn().Exec(context.Background(), "DROP TABLE IF EXISTS users CASCADE")
+	// Roll back every migration, newest first
+	files, err := filepath.Glob("../migrations/*.down.sql")
 	assert.NoError(t, err)
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	
+	for _, file := range files {
+		sql, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		_, err = database.GetConn().Exec(context.Background(), string(sql))
+		assert.NoError(t, err, "rolling back %s", file)
+	}
 }
 
 func runTestMigrations(t *testing.T, database *db.DB) {
-	// Create users table
-	_, err := database.GetConn().Exec(context.Background(), `
-		CREATE TABLE users (
-			id SERIAL PRIMARY KEY,
-			email VARCHAR(255) UNIQUE NOT NULL,
-			password_hash VARCHAR(255) NOT NULL,
-			xmpp_jid VARCHAR(255) UNIQUE NOT NULL,
-			created_at TIMESTAMP DEFAULT NOW()
-		)
-	`)
-	assert.NoError(t, err)
-
-	// Create messages table
-	_, err = database.GetConn().Exec(context.Background(), `
-		CREATE TABLE messages (
-			id SERIAL PRIMARY KEY,
-			user_id INTEGER REFERENCES users(id),
-			content TEXT NOT NULL,
-			sender_type VARCHAR(20) NOT NULL,
-			created_at TIMESTAMP DEFAULT NOW()
-		)
-	`)
-	assert.NoError(t, err)
-
-	// Create index
-	_, err = database.GetConn().Exec(context.Background(), `
-		CREATE INDEX idx_messages_user_id ON messages(user_id)
-	`)
+	// Apply every migration in order
+	applyTestMigrations(t, database, upMigrations(t))
+}
+
+// upMigrations lists the up migrations, oldest first
+func upMigrations(t *testing.T) []string {
+	files, err := filepath.Glob("../migrations/*.up.sql")
 	assert.NoError(t, err)
+	sort.Strings(files)
+	return files
+}
+
+func applyTestMigrations(t *testing.T, database *db.DB, files []string) {
+	for _, file := range files {
+		sql, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		_, err = database.GetConn().Exec(context.Background(), string(sql))
+		assert.NoError(t, err, "applying %s", file)
+	}
+}
+
+func TestMessageStatusMigrationBackfillsSent(t *testing.T) {
+	database := setupTestDB(t)
+	cleanupTestDB(t, database)
+	files := upMigrations(t)
+	require.True(t, strings.HasSuffix(files[2], "003_message_status.up.sql"))
+	
+	// A message stored before statuses existed
+	ctx := context.Background()
+	applyTestMigrations(t, database, files[:2])
+	var userID int
+	require.NoError(t, database.GetConn().QueryRow(ctx,
+		`INSERT INTO users (email, password_hash, xmpp_jid) VALUES ('early@example.com', 'x', 'early@xmpp.jp') RETURNING id`).Scan(&userID))
+	_, err := database.GetConn().Exec(ctx,
+		`INSERT INTO messages (user_id, content, sender_type) VALUES ($1, 'Bridged long ago', 'user')`, userID)
+	require.NoError(t, err)
+	applyTestMigrations(t, database, files[2:])
+	
+	// It was already bridged, so a drain must not send it again
+	messages, err := database.GetUserMessages(userID)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, db.StatusSent, messages[0].Status)
+	
+	// New messages still start out pending
+	msg, err := database.SaveMessage(userID, "Hello again", db.SenderUser)
+	require.NoError(t, err)
+	assert.Equal(t, db.StatusPending, msg.Status)
+	pending, err := database.GetMessagesByStatus(db.StatusPending, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, msg.ID, pending[0].ID)
 }
 
 func createTestUser(t *testing.T, database *db.DB) *db.User {
@@ -117,4 +150,129 @@ func TestGetUserMessages(t *testing.T) {
 	assert.Len(t, messages, 2)
 	assert.Equal(t, "Message 1", messages[0].Content)
 	assert.Equal(t, "Message 2", messages[1].Content)
-}
\ No newline at end of file
+}
+
+func TestUserHistoryAcrossSessions(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	user := createTestUser(t, database)
+
+	// First session
+	_, err := database.SaveMessage(user.ID, "First question", "user")
+	assert.NoError(t, err)
+	_, err = database.SaveMessage(user.ID, "First answer", "admin")
+	assert.NoError(t, err)
+
+	first, err := database.GetActiveSession(user.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, first)
+	_, err = database.ResolveSession(first.ID)
+	assert.NoError(t, err)
+
+	// Second session is opened automatically by the next message
+	_, err = database.SaveMessage(user.ID, "Second question", "user")
+	assert.NoError(t, err)
+	_, err = database.SaveMessage(user.ID, "Second answer", "admin")
+	assert.NoError(t, err)
+
+	second, err := database.GetActiveSession(user.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, second)
+	assert.NotEqual(t, first.ID, second.ID)
+
+	history, err := database.GetUserHistory(user.ID)
+	assert.NoError(t, err)
+	assert.Len(t, history, 4)
+
+	expected := []string{"First question", "First answer", "Second question", "Second answer"}
+	for i, entry := range history {
+		assert.Equal(t, expected[i], entry.Content)
+	}
+
+	// Boundaries are marked on the first message of each session
+	assert.True(t, history[0].SessionStart)
+	assert.False(t, history[1].SessionStart)
+	assert.True(t, history[2].SessionStart)
+	assert.False(t, history[3].SessionStart)
+
+	assert.Equal(t, first.ID, *history[0].SessionID)
+	assert.Equal(t, first.ID, *history[1].SessionID)
+	assert.Equal(t, second.ID, *history[2].SessionID)
+	assert.Equal(t, second.ID, *history[3].SessionID)
+}
+
+func TestMessageSequenceNumbers(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	alice := createTestUser(t, database)
+	bob, err := database.CreateUser("bob@example.com", "hashedpassword")
+	assert.NoError(t, err)
+
+	// Numbering is sequential within a session, whoever sends
+	for i, sender := range []string{"user", "admin", "system", "user"} {
+		msg, err := database.SaveMessage(alice.ID, "message", sender)
+		assert.NoError(t, err)
+		assert.Equal(t, i+1, msg.Seq)
+	}
+
+	// Another user's session counts independently
+	msg, err := database.SaveMessage(bob.ID, "hello", "user")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, msg.Seq)
+
+	// A new session for the same user starts again at 1
+	session, err := database.GetActiveSession(alice.ID)
+	assert.NoError(t, err)
+	_, err = database.ResolveSession(session.ID)
+	assert.NoError(t, err)
+
+	msg, err = database.SaveMessage(alice.ID, "back again", "user")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, msg.Seq)
+
+	// Sequence numbers are part of the history
+	history, err := database.GetUserHistory(alice.ID)
+	assert.NoError(t, err)
+	assert.Len(t, history, 5)
+	for i, want := range []int{1, 2, 3, 4, 1} {
+		assert.Equal(t, want, history[i].Seq)
+	}
+}
+
+func TestUserMetadataRoundTrip(t *testing.T) {
+	database := setupTestDB(t)
+	defer database.Close()
+
+	user := createTestUser(t, database)
+
+	// New users start without metadata
+	metadata, err := database.GetUserMetadata(user.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, metadata)
+
+	err = database.SetUserMetadata(user.ID, db.Metadata{"plan": "pro", "account_id": float64(4521), "beta": true})
+	assert.NoError(t, err)
+
+	metadata, err = database.GetUserMetadata(user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, db.Metadata{"plan": "pro", "account_id": float64(4521), "beta": true}, metadata)
+
+	// It's loaded with the user too
+	loaded, err := database.GetUserByID(user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "pro", loaded.Metadata["plan"])
+
+	// nil clears it
+	assert.NoError(t, database.SetUserMetadata(user.ID, nil))
+	metadata, err = database.GetUserMetadata(user.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, metadata)
+
+	// Unknown users
+	metadata, err = database.GetUserMetadata(user.ID + 1000)
+	assert.NoError(t, err)
+	assert.Nil(t, metadata)
+	assert.Error(t, database.SetUserMetadata(user.ID+1000, db.Metadata{"plan": "pro"}))
+}
@@ -0,0 +1,240 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"testing"
+
+	"github.com/ngenohkevin/veilsupport/internal/chat"
+	"github.com/ngenohkevin/veilsupport/internal/ws"
+	"github.com/ngenohkevin/veilsupport/internal/xmpp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"mellium.im/xmlstream"
+)
+
+// encodeStanza renders a stanza to XML for assertions
+func encodeStanza(t *testing.T, r xml.TokenReader) string {
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	_, err := xmlstream.Copy(enc, r)
+	require.NoError(t, err)
+	require.NoError(t, enc.Flush())
+	return buf.String()
+}
+
+func TestReplyConfirmationOnSuccessfulRoute(t *testing.T) {
+	confirmation, err := xmpp.ReplyConfirmation("agent@example.com/desk", 101, nil)
+	require.NoError(t, err)
+
+	out := encodeStanza(t, confirmation)
+	assert.Contains(t, out, `to="agent@example.com/desk"`)
+	assert.Contains(t, out, `type="chat"`)
+	assert.Contains(t, out, "<body>✅ Delivered to user 101</body>")
+	assert.NotContains(t, out, "<error")
+}
+
+func TestReplyConfirmationOnFailedRoute(t *testing.T) {
+	cases := []struct {
+		err       error
+		errorType string
+		condition string
+	}{
+		{xmpp.ErrUnknownRecipient, "modify", "bad-request"},
+		{fmt.Errorf("%w: %d", xmpp.ErrUserNotFound, 404), "cancel", "item-not-found"},
+		{fmt.Errorf("failed to save admin message: connection reset"), "cancel", "internal-server-error"},
+	}
+
+	for _, tc := range cases {
+		confirmation, err := xmpp.ReplyConfirmation("agent@example.com", 404, tc.err)
+		require.NoError(t, err)
+
+		out := encodeStanza(t, confirmation)
+		assert.Contains(t, out, `type="error"`, tc.err.Error())
+		assert.Contains(t, out, fmt.Sprintf(`<error type="%s">`, tc.errorType), tc.err.Error())
+		assert.Contains(t, out, "<"+tc.condition, tc.err.Error())
+		assert.Contains(t, out, "Reply not delivered: "+tc.err.Error())
+	}
+}
+
+func TestReplyConfirmationInvalidAdminJID(t *testing.T) {
+	_, err := xmpp.ReplyConfirmation("not a jid@", 1, nil)
+	assert.Error(t, err)
+}
+
+func TestReplyConfirmationsAreOptIn(t *testing.T) {
+	gateway := xmpp.NewGatewayClient("bot@example.com", "password", "localhost:5222", []string{"agent@example.com"})
+
+	// Disabled by default, so nothing is attempted
+	assert.NoError(t, gateway.ConfirmReply("agent@example.com", 101, nil))
+
+	// Once enabled, the gateway tries to send (and fails without a connection)
+	gateway.SetReplyConfirmations(true)
+	assert.Error(t, gateway.ConfirmReply("agent@example.com", 101, nil))
+}
+
+func TestAdminReplyRouteErrors(t *testing.T) {
+	gateway := xmpp.NewGatewayClient("bot@example.com", "password", "localhost:5222", []string{"agent@example.com"})
+
+	_, err := gateway.HandleAdminReply("agent@example.com", "no recipient here")
+	assert.ErrorIs(t, err, xmpp.ErrUnknownRecipient)
+}
+
+func TestResourceIDsDistinguishSimilarDisplayNames(t *testing.T) {
+	// These all collapsed to "user_7_john_doe" with the old lowercase/replace scheme
+	names := []string{"John Doe", "john doe", "John_Doe", "john@doe", "john_doe"}
+
+	seen := map[string]string{}
+	for _, name := range names {
+		resourceID := xmpp.EncodeResourceID(7, name)
+		if other, exists := seen[resourceID]; exists {
+			t.Fatalf("%q and %q both encode to %s", other, name, resourceID)
+		}
+		seen[resourceID] = name
+	}
+
+	// Encoding is deterministic
+	assert.Equal(t, xmpp.EncodeResourceID(7, "John Doe"), xmpp.EncodeResourceID(7, "John Doe"))
+}
+
+func TestResourceIDRoundTrip(t *testing.T) {
+	for _, name := range []string{"John Doe", "jöhn_dœ@example.com", "", "a/b c?d"} {
+		resourceID := xmpp.EncodeResourceID(42, name)
+		assert.Regexp(t, `^user_42_[A-Za-z0-9_-]*$`, resourceID)
+
+		userID, displayName, err := xmpp.ParseResourceID(resourceID)
+		require.NoError(t, err)
+		assert.Equal(t, 42, userID)
+		assert.Equal(t, name, displayName)
+	}
+
+	for _, invalid := range []string{"user_x_Zm9v", "admin_1_Zm9v", "user_1_!!", "user_1_Zm9v/extra"} {
+		_, _, err := xmpp.ParseResourceID(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestAdminReplyRoutedByUserMention(t *testing.T) {
+	gateway := xmpp.NewGatewayClient("bot@example.com", "password", "localhost:5222", []string{"agent@example.com"})
+	resourceID := gateway.RegisterUser(101, "john@example.com", "John Doe")
+
+	msg, err := gateway.HandleAdminReply("agent@example.com", "@user_101 Your order has shipped")
+	require.NoError(t, err)
+	assert.Equal(t, 101, msg.UserID)
+
+	// A full resource ID works too
+	msg, err = gateway.HandleAdminReply("agent@example.com", "@"+resourceID+" thanks")
+	require.NoError(t, err)
+	assert.Equal(t, 101, msg.UserID)
+
+	// Shared upload links become attachments
+	msg, err = gateway.HandleAdminReply("agent@example.com", "@user_101 https://upload.example.com/abc/label.pdf")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://upload.example.com/abc/label.pdf"}, msg.Attachments)
+
+	_, err = gateway.HandleAdminReply("agent@example.com", "@user_202 hello")
+	assert.ErrorIs(t, err, xmpp.ErrUserNotFound)
+}
+
+func TestGatewayDropsRepliesFromNonAdmins(t *testing.T) {
+	gateway := xmpp.NewGatewayClient("bot@example.com", "password", "localhost:5222", []string{"agent@example.com"})
+	gateway.RegisterUser(101, "john@example.com", "John Doe")
+
+	_, err := gateway.HandleAdminReply("stranger@example.com/phone", "@user_101 click this link")
+	assert.ErrorIs(t, err, xmpp.ErrNotAdmin)
+
+	// The admin's other devices and differently-cased JIDs are still the admin
+	msg, err := gateway.HandleAdminReply("Agent@Example.com/laptop", "@user_101 Your order has shipped")
+	require.NoError(t, err)
+	assert.Equal(t, 101, msg.UserID)
+}
+
+func TestAllowlist(t *testing.T) {
+	allowlist := xmpp.NewAllowlist([]string{" admin@example.com ", "agent@example.com/desk", "", "not a jid@"})
+	assert.True(t, allowlist.Allows("admin@example.com"))
+	assert.True(t, allowlist.Allows("admin@example.com/mobile"))
+	assert.True(t, allowlist.Allows("agent@example.com/anything"))
+	assert.False(t, allowlist.Allows("admin@evil.example.com"))
+	assert.False(t, allowlist.Allows(""))
+
+	// Nothing configured allows nobody
+	assert.False(t, xmpp.NewAllowlist(nil).Allows("anyone@example.com"))
+	assert.False(t, xmpp.NewAllowlist(nil).Allows(""))
+}
+
+func TestGatewayThreadsPerUser(t *testing.T) {
+	session := &fakeSession{}
+	gateway := xmpp.NewGatewayClient("bot@example.com", "password", "localhost:5222", []string{"agent@example.com"})
+	gateway.SetSession(session)
+	gateway.SetThreads(true)
+	gateway.RegisterUser(101, "john@example.com", "John Doe")
+	gateway.RegisterUser(202, "jane@example.com", "Jane Roe")
+
+	require.NoError(t, gateway.SendUserMessage(101, "Where is my order?", nil))
+	require.NoError(t, gateway.SendUserMessage(202, "Can I change my plan?", nil))
+	require.NoError(t, gateway.SendUserMessage(101, "It's been a week", nil))
+
+	sent := session.stanzas()
+	require.Len(t, sent, 3)
+	assert.Contains(t, sent[0], "<subject>John Doe &lt;john@example.com&gt;</subject>")
+	assert.Contains(t, sent[0], "<thread>"+xmpp.ThreadID(101)+"</thread>")
+	assert.Contains(t, sent[1], "<subject>Jane Roe &lt;jane@example.com&gt;</subject>")
+	assert.Contains(t, sent[1], "<thread>"+xmpp.ThreadID(202)+"</thread>")
+	assert.NotEqual(t, xmpp.ThreadID(101), xmpp.ThreadID(202))
+
+	// The same user stays in the same thread
+	assert.Contains(t, sent[2], "<thread>"+xmpp.ThreadID(101)+"</thread>")
+}
+
+func TestGatewayThreadsAreOptIn(t *testing.T) {
+	session := &fakeSession{}
+	gateway := xmpp.NewGatewayClient("bot@example.com", "password", "localhost:5222", []string{"agent@example.com"})
+	gateway.SetSession(session)
+	gateway.RegisterUser(101, "john@example.com", "John Doe")
+
+	require.NoError(t, gateway.SendUserMessage(101, "Hello", nil))
+
+	sent := session.stanzas()
+	require.Len(t, sent, 1)
+	assert.Contains(t, sent[0], "<body>")
+	assert.NotContains(t, sent[0], "<thread>")
+	assert.NotContains(t, sent[0], "<subject>")
+}
+
+func TestGatewayForwardsUnroutableReplies(t *testing.T) {
+	database := setupTestDB(t)
+	cfg, err := loadConfig(t, map[string]string{
+		"XMPP_ADMIN_JIDS":         "agent@example.com",
+		"XMPP_FALLBACK_ADMIN_JID": "supervisor@example.com",
+		"UPLOAD_DIR":              t.TempDir(),
+	})
+	require.NoError(t, err)
+	service := chat.NewGatewayService(database, ws.NewManager(), cfg)
+	session := &fakeSession{}
+	service.SetSession(session)
+
+	user, err := database.CreateUser("gateway-routed@example.com", "hash")
+	require.NoError(t, err)
+	require.NoError(t, service.RegisterUser(user.ID))
+
+	// Replies to a user the gateway doesn't know, or to nobody, are forwarded
+	err = service.HandleAdminReply("agent@example.com", "@user_999999 Your refund is approved")
+	assert.ErrorIs(t, err, xmpp.ErrUserNotFound)
+	err = service.HandleAdminReply("agent@example.com", "Who was asking about shipping?")
+	assert.ErrorIs(t, err, xmpp.ErrUnknownRecipient)
+
+	sent := session.stanzas()
+	require.Len(t, sent, 2)
+	assert.Contains(t, sent[0], `to="supervisor@example.com"`)
+	assert.Contains(t, sent[0], "agent@example.com")
+	assert.Contains(t, sent[0], "Your refund is approved")
+	assert.Contains(t, sent[1], `to="supervisor@example.com"`)
+	assert.Contains(t, sent[1], "Who was asking about shipping?")
+
+	// Strangers aren't relayed, and routable replies aren't copied
+	err = service.HandleAdminReply("stranger@example.com", "spam")
+	assert.ErrorIs(t, err, xmpp.ErrNotAdmin)
+	require.NoError(t, service.HandleAdminReply("agent@example.com", fmt.Sprintf("@user_%d Hi", user.ID)))
+	assert.Len(t, session.stanzas(), 2)
+}
@@ -0,0 +1,49 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/ngenohkevin/veilsupport/internal/i18n"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalogRendersUserLocale(t *testing.T) {
+	catalog := i18n.NewCatalog("en")
+	
+	assert.Equal(t, "¡Bienvenido a VeilSupport! Un agente te atenderá en breve.", catalog.Render("es", i18n.KeyWelcome))
+	assert.Equal(t, "Welcome to VeilSupport! An agent will be with you shortly.", catalog.Render("en", i18n.KeyWelcome))
+	
+	// Region variants fall back to their base language
+	assert.Equal(t, catalog.Render("es", i18n.KeyWelcome), catalog.Render("es_MX", i18n.KeyWelcome))
+}
+
+func TestCatalogFallsBackToDefaultLocale(t *testing.T) {
+	catalog := i18n.NewCatalog("en")
+	catalog.Add("sw", i18n.KeyWelcome, "Karibu VeilSupport!")
+	
+	// Present translation is used
+	assert.Equal(t, "Karibu VeilSupport!", catalog.Render("sw", i18n.KeyWelcome))
+	
+	// Missing translation falls back to the default locale
+	assert.Equal(t, catalog.Render("en", i18n.KeySurvey, "url"), catalog.Render("sw", i18n.KeySurvey, "url"))
+	
+	// Unknown locales and empty preferences use the default
+	assert.Equal(t, catalog.Render("en", i18n.KeyWelcome), catalog.Render("xx", i18n.KeyWelcome))
+	assert.Equal(t, catalog.Render("en", i18n.KeyWelcome), catalog.Render("", i18n.KeyWelcome))
+	
+	// A different server default changes the fallback
+	french := i18n.NewCatalog("fr")
+	assert.Equal(t, french.Render("fr", i18n.KeyWelcome), french.Render("", i18n.KeyWelcome))
+	
+	// Unknown keys render as the key itself
+	assert.Equal(t, "no_such_key", catalog.Render("en", "no_such_key"))
+}
+
+func TestLocaleValidation(t *testing.T) {
+	for _, locale := range []string{"en", "pt-BR", "zh_Hant", "fil"} {
+		assert.True(t, i18n.Valid(locale), locale)
+	}
+	for _, locale := range []string{"", "e", "english", "en-", "en-US-!", "12"} {
+		assert.False(t, i18n.Valid(locale), locale)
+	}
+}
@@ -0,0 +1,167 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ngenohkevin/veilsupport/internal/auth"
+	"github.com/ngenohkevin/veilsupport/internal/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testResetURL = "https://support.example.com/reset?token={token}"
+
+type importResponse struct {
+	Created    int                 `json:"created"`
+	Duplicates int                 `json:"duplicates"`
+	Invalid    int                 `json:"invalid"`
+	Results    []auth.ImportResult `json:"results"`
+}
+
+func TestParseUserCSV(t *testing.T) {
+	rows, err := handlers.ParseUserCSV(strings.NewReader(
+		"Email,Display Name,Metadata\n" +
+			"jane@example.com,Jane Doe,\"{\"\"plan\"\":\"\"pro\"\"}\"\n" +
+			"\n" +
+			"john@example.com\n"))
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, auth.ImportRow{Line: 2, Email: "jane@example.com", DisplayName: "Jane Doe",
+		Metadata: map[string]interface{}{"plan": "pro"}}, rows[0])
+	assert.Equal(t, auth.ImportRow{Line: 4, Email: "john@example.com"}, rows[1])
+
+	for _, invalid := range []string{
+		"jane@example.com,Jane,not json\n",
+		"jane@example.com,Jane,{},extra\n",
+		"\"unterminated\n",
+	} {
+		_, err := handlers.ParseUserCSV(strings.NewReader(invalid))
+		assert.Error(t, err, invalid)
+	}
+
+	_, err = handlers.ParseUserCSV(strings.NewReader(strings.Repeat("a@example.com\n", 1001)))
+	assert.Error(t, err)
+}
+
+func TestImportUsersFromCSV(t *testing.T) {
+	app, _, _, database := setupAdminTestAppWithDB(t)
+	adminToken := registerAdmin(t, app, database)
+	registerUser(t, app, "taken@example.com", "password123")
+
+	csv := "email,display_name,metadata\n" +
+		"new1@example.com,New One,\"{\"\"plan\"\":\"\"pro\"\"}\"\n" +
+		"taken@example.com,,\n" +
+		"new2@example.com\n" +
+		"NEW1@example.com,Again,\n" +
+		"not-an-email,,\n"
+	w := adminRequest(t, app, "POST", "/api/admin/users/import", adminToken, csv)
+	require.Equal(t, 200, w.Code, w.Body.String())
+
+	var response importResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 2, response.Created)
+	assert.Equal(t, 2, response.Duplicates)
+	assert.Equal(t, 1, response.Invalid)
+	require.Len(t, response.Results, 5)
+
+	statuses := map[int]string{}
+	for _, result := range response.Results {
+		statuses[result.Line] = result.Status
+	}
+	assert.Equal(t, map[int]string{2: "created", 3: "duplicate", 4: "created", 5: "duplicate", 6: "invalid"}, statuses)
+
+	created := response.Results[0]
+	assert.Equal(t, "new1@example.com", created.Email)
+	assert.NotZero(t, created.UserID)
+	require.NotEmpty(t, created.ResetToken)
+	assert.Equal(t, "https://support.example.com/reset?token="+created.ResetToken, created.ResetURL)
+	assert.Empty(t, response.Results[1].ResetToken)
+
+	user, err := database.GetUserByEmail("new1@example.com")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, "pro", user.Metadata["plan"])
+	assert.Equal(t, "New One", user.Metadata["display_name"])
+
+	// Imported users have no password until the reset link sets one
+	assert.Equal(t, 401, adminRequest(t, app, "POST", "/api/login", "", `{"email":"new1@example.com","password":"!"}`).Code)
+
+	// The reset link sets a real one
+	reset := func(token string) int {
+		return adminRequest(t, app, "POST", "/api/password/reset", "",
+			`{"token":"`+token+`","password":"chosen-password"}`).Code
+	}
+	assert.Equal(t, 200, reset(created.ResetToken))
+	w = adminRequest(t, app, "POST", "/api/login", "", `{"email":"new1@example.com","password":"chosen-password"}`)
+	assert.Equal(t, 200, w.Code, w.Body.String())
+
+	// Links work once
+	assert.Equal(t, 400, reset(created.ResetToken))
+	assert.Equal(t, 400, reset("not-a-token"))
+}
+
+func TestImportUsersRejectsMalformedCSV(t *testing.T) {
+	app, _, _, database := setupAdminTestAppWithDB(t)
+	adminToken := registerAdmin(t, app, database)
+
+	// A bad line anywhere means nothing is imported
+	w := adminRequest(t, app, "POST", "/api/admin/users/import", adminToken,
+		"first@example.com\nsecond@example.com,Second,{broken\n")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "line 2")
+
+	user, err := database.GetUserByEmail("first@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, user)
+}
+
+func TestImportUsersFromUploadedFile(t *testing.T) {
+	app, _, _, database := setupAdminTestAppWithDB(t)
+	adminToken := registerAdmin(t, app, database)
+	_, userToken := registerUser(t, app, "sneaky@example.com", "password123")
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "users.csv")
+	require.NoError(t, err)
+	part.Write([]byte("uploaded@example.com,Uploaded\n"))
+	require.NoError(t, form.Close())
+
+	upload := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/admin/users/import", bytes.NewReader(body.Bytes()))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, 403, upload(userToken).Code)
+
+	w := upload(adminToken)
+	require.Equal(t, 200, w.Code, w.Body.String())
+	var response importResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 1, response.Created)
+}
+
+func TestImportUsersIsAllOrNothing(t *testing.T) {
+	app, _, _, database := setupAdminTestAppWithDB(t)
+	adminToken := registerAdmin(t, app, database)
+
+	// The second address is valid but too long for the column, so its
+	// insert fails after the first user was already written
+	tooLong := strings.Repeat("a", 300) + "@example.com"
+	w := adminRequest(t, app, "POST", "/api/admin/users/import", adminToken,
+		"kept@example.com\n"+tooLong+"\n")
+	assert.Equal(t, 500, w.Code, w.Body.String())
+
+	user, err := database.GetUserByEmail("kept@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, user)
+}
@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ngenohkevin/veilsupport/internal/auth"
+	"github.com/ngenohkevin/veilsupport/internal/chat"
+	"github.com/ngenohkevin/veilsupport/internal/db"
+	"github.com/ngenohkevin/veilsupport/internal/handlers"
+	"github.com/ngenohkevin/veilsupport/internal/ws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const inboundSecret = "inbound-test-secret"
+
+func setupInboundTestApp(t *testing.T, createUsers bool) (*gin.Engine, *db.DB) {
+	gin.SetMode(gin.TestMode)
+
+	database := setupTestDB(t)
+	authService := auth.NewAuthService(database, "test-secret-key")
+	wsManager := ws.NewManager()
+	chatService := chat.NewChatService(database, NewMockXMPPClient(), wsManager)
+	h := handlers.NewHandlers(authService, chatService, wsManager)
+	h.SetInboundWebhook(inboundSecret, createUsers)
+
+	r := gin.New()
+	r.POST("/api/register", h.Register)
+	r.POST("/api/inbound", h.Inbound)
+	return r, database
+}
+
+func postInbound(app *gin.Engine, body, timestamp, signature string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/inbound", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if timestamp != "" {
+		req.Header.Set(handlers.InboundTimestampHeader, timestamp)
+	}
+	if signature != "" {
+		req.Header.Set(handlers.InboundSignatureHeader, signature)
+	}
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	return w
+}
+
+// postSignedInbound posts body signed with the test secret at the current time
+func postSignedInbound(app *gin.Engine, body string) *httptest.ResponseRecorder {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	return postInbound(app, body, timestamp, handlers.SignInbound(inboundSecret, timestamp, []byte(body)))
+}
+
+func TestInboundSignedMessageIsSaved(t *testing.T) {
+	app, database := setupInboundTestApp(t, false)
+	user, _ := registerUser(t, app, "mailer@example.com", "password123")
+
+	body := `{"user_email":"mailer@example.com","content":"Reply from my inbox","source":"email"}`
+	w := postSignedInbound(app, body)
+	require.Equal(t, 200, w.Code, w.Body.String())
+
+	messages, err := database.GetUserMessages(int(user["id"].(float64)))
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "Reply from my inbox", messages[0].Content)
+	assert.Equal(t, "user", messages[0].SenderType)
+
+	// Unknown senders are rejected unless user creation is enabled
+	body = `{"user_email":"stranger@example.com","content":"Hi","source":"email"}`
+	w = postSignedInbound(app, body)
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestInboundCreatesUserWhenConfigured(t *testing.T) {
+	app, database := setupInboundTestApp(t, true)
+
+	body := `{"user_email":"new@example.com","content":"First contact","source":"email"}`
+	w := postSignedInbound(app, body)
+	require.Equal(t, 200, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"user_created":true`)
+
+	user, err := database.GetUserByEmail("new@example.com")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, "email", user.Metadata["source"])
+
+	messages, err := database.GetUserMessages(user.ID)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "First contact", messages[0].Content)
+}
+
+func TestInboundRejectsInvalidSignature(t *testing.T) {
+	app, database := setupInboundTestApp(t, true)
+
+	body := `{"user_email":"forged@example.com","content":"Let me in","source":"email"}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	for _, signature := range []string{
+		"",
+		handlers.SignInbound("wrong-secret", now, []byte(body)),
+		handlers.SignInbound(inboundSecret, now, []byte(body+" ")),
+		strings.TrimPrefix(handlers.SignInbound(inboundSecret, now, []byte(body)), "sha256="),
+		"sha256=not-hex",
+	} {
+		w := postInbound(app, body, now, signature)
+		assert.Equal(t, 401, w.Code, signature)
+	}
+
+	// The timestamp is covered by the signature
+	signature := handlers.SignInbound(inboundSecret, now, []byte(body))
+	later := strconv.FormatInt(time.Now().Unix()+1, 10)
+	assert.Equal(t, 401, postInbound(app, body, later, signature).Code)
+	assert.Equal(t, 401, postInbound(app, body, "", signature).Code)
+
+	// Correctly signed requests from outside the window are replays
+	for _, at := range []time.Time{
+		time.Now().Add(-handlers.InboundMaxSkew - time.Minute),
+		time.Now().Add(handlers.InboundMaxSkew + time.Minute),
+	} {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		w := postInbound(app, body, timestamp, handlers.SignInbound(inboundSecret, timestamp, []byte(body)))
+		assert.Equal(t, 401, w.Code, timestamp)
+	}
+
+	user, err := database.GetUserByEmail("forged@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, user)
+}
@@ -1,11 +1,13 @@
 package tests
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -31,6 +33,9 @@ type MockXMPPClient struct {
 	receivedMessages []MockXMPPMessage
 	messageChannel   chan MockXMPPMessage
 	connected        bool
+	sendErr          error         // Returned by every send when set
+	sendDelay        time.Duration // How long each send takes
+	mu               sync.Mutex
 }
 
 func NewMockXMPPClient() *MockXMPPClient {
@@ -54,18 +59,35 @@ func (m *MockXMPPClient) SendMessage(to, body string) error {
 	if !m.connected {
 		return fmt.Errorf("not connected")
 	}
+	if m.sendErr != nil {
+		return m.sendErr
+	}
+	time.Sleep(m.sendDelay)
 	
 	msg := MockXMPPMessage{
 		From: "admin@server.com",
 		To:   to,
 		Body: body,
 	}
+	m.mu.Lock()
 	m.receivedMessages = append(m.receivedMessages, msg)
+	m.mu.Unlock()
 	return nil
 }
 
+func (m *MockXMPPClient) SendMessageSimple(to, body string) error {
+	return m.SendMessage(to, body)
+}
+
+func (m *MockXMPPClient) Listen(ctx context.Context, messages chan<- xmpp.XMPPMessage, errorChan chan<- error) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
 func (m *MockXMPPClient) GetReceivedMessages() []MockXMPPMessage {
-	return m.receivedMessages
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]MockXMPPMessage(nil), m.receivedMessages...)
 }
 
 func (m *MockXMPPClient) SimulateIncomingMessage(from, to, body string) {
@@ -0,0 +1,175 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ngenohkevin/veilsupport/internal/xmpp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reconnectingXMPP delivers messages only while a Listen call is running,
+// like a real session, and can drop the current session
+type reconnectingXMPP struct {
+	*MockXMPPClient
+
+	mu        sync.Mutex
+	listens   int
+	active    int
+	maxActive int
+	current   chan<- xmpp.XMPPMessage
+	drop      chan struct{}
+}
+
+func (r *reconnectingXMPP) Listen(ctx context.Context, messages chan<- xmpp.XMPPMessage, errorChan chan<- error) error {
+	drop := make(chan struct{})
+	r.mu.Lock()
+	r.listens++
+	r.active++
+	if r.active > r.maxActive {
+		r.maxActive = r.active
+	}
+	r.current = messages
+	r.drop = drop
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		r.active--
+		r.current = nil
+		r.mu.Unlock()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-drop:
+		return errors.New("stream closed")
+	}
+}
+
+// dropSession ends the running Listen as if the connection died
+func (r *reconnectingXMPP) dropSession() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	close(r.drop)
+}
+
+// deliver hands a message to the running listener, reporting false if there is none
+func (r *reconnectingXMPP) deliver(msg xmpp.XMPPMessage) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current == nil {
+		return false
+	}
+	r.current <- msg
+	return true
+}
+
+func (r *reconnectingXMPP) stats() (listens, active, maxActive int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.listens, r.active, r.maxActive
+}
+
+func TestListenerReattachesAfterReconnect(t *testing.T) {
+	fake := &reconnectingXMPP{MockXMPPClient: NewMockXMPPClient()}
+	app, _, chatService := setupChatTestApp(t, fake)
+
+	server := httptest.NewServer(app)
+	defer server.Close()
+	user, token := registerUser(t, app, "listener@example.com", "password123")
+	conn := dialTestWebSocket(t, server, token)
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		chatService.StartXMPPListener(ctx)
+	}()
+	require.Eventually(t, func() bool { _, active, _ := fake.stats(); return active == 1 }, time.Second, 10*time.Millisecond)
+
+	// The session dies: nothing can be received until it is replaced
+	fake.dropSession()
+	require.Eventually(t, func() bool { _, active, _ := fake.stats(); return active == 0 }, time.Second, 10*time.Millisecond)
+	assert.False(t, fake.deliver(xmpp.XMPPMessage{From: "admin@example.com", To: user["xmpp_jid"].(string), Body: "Lost"}))
+
+	// Several reconnect signals in a row still leave a single listener
+	for i := 0; i < 3; i++ {
+		chatService.NotifyReconnected()
+	}
+	require.Eventually(t, func() bool { _, active, _ := fake.stats(); return active == 1 }, time.Second, 10*time.Millisecond)
+
+	require.True(t, fake.deliver(xmpp.XMPPMessage{From: "admin@example.com", To: user["xmpp_jid"].(string), Body: "Back again"}))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var frame map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&frame))
+	assert.Equal(t, "message", frame["type"])
+	assert.Equal(t, "Back again", frame["content"])
+
+	// A reconnect while the old listener is still running replaces it
+	chatService.NotifyReconnected()
+	require.Eventually(t, func() bool { listens, _, _ := fake.stats(); return listens >= 3 }, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-stopped
+	_, active, maxActive := fake.stats()
+	assert.Equal(t, 0, active)
+	assert.Equal(t, 1, maxActive)
+}
+
+// pinged reports whether the listener has pinged over the session
+func (s *fakeSession) pinged() bool {
+	for _, stanza := range s.stanzas() {
+		if strings.Contains(stanza, "urn:xmpp:ping") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestListenerFollowsReconnectAfterDisconnect(t *testing.T) {
+	client := xmpp.NewXMPPClient("bot@example.com", "password", "localhost:5222")
+	client.SetKeepAlive(0)
+	_, _, chatService := setupChatTestApp(t, client)
+
+	var mu sync.Mutex
+	var sessions []*fakeSession
+	connect := func(ctx context.Context) error {
+		session := &fakeSession{}
+		mu.Lock()
+		sessions = append(sessions, session)
+		mu.Unlock()
+		client.SetSession(session)
+		return nil
+	}
+	latest := func() *fakeSession {
+		mu.Lock()
+		defer mu.Unlock()
+		return sessions[len(sessions)-1]
+	}
+
+	// Wired the way the server wires it
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reconnector := xmpp.NewReconnector(connect, client.IsConnected)
+	reconnector.SetCheckInterval(10 * time.Millisecond)
+	reconnector.SetOnConnect(func() { chatService.XMPPConnected(ctx) })
+	require.NoError(t, reconnector.Attempt(ctx))
+	go reconnector.Run(ctx)
+
+	first := latest()
+	require.Eventually(t, first.pinged, 3*time.Second, 10*time.Millisecond)
+
+	// The connection dies; the listener moves to the session that replaces it
+	first.fail(errors.New("broken pipe"))
+	require.Eventually(t, func() bool { return latest() != first && client.IsConnected() }, 3*time.Second, 10*time.Millisecond)
+	require.Eventually(t, latest().pinged, 3*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,288 @@
+package tests
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ngenohkevin/veilsupport/internal/auth"
+	"github.com/ngenohkevin/veilsupport/internal/chat"
+	"github.com/ngenohkevin/veilsupport/internal/db"
+	"github.com/ngenohkevin/veilsupport/internal/handlers"
+	"github.com/ngenohkevin/veilsupport/internal/ws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type loginSessionsResponse struct {
+	Sessions []struct {
+		ID        string `json:"id"`
+		UserAgent string `json:"user_agent"`
+		IP        string `json:"ip"`
+		Current   bool   `json:"current"`
+	} `json:"sessions"`
+}
+
+func setupLoginSessionsTestApp(t *testing.T) (*gin.Engine, *auth.AuthService) {
+	gin.SetMode(gin.TestMode)
+
+	database := setupTestDB(t)
+	authService := auth.NewAuthService(database, "test-secret-key")
+	wsManager := ws.NewManager()
+	h := handlers.NewHandlers(authService, chat.NewChatService(database, nil, wsManager), wsManager)
+
+	r := gin.New()
+	api := r.Group("/api")
+	api.POST("/register", h.Register)
+	api.POST("/login", h.Login)
+	protected := api.Group("/")
+	protected.Use(h.JWTMiddleware())
+	protected.GET("/me/sessions", h.ListMySessions)
+	protected.DELETE("/me/sessions", h.RevokeOtherSessions)
+	protected.DELETE("/me/sessions/:id", h.RevokeMySession)
+
+	return r, authService
+}
+
+// loginFrom logs in with the given User-Agent and returns the token
+func loginFrom(t *testing.T, app *gin.Engine, email, userAgent string) string {
+	req := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"email":"`+email+`","password":"password123"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code, w.Body.String())
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp.Token
+}
+
+func listLoginSessions(t *testing.T, app *gin.Engine, token, userAgent string) loginSessionsResponse {
+	req := httptest.NewRequest("GET", "/api/me/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("User-Agent", userAgent)
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code, w.Body.String())
+
+	var resp loginSessionsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestListLoginSessions(t *testing.T) {
+	app, _ := setupLoginSessionsTestApp(t)
+	registerUser(t, app, "devices@example.com", "password123")
+	registerUser(t, app, "someone@example.com", "password123")
+	loginFrom(t, app, "someone@example.com", "Elsewhere/1.0")
+
+	laptop := loginFrom(t, app, "devices@example.com", "Laptop/1.0")
+	phone := loginFrom(t, app, "devices@example.com", "Phone/2.0")
+	listLoginSessions(t, app, laptop, "Laptop/1.0")
+
+	// Registering and both logins each issued a token; other users' aren't shown
+	resp := listLoginSessions(t, app, phone, "Phone/2.0")
+	require.Len(t, resp.Sessions, 3)
+
+	agents := map[string]bool{}
+	current := 0
+	for _, session := range resp.Sessions {
+		assert.NotEmpty(t, session.ID)
+		agents[session.UserAgent] = true
+		if session.Current {
+			current++
+			assert.Equal(t, "Phone/2.0", session.UserAgent)
+			assert.NotEmpty(t, session.IP)
+		}
+	}
+	assert.Equal(t, 1, current)
+	assert.True(t, agents["Laptop/1.0"])
+	assert.True(t, agents["Phone/2.0"])
+}
+
+func TestListLoginSessionsPaginated(t *testing.T) {
+	app, _ := setupLoginSessionsTestApp(t)
+	registerUser(t, app, "many@example.com", "password123")
+	loginFrom(t, app, "many@example.com", "Laptop/1.0")
+	token := loginFrom(t, app, "many@example.com", "Phone/2.0")
+
+	list := func(query string) (*httptest.ResponseRecorder, loginSessionsResponse) {
+		req := httptest.NewRequest("GET", "/api/me/sessions"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		var resp loginSessionsResponse
+		if w.Code == 200 {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		}
+		return w, resp
+	}
+
+	w, first := list("?limit=2")
+	require.Equal(t, 200, w.Code, w.Body.String())
+	assert.Len(t, first.Sessions, 2)
+	assert.Equal(t, "true", w.Header().Get("X-Page-Has-More"))
+
+	w, rest := list("?limit=2&offset=2")
+	require.Equal(t, 200, w.Code, w.Body.String())
+	require.Len(t, rest.Sessions, 1)
+	assert.Equal(t, "false", w.Header().Get("X-Page-Has-More"))
+	assert.NotEqual(t, first.Sessions[1].ID, rest.Sessions[0].ID)
+
+	w, _ = list("?limit=abc")
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestRevokeLoginSession(t *testing.T) {
+	app, authService := setupLoginSessionsTestApp(t)
+	registerUser(t, app, "revoke@example.com", "password123")
+	_, strangerToken := registerUser(t, app, "stranger@example.com", "password123")
+
+	laptop := loginFrom(t, app, "revoke@example.com", "Laptop/1.0")
+	phone := loginFrom(t, app, "revoke@example.com", "Phone/2.0")
+	laptopClaims, err := authService.ValidateToken(laptop)
+	require.NoError(t, err)
+
+	// Other users can't revoke it
+	w := adminRequest(t, app, "DELETE", "/api/me/sessions/"+laptopClaims.ID, strangerToken, "")
+	assert.Equal(t, 404, w.Code)
+
+	w = adminRequest(t, app, "DELETE", "/api/me/sessions/"+laptopClaims.ID, phone, "")
+	require.Equal(t, 200, w.Code, w.Body.String())
+
+	// Only the revoked token stops working
+	_, err = authService.ValidateToken(laptop)
+	assert.ErrorIs(t, err, auth.ErrTokenRevoked)
+	assert.Equal(t, 401, adminRequest(t, app, "GET", "/api/me/sessions", laptop, "").Code)
+	_, err = authService.ValidateToken(phone)
+	assert.NoError(t, err)
+
+	resp := listLoginSessions(t, app, phone, "Phone/2.0")
+	assert.Len(t, resp.Sessions, 2) // Registration and the phone
+	for _, session := range resp.Sessions {
+		assert.NotEqual(t, laptopClaims.ID, session.ID)
+	}
+
+	// Revoking twice finds nothing
+	w = adminRequest(t, app, "DELETE", "/api/me/sessions/"+laptopClaims.ID, phone, "")
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestRevokeOtherLoginSessions(t *testing.T) {
+	app, authService := setupLoginSessionsTestApp(t)
+	_, registered := registerUser(t, app, "others@example.com", "password123")
+	laptop := loginFrom(t, app, "others@example.com", "Laptop/1.0")
+	phone := loginFrom(t, app, "others@example.com", "Phone/2.0")
+	_, strangerToken := registerUser(t, app, "bystander@example.com", "password123")
+
+	w := adminRequest(t, app, "DELETE", "/api/me/sessions", phone, "")
+	require.Equal(t, 200, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"revoked":2}`, w.Body.String())
+
+	for _, token := range []string{registered, laptop} {
+		_, err := authService.ValidateToken(token)
+		assert.ErrorIs(t, err, auth.ErrTokenRevoked)
+	}
+	_, err := authService.ValidateToken(phone)
+	assert.NoError(t, err)
+	_, err = authService.ValidateToken(strangerToken)
+	assert.NoError(t, err)
+
+	resp := listLoginSessions(t, app, phone, "Phone/2.0")
+	require.Len(t, resp.Sessions, 1)
+	assert.True(t, resp.Sessions[0].Current)
+}
+
+func TestTokensIssuedBeforeTrackingAccepted(t *testing.T) {
+	_, authService := setupLoginSessionsTestApp(t)
+
+	// Signed with the right secret but with no ID, as issued before tracking
+	token, err := auth.NewAuthService(nil, "test-secret-key").GenerateToken(1, "old@example.com")
+	require.NoError(t, err)
+	claims, err := authService.ValidateToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, 1, claims.UserID)
+}
+
+func TestUnrecordedTokensRejected(t *testing.T) {
+	database := setupTestDB(t)
+	authService := auth.NewAuthService(database, "test-secret-key")
+	_, token, err := authService.Register("forgotten@example.com", "password123")
+	require.NoError(t, err)
+
+	_, err = database.GetConn().Exec(context.Background(), `DELETE FROM auth_tokens`)
+	require.NoError(t, err)
+	_, err = authService.ValidateToken(token)
+	assert.ErrorIs(t, err, auth.ErrTokenRevoked)
+}
+
+func TestPasswordResetRevokesTokens(t *testing.T) {
+	database := setupTestDB(t)
+	authService := auth.NewAuthService(database, "test-secret-key")
+	user, registered, err := authService.Register("reset@example.com", "password123")
+	require.NoError(t, err)
+	_, loggedIn, err := authService.Login("reset@example.com", "password123")
+	require.NoError(t, err)
+	_, bystander, err := authService.Register("calm@example.com", "password123")
+	require.NoError(t, err)
+
+	sum := sha256.Sum256([]byte("reset-token"))
+	_, err = database.GetConn().Exec(context.Background(),
+		`INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		user.ID, hex.EncodeToString(sum[:]), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	_, fresh, err := authService.ResetPassword("reset-token", "new-password")
+	require.NoError(t, err)
+
+	// Whoever knew the old password is logged out
+	for _, token := range []string{registered, loggedIn} {
+		_, err := authService.ValidateToken(token)
+		assert.ErrorIs(t, err, auth.ErrTokenRevoked)
+	}
+	_, err = authService.ValidateToken(fresh)
+	assert.NoError(t, err)
+	_, err = authService.ValidateToken(bystander)
+	assert.NoError(t, err)
+}
+
+func TestExpiredTokensArePruned(t *testing.T) {
+	database := setupTestDB(t)
+	authService := auth.NewAuthService(database, "test-secret-key")
+	user, current, err := authService.Register("pruned@example.com", "password123")
+	require.NoError(t, err)
+	require.NoError(t, database.CreateAuthToken("expired", user.ID, -time.Minute))
+
+	pruned, err := authService.PruneExpiredTokens()
+	require.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+
+	expired, err := database.GetAuthToken("expired")
+	require.NoError(t, err)
+	assert.Nil(t, expired)
+	_, err = authService.ValidateToken(current)
+	assert.NoError(t, err)
+}
+
+func TestAuthTokensAreDeletedWithTheirUser(t *testing.T) {
+	database := setupTestDB(t)
+	authService := auth.NewAuthService(database, "test-secret-key")
+	user, _, err := authService.Register("gone@example.com", "password123")
+	require.NoError(t, err)
+
+	_, err = database.GetConn().Exec(context.Background(), `DELETE FROM users WHERE id = $1`, user.ID)
+	require.NoError(t, err)
+
+	tokens, _, err := database.GetActiveAuthTokens(user.ID, db.Page{Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, tokens)
+}
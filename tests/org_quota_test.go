@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ngenohkevin/veilsupport/internal/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// orgUser registers a user belonging to org and returns their token
+func orgUser(t *testing.T, app *gin.Engine, database *db.DB, email, org string) string {
+	user, token := registerUser(t, app, email, "password123")
+	require.NoError(t, database.SetUserMetadata(int(user["id"].(float64)), db.Metadata{"org": org}))
+	return token
+}
+
+// trySend sends a message, returning the response whatever its status
+func trySend(app *gin.Engine, token, message string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/send", strings.NewReader(`{"message":"`+message+`"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	return w
+}
+
+func TestOrgOverQuotaIsBlocked(t *testing.T) {
+	app, database, chatService := setupChatTestApp(t, NewMockXMPPClient())
+	chatService.SetOrgLimits(2, time.Hour, 0)
+
+	acme := orgUser(t, app, database, "wile@acme.example", "acme")
+	acmeAgain := orgUser(t, app, database, "road@acme.example", "acme")
+	globex := orgUser(t, app, database, "hank@globex.example", "globex")
+	_, loner := registerUser(t, app, "loner@example.com", "password123")
+
+	// The quota is shared by everyone in the org
+	assert.Equal(t, 200, trySend(app, acme, "first").Code)
+	assert.Equal(t, 200, trySend(app, acmeAgain, "second").Code)
+
+	w := trySend(app, acme, "third")
+	assert.Equal(t, 429, w.Code)
+	assert.Contains(t, w.Body.String(), "quota exceeded")
+
+	// Other orgs, and users without one, are unaffected
+	assert.Equal(t, 200, trySend(app, globex, "hello").Code)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 200, trySend(app, loner, "hello").Code)
+	}
+
+	// Blocked messages aren't stored or counted
+	usage, err := database.GetOrgUsage("acme")
+	require.NoError(t, err)
+	require.NotNil(t, usage)
+	assert.Equal(t, 2, usage.Messages)
+}
+
+func TestOrgQuotaResetsEachPeriod(t *testing.T) {
+	app, database, chatService := setupChatTestApp(t, NewMockXMPPClient())
+	period := time.Second
+	chatService.SetOrgLimits(1, period, 0)
+
+	token := orgUser(t, app, database, "reset@acme.example", "acme")
+
+	assert.Equal(t, 200, trySend(app, token, "first").Code)
+	assert.Equal(t, 429, trySend(app, token, "too soon").Code)
+
+	time.Sleep(period + 100*time.Millisecond)
+	assert.Equal(t, 200, trySend(app, token, "next period").Code)
+	assert.Equal(t, 429, trySend(app, token, "too soon again").Code)
+
+	usage, err := database.GetOrgUsage("acme")
+	require.NoError(t, err)
+	require.NotNil(t, usage)
+	assert.Equal(t, 1, usage.Messages)
+}
+
+func TestOrgRateLimit(t *testing.T) {
+	app, database, chatService := setupChatTestApp(t, NewMockXMPPClient())
+	chatService.SetOrgLimits(0, 0, 2)
+
+	acme := orgUser(t, app, database, "speedy@acme.example", "acme")
+	globex := orgUser(t, app, database, "steady@globex.example", "globex")
+
+	assert.Equal(t, 200, trySend(app, acme, "one").Code)
+	assert.Equal(t, 200, trySend(app, acme, "two").Code)
+
+	w := trySend(app, acme, "three")
+	assert.Equal(t, 429, w.Code)
+	assert.Contains(t, w.Body.String(), "too fast")
+
+	assert.Equal(t, 200, trySend(app, globex, "one").Code)
+}
+
+func TestOrgCantBeChosenAtRegistration(t *testing.T) {
+	app, database, chatService := setupChatTestApp(t, NewMockXMPPClient())
+	chatService.SetOrgLimits(1, time.Hour, 0)
+
+	victim := orgUser(t, app, database, "owner@acme.example", "acme")
+
+	// Claiming another org's name doesn't make a user part of it
+	req := httptest.NewRequest("POST", "/api/register", strings.NewReader(
+		`{"email":"intruder@example.com","password":"password123","metadata":{"org":"acme"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	require.Equal(t, 201, w.Code, w.Body.String())
+	var registered struct {
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &registered))
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 200, trySend(app, registered.Token, "hello").Code)
+	}
+	usage, err := database.GetOrgUsage("acme")
+	require.NoError(t, err)
+	assert.Nil(t, usage)
+	assert.Equal(t, 200, trySend(app, victim, "still mine").Code)
+}
+
+func TestOrgNameIsBounded(t *testing.T) {
+	app, database, chatService := setupChatTestApp(t, NewMockXMPPClient())
+	chatService.SetOrgLimits(1, time.Hour, 0)
+
+	// Set directly, bypassing the metadata limits, as older rows may be
+	long := strings.Repeat("o", 300)
+	token := orgUser(t, app, database, "long@example.com", long)
+
+	assert.Equal(t, 200, trySend(app, token, "first").Code)
+	assert.Equal(t, 429, trySend(app, token, "second").Code)
+
+	usage, err := database.GetOrgUsage(long[:255])
+	require.NoError(t, err)
+	require.NotNil(t, usage)
+	assert.Equal(t, 1, usage.Messages)
+}
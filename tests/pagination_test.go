@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ngenohkevin/veilsupport/internal/db"
+	"github.com/ngenohkevin/veilsupport/internal/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parsePagination(t *testing.T, query string) (db.Page, error) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/history"+query, nil)
+	return handlers.ParsePagination(c, 20, 100)
+}
+
+func TestParsePaginationDefaults(t *testing.T) {
+	page, err := parsePagination(t, "")
+	require.NoError(t, err)
+	assert.Equal(t, db.Page{Limit: 20, Offset: 0}, page)
+
+	page, err = parsePagination(t, "?offset=40")
+	require.NoError(t, err)
+	assert.Equal(t, db.Page{Limit: 20, Offset: 40}, page)
+
+	page, err = parsePagination(t, "?limit=5&offset=10")
+	require.NoError(t, err)
+	assert.Equal(t, db.Page{Limit: 5, Offset: 10}, page)
+}
+
+func TestParsePaginationClampsToMax(t *testing.T) {
+	page, err := parsePagination(t, "?limit=5000")
+	require.NoError(t, err)
+	assert.Equal(t, 100, page.Limit)
+}
+
+func TestParsePaginationRejectsInvalidValues(t *testing.T) {
+	for _, query := range []string{"?limit=-1", "?limit=0", "?offset=-5", "?limit=ten", "?offset=1.5"} {
+		_, err := parsePagination(t, query)
+		assert.Error(t, err, query)
+	}
+}
+
+func TestHistoryPagination(t *testing.T) {
+	app, database, _ := setupChatTestApp(t, NewMockXMPPClient())
+	user, token := registerUser(t, app, "pages@example.com", "password123")
+	userID := int(user["id"].(float64))
+
+	sendMessage(t, app, token, "one")
+	sendMessage(t, app, token, "two")
+	// Start a second session before the third message
+	session, err := database.GetActiveSession(userID)
+	require.NoError(t, err)
+	_, err = database.ResolveSession(session.ID)
+	require.NoError(t, err)
+	sendMessage(t, app, token, "three")
+	sendMessage(t, app, token, "four")
+
+	get := func(path string) (contents []string, starts []bool, hasMore bool, limit string) {
+		w := adminRequest(t, app, "GET", path, token, "")
+		require.Equal(t, 200, w.Code, w.Body.String())
+		var response struct {
+			Messages []db.HistoryEntry `json:"messages"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		for _, entry := range response.Messages {
+			contents = append(contents, entry.Content)
+			starts = append(starts, entry.SessionStart)
+		}
+		return contents, starts, w.Header().Get("X-Page-Has-More") == "true", w.Header().Get("X-Page-Limit")
+	}
+
+	// The first page is the newest messages, oldest first
+	contents, _, hasMore, limit := get("/api/history?limit=2")
+	assert.Equal(t, []string{"three", "four"}, contents)
+	assert.True(t, hasMore)
+	assert.Equal(t, "2", limit)
+
+	// Offsets count back from the newest message
+	contents, _, hasMore, _ = get("/api/history?limit=2&offset=1")
+	assert.Equal(t, []string{"two", "three"}, contents)
+	assert.True(t, hasMore)
+
+	contents, _, hasMore, _ = get("/api/history?limit=2&offset=2")
+	assert.Equal(t, []string{"one", "two"}, contents)
+	assert.False(t, hasMore)
+
+	// Session boundaries are still right on later pages
+	contents, starts, hasMore, _ := get("/api/history/all?limit=2&offset=1")
+	assert.Equal(t, []string{"two", "three"}, contents)
+	assert.Equal(t, []bool{false, true}, starts)
+	assert.True(t, hasMore)
+
+	contents, starts, _, _ = get("/api/history/all?limit=1")
+	assert.Equal(t, []string{"four"}, contents)
+	assert.Equal(t, []bool{false}, starts)
+
+	contents, starts, hasMore, _ = get("/api/history/all?offset=3")
+	assert.Equal(t, []string{"one"}, contents)
+	assert.Equal(t, []bool{true}, starts)
+	assert.False(t, hasMore)
+
+	contents, starts, hasMore, limit = get("/api/history/all")
+	assert.Equal(t, []string{"one", "two", "three", "four"}, contents)
+	assert.Equal(t, []bool{true, false, true, false}, starts)
+	assert.False(t, hasMore)
+	assert.Equal(t, "50", limit)
+
+	assert.Equal(t, 400, adminRequest(t, app, "GET", "/api/history?limit=-1", token, "").Code)
+	assert.Equal(t, 400, adminRequest(t, app, "GET", "/api/history?limit=0", token, "").Code)
+	assert.Equal(t, 400, adminRequest(t, app, "GET", "/api/history/all?offset=-1", token, "").Code)
+}
@@ -0,0 +1,202 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ngenohkevin/veilsupport/internal/xmpp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDialer fails until it is told to succeed
+type fakeDialer struct {
+	mu        sync.Mutex
+	succeed   bool
+	connected bool
+	calls     int
+}
+
+func (d *fakeDialer) Connect(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls++
+	if !d.succeed {
+		return errors.New("connection refused")
+	}
+	d.connected = true
+	return nil
+}
+
+func (d *fakeDialer) IsConnected() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.connected
+}
+
+func (d *fakeDialer) set(succeed, connected bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.succeed = succeed
+	d.connected = connected
+}
+
+func TestReconnectAlertAfterFailedAttempts(t *testing.T) {
+	dialer := &fakeDialer{}
+	reconnector := xmpp.NewReconnector(dialer.Connect, dialer.IsConnected)
+	reconnector.SetAlertThreshold(3, 0)
+
+	var alerts []xmpp.Outage
+	reconnector.SetAlertHandler(func(outage xmpp.Outage) {
+		alerts = append(alerts, outage)
+	})
+
+	ctx := context.Background()
+
+	// Below the threshold nothing fires
+	assert.Error(t, reconnector.Attempt(ctx))
+	assert.Error(t, reconnector.Attempt(ctx))
+	assert.Empty(t, alerts)
+
+	// The third failure crosses it
+	assert.Error(t, reconnector.Attempt(ctx))
+	require.Len(t, alerts, 1)
+	assert.Equal(t, 3, alerts[0].Attempts)
+	assert.EqualError(t, alerts[0].LastError, "connection refused")
+
+	// Retrying continues without alerting again for the same outage
+	assert.Error(t, reconnector.Attempt(ctx))
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, 4, reconnector.Outage().Attempts)
+
+	// Recovery clears the outage, and the next one alerts afresh
+	dialer.set(true, false)
+	assert.NoError(t, reconnector.Attempt(ctx))
+	assert.Nil(t, reconnector.Outage())
+
+	dialer.set(false, false)
+	for i := 0; i < 3; i++ {
+		reconnector.Attempt(ctx)
+	}
+	assert.Len(t, alerts, 2)
+}
+
+func TestReconnectAlertAfterDuration(t *testing.T) {
+	dialer := &fakeDialer{}
+	reconnector := xmpp.NewReconnector(dialer.Connect, dialer.IsConnected)
+	reconnector.SetAlertThreshold(0, 50*time.Millisecond)
+
+	alerted := 0
+	reconnector.SetAlertHandler(func(xmpp.Outage) { alerted++ })
+
+	ctx := context.Background()
+	reconnector.Attempt(ctx)
+	assert.Equal(t, 0, alerted)
+
+	time.Sleep(60 * time.Millisecond)
+	reconnector.Attempt(ctx)
+	assert.Equal(t, 1, alerted)
+}
+
+func TestReconnectorRunKeepsRetrying(t *testing.T) {
+	dialer := &fakeDialer{}
+	reconnector := xmpp.NewReconnector(dialer.Connect, dialer.IsConnected)
+	reconnector.SetBackoff(time.Millisecond, 5*time.Millisecond)
+	reconnector.SetCheckInterval(time.Millisecond)
+	reconnector.SetAlertThreshold(2, 0)
+
+	alerts := make(chan xmpp.Outage, 10)
+	reconnector.SetAlertHandler(func(outage xmpp.Outage) { alerts <- outage })
+	connected := make(chan struct{}, 10)
+	reconnector.SetOnConnect(func() { connected <- struct{}{} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reconnector.Run(ctx)
+
+	select {
+	case outage := <-alerts:
+		assert.GreaterOrEqual(t, outage.Attempts, 2)
+	case <-time.After(2 * time.Second):
+		t.Fatal("alert did not fire")
+	}
+
+	// The loop is still trying and picks the connection back up
+	dialer.set(true, false)
+	select {
+	case <-connected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("did not reconnect")
+	}
+	assert.True(t, dialer.IsConnected())
+	assert.Nil(t, reconnector.Outage())
+}
+
+func TestReconnectAfterSessionFails(t *testing.T) {
+	client := xmpp.NewXMPPClient("bot@example.com", "password", "localhost:5222")
+	client.SetKeepAlive(0)
+	first := &fakeSession{}
+	client.SetSession(first)
+
+	// Each connection attempt succeeds with a fresh session
+	var mu sync.Mutex
+	var sessions []*fakeSession
+	connect := func(ctx context.Context) error {
+		session := &fakeSession{}
+		mu.Lock()
+		sessions = append(sessions, session)
+		mu.Unlock()
+		client.SetSession(session)
+		return nil
+	}
+	latest := func() *fakeSession {
+		mu.Lock()
+		defer mu.Unlock()
+		return sessions[len(sessions)-1]
+	}
+
+	reconnector := xmpp.NewReconnector(connect, client.IsConnected)
+	reconnector.SetCheckInterval(10 * time.Millisecond)
+	connected := make(chan struct{}, 10)
+	reconnector.SetOnConnect(func() { connected <- struct{}{} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listenErr := make(chan error, 1)
+	go func() { listenErr <- client.Listen(ctx, make(chan xmpp.XMPPMessage), make(chan error)) }()
+	go reconnector.Run(ctx)
+
+	// The server goes away; the listener's next ping notices
+	first.fail(errors.New("broken pipe"))
+	select {
+	case err := <-listenErr:
+		assert.ErrorContains(t, err, "broken pipe")
+	case <-time.After(3 * time.Second):
+		t.Fatal("listener did not notice the dead session")
+	}
+	assert.True(t, first.closed)
+
+	select {
+	case <-connected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("did not reconnect after a failed ping")
+	}
+	require.True(t, client.IsConnected())
+	require.NoError(t, client.SendMessage("admin@example.com", "Back online"))
+	assert.Len(t, latest().stanzas(), 1)
+
+	// A failed send is noticed straight away too
+	second := latest()
+	second.fail(errors.New("stream closed"))
+	assert.Error(t, client.SendMessage("admin@example.com", "Lost"))
+	select {
+	case <-connected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("did not reconnect after a failed send")
+	}
+	assert.NotSame(t, second, latest())
+	require.NoError(t, client.SendMessage("admin@example.com", "Back again"))
+}
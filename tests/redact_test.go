@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/ngenohkevin/veilsupport/internal/redact"
+	"github.com/ngenohkevin/veilsupport/internal/xmpp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLogs collects everything logged while fn runs with the given mode
+func captureLogs(t *testing.T, mode redact.Mode, fn func()) string {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	redact.SetMode(mode)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		redact.SetMode(redact.ModeRedact)
+	}()
+
+	fn()
+	return buf.String()
+}
+
+func TestRedactModes(t *testing.T) {
+	body := "My card number is 4111 1111 1111 1111"
+
+	captureLogs(t, redact.ModeRedact, func() {
+		assert.Equal(t, "[redacted, 37 chars]", redact.Body(body))
+	})
+	captureLogs(t, redact.ModeTruncate, func() {
+		assert.Equal(t, "My card number is 41… (37 chars)", redact.Body(body))
+		assert.Equal(t, "Short", redact.Body("Short"))
+	})
+	captureLogs(t, redact.ModeFull, func() {
+		assert.Equal(t, body, redact.Body(body))
+	})
+
+	mode, err := redact.ParseMode("")
+	require.NoError(t, err)
+	assert.Equal(t, redact.ModeRedact, mode)
+	mode, err = redact.ParseMode(" Truncate ")
+	require.NoError(t, err)
+	assert.Equal(t, redact.ModeTruncate, mode)
+	_, err = redact.ParseMode("verbose")
+	assert.Error(t, err)
+}
+
+func TestSentMessageBodiesAreRedactedInLogs(t *testing.T) {
+	session := &fakeSession{}
+	client := xmpp.NewXMPPClient("bot@example.com", "password", "localhost:5222")
+	client.SetSession(session)
+	secret := "my password is hunter2"
+
+	logs := captureLogs(t, redact.ModeRedact, func() {
+		require.NoError(t, client.SendMessage("admin@example.com", secret))
+	})
+	assert.Contains(t, logs, "admin@example.com")
+	assert.NotContains(t, logs, "hunter2")
+	assert.Contains(t, logs, "[redacted, 22 chars]")
+
+	logs = captureLogs(t, redact.ModeFull, func() {
+		require.NoError(t, client.SendMessage("admin@example.com", secret))
+	})
+	assert.Contains(t, logs, secret)
+}
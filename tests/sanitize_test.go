@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ngenohkevin/veilsupport/internal/sanitize"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeEscapeNeutralizesMarkup(t *testing.T) {
+	input := `Hi <script>alert("xss")</script><img src=x onerror=alert(1)>`
+
+	output := sanitize.Content(sanitize.ModeEscape, input)
+
+	assert.Equal(t, `Hi &lt;script&gt;alert("xss")&lt;/script&gt;&lt;img src=x onerror=alert(1)&gt;`, output)
+	assert.NotContains(t, output, "<")
+}
+
+func TestSanitizeStripRemovesMarkup(t *testing.T) {
+	cases := map[string]string{
+		`Hi <script>alert("xss")</script>there`:             "Hi there",
+		`<b>bold</b> and <a href="javascript:x()">link</a>`: "bold and link",
+		`<img src=x onerror=alert(1)>`:                      "",
+		`<STYLE>body{display:none}</STYLE>visible`:          "visible",
+		`before<!-- <script>x</script> -->after`:            "beforeafter",
+		`<iframe src="https://evil.example"></iframe>ok`:    "ok",
+	}
+
+	for input, expected := range cases {
+		assert.Equal(t, expected, sanitize.Content(sanitize.ModeStrip, input), input)
+	}
+
+	// An unterminated tag can't survive as markup
+	assert.Equal(t, "x &lt;script", sanitize.Content(sanitize.ModeStrip, "x <script"))
+	// ...and can't be confused with an entity the user typed
+	assert.Equal(t, "&amp;lt;script", sanitize.Content(sanitize.ModeStrip, "&lt;script"))
+}
+
+func TestSanitizeLeavesPlainTextUntouched(t *testing.T) {
+	plain := []string{
+		"Hello, it's fine, thanks!",
+		"My order #123 hasn't arrived \"yet\"",
+		"Ünïcödé 👍 text",
+	}
+
+	for _, text := range plain {
+		assert.Equal(t, text, sanitize.Content(sanitize.ModeEscape, text))
+		assert.Equal(t, text, sanitize.Content(sanitize.ModeStrip, text))
+		assert.Equal(t, text, sanitize.Content(sanitize.ModeOff, text))
+	}
+
+	// Comparisons aren't tags when stripping
+	assert.Equal(t, "1 < 2 and 3 > 2", sanitize.Content(sanitize.ModeStrip, "1 < 2 and 3 > 2"))
+	
+	// Ampersands are left alone only when off
+	assert.Equal(t, "fine &amp; thanks", sanitize.Content(sanitize.ModeEscape, "fine & thanks"))
+	assert.Equal(t, "fine &amp; thanks", sanitize.Content(sanitize.ModeStrip, "fine & thanks"))
+	assert.Equal(t, "fine & thanks", sanitize.Content(sanitize.ModeOff, "fine & thanks"))
+
+	// Off stores markup as-is
+	assert.Equal(t, "<b>hi</b>", sanitize.Content(sanitize.ModeOff, "<b>hi</b>"))
+}
+
+func TestParseSanitizeMode(t *testing.T) {
+	for value, expected := range map[string]sanitize.Mode{
+		"":        sanitize.ModeOff,
+		"off":     sanitize.ModeOff,
+		"escape":  sanitize.ModeEscape,
+		" STRIP ": sanitize.ModeStrip,
+	} {
+		mode, err := sanitize.ParseMode(value)
+		require.NoError(t, err)
+		assert.Equal(t, expected, mode)
+	}
+
+	_, err := sanitize.ParseMode("scrub")
+	assert.Error(t, err)
+}
+
+func TestStoredMessagesAreSanitized(t *testing.T) {
+	app, database, chatService := setupChatTestApp(t, NewMockXMPPClient())
+	chatService.SetSanitizeMode(sanitize.ModeStrip)
+
+	user, token := registerUser(t, app, "sanitize@example.com", "password123")
+	userID := int(user["id"].(float64))
+
+	sendMessage(t, app, token, `Help <script>document.location='https://evil.example'</script>please`)
+
+	messages, err := database.GetUserMessages(userID)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "Help please", messages[0].Content)
+
+	// Nothing left after stripping is rejected
+	req := httptest.NewRequest("POST", "/api/send", strings.NewReader(`{"message":"<script>alert(1)</script>"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestNormalizeTidiesWhitespace(t *testing.T) {
+	cases := map[string]string{
+		"  Hello  \r\n\n\n\nworld\t\n\n": "Hello\n\nworld",
+		"one\r\ntwo\rthree":              "one\ntwo\nthree",
+		"para one\n  \n\t\n\npara two":   "para one\n\npara two",
+		"keep\n\nsingle blank lines":     "keep\n\nsingle blank lines",
+		"code:\n    indented  \n":        "code:\n    indented",
+		" \n\t\n ":                       "",
+	}
+
+	for input, expected := range cases {
+		assert.Equal(t, expected, sanitize.Normalize(input), input)
+	}
+}
+
+func TestStoredMessagesAreNormalizedWhenEnabled(t *testing.T) {
+	app, database, chatService := setupChatTestApp(t, NewMockXMPPClient())
+
+	user, token := registerUser(t, app, "normalize@example.com", "password123")
+	userID := int(user["id"].(float64))
+
+	// Off by default: whitespace is stored as sent
+	sendMessage(t, app, token, `  first  \n\n\n\nline  `)
+
+	chatService.SetNormalize(true)
+	sendMessage(t, app, token, `  second  \r\n\n\n\nline  `)
+
+	messages, err := database.GetUserMessages(userID)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "  first  \n\n\n\nline  ", messages[0].Content)
+	assert.Equal(t, "second\n\nline", messages[1].Content)
+}
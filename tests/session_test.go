@@ -0,0 +1,340 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ngenohkevin/veilsupport/internal/xmpp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"mellium.im/xmlstream"
+	mellium "mellium.im/xmpp"
+)
+
+// fakeSession records every stanza sent instead of writing to a stream
+type fakeSession struct {
+	mu         sync.Mutex
+	sent       []string
+	sendErr    error
+	closed     bool
+	keepAlives int      // Whitespace written between stanzas
+	inbound    []string // Stanzas handed to Serve's handler, in order
+}
+
+func (s *fakeSession) Send(ctx context.Context, r xml.TokenReader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sendErr != nil {
+		return s.sendErr
+	}
+
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	if _, err := xmlstream.Copy(enc, r); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	s.sent = append(s.sent, buf.String())
+	return nil
+}
+
+// fail makes every later send return err, as a dead connection would
+func (s *fakeSession) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendErr = err
+}
+
+func (s *fakeSession) Serve(h mellium.Handler) error {
+	for _, raw := range s.inbound {
+		d := xml.NewDecoder(strings.NewReader(raw))
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		start := tok.(xml.StartElement)
+		rw := struct {
+			xml.TokenReader
+			xmlstream.Encoder
+		}{d, xml.NewEncoder(io.Discard)}
+		if err := h.HandleXMPP(rw, &start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeSession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// TokenWriter counts the whitespace keep-alives written between stanzas
+func (s *fakeSession) TokenWriter() xmlstream.TokenWriteFlushCloser {
+	return &fakeTokenWriter{session: s}
+}
+
+func (s *fakeSession) keepAliveCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keepAlives
+}
+
+type fakeTokenWriter struct {
+	session *fakeSession
+}
+
+func (w *fakeTokenWriter) EncodeToken(t xml.Token) error {
+	if data, ok := t.(xml.CharData); ok && strings.TrimSpace(string(data)) == "" {
+		w.session.mu.Lock()
+		w.session.keepAlives++
+		w.session.mu.Unlock()
+	}
+	return nil
+}
+
+func (w *fakeTokenWriter) Flush() error { return nil }
+func (w *fakeTokenWriter) Close() error { return nil }
+
+func (s *fakeSession) stanzas() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.sent...)
+}
+
+func TestXMPPClientSendMessageWithFakeSession(t *testing.T) {
+	session := &fakeSession{}
+	client := xmpp.NewXMPPClient("bot@example.com", "password", "localhost:5222")
+	client.SetSession(session)
+	require.True(t, client.IsConnected())
+
+	require.NoError(t, client.SendMessage("admin@example.com", "Hello <admin> & co"))
+
+	sent := session.stanzas()
+	require.Len(t, sent, 1)
+	assert.Contains(t, sent[0], `to="admin@example.com"`)
+	assert.Contains(t, sent[0], `type="chat"`)
+	assert.Contains(t, sent[0], "<body>Hello &lt;admin&gt; &amp; co</body>")
+
+	require.NoError(t, client.Close())
+	assert.True(t, session.closed)
+	assert.False(t, client.IsConnected())
+}
+
+func TestXMPPClientSendMessageErrors(t *testing.T) {
+	session := &fakeSession{}
+	client := xmpp.NewXMPPClient("bot@example.com", "password", "localhost:5222")
+
+	// Not connected yet
+	assert.Error(t, client.SendMessage("admin@example.com", "hi"))
+
+	client.SetSession(session)
+	assert.Error(t, client.SendMessage("", "hi"))
+	assert.Error(t, client.SendMessage("admin@example.com", ""))
+	assert.Error(t, client.SendMessage("not a jid@", "hi"))
+	assert.Empty(t, session.stanzas())
+
+	session.sendErr = errors.New("stream closed")
+	err := client.SendMessage("admin@example.com", "hi")
+	require.Error(t, err)
+	assert.ErrorIs(t, err, session.sendErr)
+}
+
+func TestGatewaySendUserMessageWithFakeSession(t *testing.T) {
+	session := &fakeSession{}
+	gateway := xmpp.NewGatewayClient("bot@example.com", "password", "localhost:5222",
+		[]string{"agent1@example.com", "agent2@example.com"})
+
+	// Unknown users are rejected before anything is sent
+	gateway.SetSession(session)
+	assert.Error(t, gateway.SendUserMessage(101, "hi", nil))
+
+	gateway.RegisterUser(101, "john@example.com", "John")
+	require.NoError(t, gateway.SendUserMessage(101, "Where is my order?", []string{"https://files.example.com/receipt.pdf"}))
+
+	sent := session.stanzas()
+	require.Len(t, sent, 2)
+	assert.Contains(t, sent[0], `to="agent1@example.com"`)
+	assert.Contains(t, sent[1], `to="agent2@example.com"`)
+	for _, stanza := range sent {
+		assert.Contains(t, stanza, "John &lt;john@example.com&gt;")
+		assert.Contains(t, stanza, "User ID: 101")
+		assert.Contains(t, stanza, "Where is my order?")
+		assert.Contains(t, stanza, "Attachments: 1 file(s)")
+		assert.Contains(t, stanza, "https://files.example.com/receipt.pdf")
+	}
+}
+
+func TestBotSendUserMessageWithFakeSession(t *testing.T) {
+	session := &fakeSession{}
+	bot := newTestBot()
+
+	assert.Error(t, bot.SendUserMessage(101, "john@example.com", "John", "hi"))
+
+	bot.SetSession(session)
+	require.NoError(t, bot.SendUserMessage(101, "john@example.com", "John", "Where is my order?"))
+	require.NoError(t, bot.SendUserMessage(101, "john@example.com", "John", "Any update?"))
+
+	sent := session.stanzas()
+	require.Len(t, sent, 2)
+	assert.Contains(t, sent[0], `to="admin@example.com"`)
+	assert.Contains(t, sent[0], "User ID: 101")
+	assert.Contains(t, sent[0], "Message #1")
+	assert.Contains(t, sent[0], "Where is my order?")
+	assert.Contains(t, sent[1], "Message #2")
+
+	session.sendErr = errors.New("stream closed")
+	assert.Error(t, bot.SendUserMessage(101, "john@example.com", "John", "hello?"))
+}
+
+// presenceTypes lists the presence stanzas sent, "available" or "unavailable"
+func presenceTypes(session *fakeSession) []string {
+	var types []string
+	for _, sent := range session.stanzas() {
+		if !strings.HasPrefix(sent, "<presence") {
+			continue
+		}
+		if strings.Contains(sent, `type="unavailable"`) {
+			types = append(types, "unavailable")
+		} else {
+			types = append(types, "available")
+		}
+	}
+	return types
+}
+
+func TestGatewayPresenceFlapsAreSuppressed(t *testing.T) {
+	session := &fakeSession{}
+	gateway := xmpp.NewGatewayClient("bot@example.com", "password", "localhost:5222", []string{"admin@example.com"})
+	gateway.SetSession(session)
+	gateway.SetPresenceGrace(100 * time.Millisecond)
+	gateway.RegisterUser(1, "flaky@example.com", "Flaky")
+
+	require.NoError(t, gateway.SetUserOnline(1, true))
+	assert.Equal(t, []string{"available"}, presenceTypes(session))
+
+	// Reconnecting within the grace period is invisible to admins
+	for i := 0; i < 10; i++ {
+		require.NoError(t, gateway.SetUserOnline(1, false))
+		require.NoError(t, gateway.SetUserOnline(1, true))
+	}
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, []string{"available"}, presenceTypes(session))
+
+	// Flapping that ends offline is reported once, after the grace period
+	for i := 0; i < 10; i++ {
+		require.NoError(t, gateway.SetUserOnline(1, true))
+		require.NoError(t, gateway.SetUserOnline(1, false))
+	}
+	assert.Equal(t, []string{"available"}, presenceTypes(session))
+	require.Eventually(t, func() bool { return len(presenceTypes(session)) == 2 }, time.Second, 10*time.Millisecond)
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, []string{"available", "unavailable"}, presenceTypes(session))
+}
+
+func TestGatewayPresenceWithoutGrace(t *testing.T) {
+	session := &fakeSession{}
+	gateway := xmpp.NewGatewayClient("bot@example.com", "password", "localhost:5222", []string{"admin@example.com"})
+	gateway.SetSession(session)
+	gateway.SetPresenceGrace(0)
+	gateway.RegisterUser(1, "steady@example.com", "Steady")
+
+	require.NoError(t, gateway.SetUserOnline(1, true))
+	require.NoError(t, gateway.SetUserOnline(1, true))
+	require.NoError(t, gateway.SetUserOnline(1, false))
+	require.NoError(t, gateway.SetUserOnline(1, false))
+
+	// Only real transitions are sent
+	assert.Equal(t, []string{"available", "unavailable"}, presenceTypes(session))
+	assert.Error(t, gateway.SetUserOnline(2, true))
+}
+
+// listenFor runs the client's listener for the given time
+func listenFor(t *testing.T, client *xmpp.XMPPClient, d time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	err := client.Listen(ctx, make(chan xmpp.XMPPMessage), make(chan error))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestKeepAliveFiresWhileIdle(t *testing.T) {
+	session := &fakeSession{}
+	client := xmpp.NewXMPPClient("bot@example.com", "password", "localhost:5222")
+	client.SetKeepAlive(50 * time.Millisecond)
+	client.SetSession(session)
+
+	listenFor(t, client, 275*time.Millisecond)
+
+	// One per interval: at 50, 100, 150, 200 and 250ms
+	assert.InDelta(t, 5, session.keepAliveCount(), 1)
+	// Keep-alives aren't stanzas
+	assert.Empty(t, session.stanzas())
+}
+
+func TestKeepAliveWaitsWhileBusy(t *testing.T) {
+	session := &fakeSession{}
+	client := xmpp.NewXMPPClient("bot@example.com", "password", "localhost:5222")
+	client.SetKeepAlive(100 * time.Millisecond)
+	client.SetSession(session)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 12; i++ {
+			assert.NoError(t, client.SendMessage("admin@example.com", "still here"))
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	listenFor(t, client, 230*time.Millisecond)
+	<-done
+
+	assert.Zero(t, session.keepAliveCount())
+}
+
+func TestKeepAliveDisabled(t *testing.T) {
+	session := &fakeSession{}
+	client := xmpp.NewXMPPClient("bot@example.com", "password", "localhost:5222")
+	client.SetKeepAlive(0)
+	client.SetSession(session)
+
+	listenFor(t, client, 150*time.Millisecond)
+	assert.Zero(t, session.keepAliveCount())
+}
+
+func TestListenDeliversIncomingMessages(t *testing.T) {
+	session := &fakeSession{inbound: []string{
+		`<iq xmlns="jabber:client" type="result" id="ping1"/>`,
+		`<message xmlns="jabber:client" from="agent@example.com" to="user_alice_1@xmpp.jp" type="error"><body>bounced</body></message>`,
+		`<message xmlns="jabber:client" from="agent@example.com" to="user_alice_1@xmpp.jp" type="chat"><composing xmlns="http://jabber.org/protocol/chatstates"/></message>`,
+		`<message xmlns="jabber:client" from="agent@example.com/desk" to="user_alice_1@xmpp.jp" type="chat">` +
+			`<body>Here is the invoice</body>` +
+			`<x xmlns="jabber:x:oob"><url>https://upload.example.com/abc/invoice.pdf</url></x></message>`,
+	}}
+	client := xmpp.NewXMPPClient("bot@example.com", "password", "localhost:5222")
+	client.SetSession(session)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	messages := make(chan xmpp.XMPPMessage, 10)
+	err := client.Listen(ctx, messages, make(chan error))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Only the message with content comes through, parsed with its attachment
+	require.Len(t, messages, 1)
+	msg := <-messages
+	assert.Equal(t, "agent@example.com/desk", msg.From)
+	assert.Equal(t, "user_alice_1@xmpp.jp", msg.To)
+	assert.Equal(t, "Here is the invoice", msg.Body)
+	assert.Equal(t, []string{"https://upload.example.com/abc/invoice.pdf"}, msg.Attachments)
+}
@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ngenohkevin/veilsupport/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a tracer provider that keeps spans in memory until
+// the test ends
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return exporter
+}
+
+func TestSendMessageSpanHierarchy(t *testing.T) {
+	mockXMPP := NewMockXMPPClient()
+	mockXMPP.Connect()
+	app, _, chatService := setupChatTestApp(t, mockXMPP)
+	chatService.SetAdminJID("admin@example.com")
+	_, token := registerUser(t, app, "traced@example.com", "password123")
+
+	exporter := recordSpans(t)
+
+	// Continue a trace started by the caller
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("POST", "/api/send", strings.NewReader(`{"message":"Trace me"}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	require.Equal(t, 200, w.Code)
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	server, ok := spans["POST /api/send"]
+	require.True(t, ok, "missing server span")
+	send, ok := spans["chat.SendMessage"]
+	require.True(t, ok, "missing chat span")
+	save, ok := spans["db.SaveMessage"]
+	require.True(t, ok, "missing database span")
+	bridge, ok := spans["xmpp.SendMessage"]
+	require.True(t, ok, "missing XMPP span")
+
+	// handler → chat service → database write and XMPP send, all in one trace
+	assert.Equal(t, traceID, server.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+	assert.Equal(t, server.SpanContext.SpanID(), send.Parent.SpanID())
+	assert.Equal(t, send.SpanContext.SpanID(), save.Parent.SpanID())
+	assert.Equal(t, send.SpanContext.SpanID(), bridge.Parent.SpanID())
+	for _, span := range []tracetest.SpanStub{send, save, bridge} {
+		assert.Equal(t, traceID, span.SpanContext.TraceID().String(), span.Name)
+	}
+}
+
+func TestSendMessageSpanRecordsFailure(t *testing.T) {
+	app, _, _ := setupChatTestApp(t, NewMockXMPPClient())
+	_, token := registerUser(t, app, "untraced@example.com", "password123")
+
+	exporter := recordSpans(t)
+
+	req := httptest.NewRequest("POST", "/api/send", strings.NewReader(`{"message":"   "}`))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	require.Equal(t, 400, w.Code)
+
+	var found bool
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "chat.SendMessage" {
+			found = true
+			assert.Equal(t, codes.Error, span.Status.Code)
+			assert.Equal(t, "message is empty", span.Status.Description)
+		}
+		// Nothing was written or bridged
+		assert.NotEqual(t, "db.SaveMessage", span.Name)
+		assert.NotEqual(t, "xmpp.SendMessage", span.Name)
+	}
+	assert.True(t, found)
+}
+
+func TestParseTracingExporter(t *testing.T) {
+	for value, expected := range map[string]tracing.Exporter{
+		"":       tracing.ExporterNone,
+		"none":   tracing.ExporterNone,
+		" OTLP ": tracing.ExporterOTLP,
+		"stdout": tracing.ExporterStdout,
+	} {
+		exporter, err := tracing.ParseExporter(value)
+		require.NoError(t, err)
+		assert.Equal(t, expected, exporter)
+	}
+
+	_, err := tracing.ParseExporter("jaeger")
+	assert.Error(t, err)
+
+	// The default installs nothing
+	previous := otel.GetTracerProvider()
+	shutdown, err := tracing.Setup(context.Background(), tracing.ExporterNone)
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+	assert.Equal(t, previous, otel.GetTracerProvider())
+
+	// Any other exporter replaces the global provider
+	propagator := otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		otel.SetTextMapPropagator(propagator)
+	})
+	shutdown, err = tracing.Setup(context.Background(), tracing.ExporterStdout)
+	require.NoError(t, err)
+	assert.NotEqual(t, previous, otel.GetTracerProvider())
+	assert.NoError(t, shutdown(context.Background()))
+}
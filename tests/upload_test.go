@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ngenohkevin/veilsupport/internal/xmpp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"mellium.im/xmpp/upload"
+)
+
+// fakeSlots hands out slots for an upload server, taking delays[n] to answer
+// the nth request (repeating the last)
+type fakeSlots struct {
+	server   *httptest.Server
+	delays   []time.Duration
+	requests atomic.Int32
+}
+
+func (f *fakeSlots) RequestSlot(ctx context.Context, file upload.File) (upload.Slot, error) {
+	n := int(f.requests.Add(1))
+	delay := f.delays[len(f.delays)-1]
+	if n <= len(f.delays) {
+		delay = f.delays[n-1]
+	}
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return upload.Slot{}, ctx.Err()
+	}
+
+	putURL, _ := url.Parse(f.server.URL + "/put/" + file.Name)
+	getURL, _ := url.Parse(f.server.URL + "/get/" + file.Name)
+	return upload.Slot{
+		PutURL: putURL,
+		GetURL: getURL,
+		Header: http.Header{"Authorization": {"Bearer slot-token"}},
+	}, nil
+}
+
+// uploadServer answers PUTs with the given statuses in turn and keeps the
+// last body it accepted
+func uploadServer(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32, *atomic.Value) {
+	var puts atomic.Int32
+	var stored atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(puts.Add(1))
+		status := statuses[len(statuses)-1]
+		if n <= len(statuses) {
+			status = statuses[n-1]
+		}
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "Bearer slot-token", r.Header.Get("Authorization"))
+
+		body, _ := io.ReadAll(r.Body)
+		if status < 300 {
+			stored.Store(string(body))
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &puts, &stored
+}
+
+func newTestUploader(slots xmpp.SlotRequester) *xmpp.Uploader {
+	uploader := xmpp.NewUploader(slots)
+	uploader.SetOptions(xmpp.UploadOptions{
+		SlotTimeout: 100 * time.Millisecond,
+		PutTimeout:  time.Second,
+		Attempts:    3,
+		Backoff:     10 * time.Millisecond,
+	})
+	return uploader
+}
+
+func TestUploadRetriesSlowSlotRequest(t *testing.T) {
+	server, puts, stored := uploadServer(t, http.StatusCreated)
+	// The first request outlasts the slot timeout; the second is quick
+	slots := &fakeSlots{server: server, delays: []time.Duration{time.Second, 0}}
+
+	start := time.Now()
+	getURL, err := newTestUploader(slots).Upload(context.Background(), "log.txt", "text/plain", []byte("hello"))
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+
+	assert.Equal(t, server.URL+"/get/log.txt", getURL)
+	assert.EqualValues(t, 2, slots.requests.Load())
+	assert.EqualValues(t, 1, puts.Load())
+	assert.Equal(t, "hello", stored.Load())
+}
+
+func TestUploadGivesUpOnSlotTimeouts(t *testing.T) {
+	server, puts, _ := uploadServer(t, http.StatusCreated)
+	slots := &fakeSlots{server: server, delays: []time.Duration{time.Second}}
+
+	_, err := newTestUploader(slots).Upload(context.Background(), "log.txt", "text/plain", []byte("hello"))
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.EqualValues(t, 3, slots.requests.Load())
+	assert.Zero(t, puts.Load())
+}
+
+func TestUploadRetriesFailedPut(t *testing.T) {
+	server, puts, stored := uploadServer(t, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusCreated)
+	slots := &fakeSlots{server: server, delays: []time.Duration{0}}
+
+	getURL, err := newTestUploader(slots).Upload(context.Background(), "photo.jpg", "image/jpeg", []byte("jpeg"))
+	require.NoError(t, err)
+	assert.Equal(t, server.URL+"/get/photo.jpg", getURL)
+
+	// The slot is reused for every PUT
+	assert.EqualValues(t, 1, slots.requests.Load())
+	assert.EqualValues(t, 3, puts.Load())
+	assert.Equal(t, "jpeg", stored.Load())
+}
+
+func TestUploadDoesNotRetryRejectedPut(t *testing.T) {
+	server, puts, _ := uploadServer(t, http.StatusRequestEntityTooLarge)
+	slots := &fakeSlots{server: server, delays: []time.Duration{0}}
+
+	_, err := newTestUploader(slots).Upload(context.Background(), "huge.bin", "", []byte("data"))
+	assert.Error(t, err)
+	assert.EqualValues(t, 1, puts.Load())
+}
+
+func TestUploadWithoutServiceConfigured(t *testing.T) {
+	client := xmpp.NewXMPPClient("bot@example.com", "password", "example.com")
+	_, err := client.Upload(context.Background(), "log.txt", "text/plain", []byte("hello"))
+	assert.Error(t, err)
+}
+
+// postAttachment uploads a file through POST /api/attachments
+func postAttachment(t *testing.T, app *gin.Engine, token, name string, data []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", name)
+	require.NoError(t, err)
+	_, err = part.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	req := httptest.NewRequest("POST", "/api/attachments", &body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	return w
+}
+
+func TestUserAttachmentIsUploadedAndSent(t *testing.T) {
+	mockXMPP := NewMockXMPPClient()
+	mockXMPP.Connect()
+	app, database, chatService := setupChatTestApp(t, mockXMPP)
+	chatService.SetAdminJID("admin@example.com")
+	user, token := registerUser(t, app, "sharer@example.com", "password123")
+	userID := int(user["id"].(float64))
+
+	// Unavailable until an uploader is set
+	assert.Equal(t, 404, postAttachment(t, app, token, "receipt.pdf", []byte("pdf")).Code)
+
+	server, _, stored := uploadServer(t, http.StatusCreated)
+	chatService.SetFileUploader(newTestUploader(&fakeSlots{server: server, delays: []time.Duration{0}}))
+
+	w := postAttachment(t, app, token, "receipt.pdf", []byte("pdf"))
+	require.Equal(t, 200, w.Code, w.Body.String())
+	assert.Equal(t, "pdf", stored.Load())
+	getURL := server.URL + "/get/receipt.pdf"
+
+	// The download URL is the message, with the file as its attachment
+	messages, err := database.GetUserMessages(userID)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, getURL, messages[0].Content)
+	attachments, err := database.GetMessageAttachments(messages[0].ID)
+	require.NoError(t, err)
+	require.Len(t, attachments, 1)
+	assert.Equal(t, getURL, attachments[0].URL)
+
+	received := mockXMPP.GetReceivedMessages()
+	require.Len(t, received, 1)
+	assert.Contains(t, received[0].Body, getURL)
+}
+
+func TestUserAttachmentUploadFailure(t *testing.T) {
+	app, database, chatService := setupChatTestApp(t, NewMockXMPPClient())
+	user, token := registerUser(t, app, "rejected@example.com", "password123")
+
+	server, _, _ := uploadServer(t, http.StatusForbidden)
+	chatService.SetFileUploader(newTestUploader(&fakeSlots{server: server, delays: []time.Duration{0}}))
+
+	assert.Equal(t, 503, postAttachment(t, app, token, "secret.txt", []byte("data")).Code)
+
+	// Nothing is saved for a file that never arrived
+	messages, err := database.GetUserMessages(int(user["id"].(float64)))
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
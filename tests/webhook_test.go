@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ngenohkevin/veilsupport/internal/webhook"
+	"github.com/ngenohkevin/veilsupport/internal/xmpp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookRecorder is a webhook endpoint that answers with the given statuses
+// in turn (repeating the last) and keeps every body it receives
+type webhookRecorder struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	calls    atomic.Int32
+}
+
+func (r *webhookRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	call := int(r.calls.Add(1))
+
+	r.mu.Lock()
+	r.bodies = append(r.bodies, body)
+	status := r.statuses[len(r.statuses)-1]
+	if call <= len(r.statuses) {
+		status = r.statuses[call-1]
+	}
+	r.mu.Unlock()
+
+	w.WriteHeader(status)
+}
+
+func (r *webhookRecorder) lastBody() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.bodies[len(r.bodies)-1]
+}
+
+func TestWebhookRetriesUntilAccepted(t *testing.T) {
+	recorder := &webhookRecorder{statuses: []int{500, 503, 204}}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	client := webhook.New(server.URL)
+	client.SetRetries(3, 10*time.Millisecond)
+
+	require.NoError(t, client.Send(context.Background(), "test", map[string]int{"n": 1}))
+	assert.EqualValues(t, 3, recorder.calls.Load())
+
+	var event struct {
+		Type string         `json:"type"`
+		Data map[string]int `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.lastBody(), &event))
+	assert.Equal(t, "test", event.Type)
+	assert.Equal(t, 1, event.Data["n"])
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	recorder := &webhookRecorder{statuses: []int{500}}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	client := webhook.New(server.URL)
+	client.SetRetries(2, 10*time.Millisecond)
+	assert.Error(t, client.Send(context.Background(), "test", nil))
+	assert.EqualValues(t, 2, recorder.calls.Load())
+
+	// Client errors won't get better by retrying
+	rejecting := &webhookRecorder{statuses: []int{400}}
+	rejectServer := httptest.NewServer(rejecting)
+	defer rejectServer.Close()
+
+	client = webhook.New(rejectServer.URL)
+	client.SetRetries(5, 10*time.Millisecond)
+	assert.Error(t, client.Send(context.Background(), "test", nil))
+	assert.EqualValues(t, 1, rejecting.calls.Load())
+}
+
+func TestAdminReplyWebhookPayload(t *testing.T) {
+	recorder := &webhookRecorder{statuses: []int{200}}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	app, _, chatService := setupChatTestApp(t, NewMockXMPPClient())
+	client := webhook.New(server.URL)
+	chatService.SetReplyWebhook(client)
+
+	user, _ := registerUser(t, app, "crm@example.com", "password123")
+	err := chatService.HandleAdminReply(xmpp.XMPPMessage{
+		From:        "agent@example.com",
+		To:          user["xmpp_jid"].(string),
+		Body:        "Your refund is on its way",
+		Attachments: []string{"https://upload.example.com/refund.pdf"},
+	})
+	require.NoError(t, err)
+	client.Wait()
+
+	require.EqualValues(t, 1, recorder.calls.Load())
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			UserID    int    `json:"user_id"`
+			UserEmail string `json:"user_email"`
+			Message   struct {
+				ID         int    `json:"id"`
+				Content    string `json:"content"`
+				SenderType string `json:"sender_type"`
+				Seq        int    `json:"seq"`
+			} `json:"message"`
+			Attachments []string `json:"attachments"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(recorder.lastBody(), &event))
+	assert.Equal(t, "admin_reply", event.Type)
+	assert.Equal(t, int(user["id"].(float64)), event.Data.UserID)
+	assert.Equal(t, "crm@example.com", event.Data.UserEmail)
+	assert.NotZero(t, event.Data.Message.ID)
+	assert.Equal(t, "Your refund is on its way", event.Data.Message.Content)
+	assert.Equal(t, "admin", event.Data.Message.SenderType)
+	assert.Equal(t, 1, event.Data.Message.Seq)
+	assert.Equal(t, []string{"https://upload.example.com/refund.pdf"}, event.Data.Attachments)
+}
+
+func TestAdminReplyWebhookFailureDoesNotBlockDelivery(t *testing.T) {
+	// An endpoint that is both slow and failing
+	recorder := &webhookRecorder{statuses: []int{500}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		recorder.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	app, database, chatService := setupChatTestApp(t, NewMockXMPPClient())
+	client := webhook.New(server.URL)
+	client.SetRetries(3, 50*time.Millisecond)
+	chatService.SetReplyWebhook(client)
+
+	appServer := httptest.NewServer(app)
+	defer appServer.Close()
+
+	user, token := registerUser(t, app, "blocked@example.com", "password123")
+	conn := dialTestWebSocket(t, appServer, token)
+	defer conn.Close()
+
+	start := time.Now()
+	err := chatService.HandleAdminReply(xmpp.XMPPMessage{
+		From: "agent@example.com",
+		To:   user["xmpp_jid"].(string),
+		Body: "Still delivered",
+	})
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+
+	// The user gets the reply while the webhook is still failing
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var frame map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&frame))
+	assert.Equal(t, "message", frame["type"])
+	assert.Equal(t, "Still delivered", frame["content"])
+
+	messages, err := database.GetUserMessages(int(user["id"].(float64)))
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+
+	client.Wait()
+	assert.EqualValues(t, 3, recorder.calls.Load())
+}
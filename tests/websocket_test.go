@@ -2,10 +2,15 @@ package tests
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"net/url"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -17,6 +22,7 @@ import (
 	"github.com/ngenohkevin/veilsupport/internal/ws"
 	"github.com/ngenohkevin/veilsupport/internal/xmpp"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func connectWebSocket(t *testing.T, app *gin.Engine, token string) *websocket.Conn {
@@ -60,6 +66,7 @@ func setupWebSocketTestApp(t *testing.T) (*gin.Engine, *chat.ChatService) {
 	
 	// Setup chat service
 	chatService := chat.NewChatService(database, xmppClient, wsManager)
+	chatService.SetAdminAllowlist([]string{"admin@server.com"})
 	
 	// Store reference for simulateAdminMessage
 	testChatService = chatService
@@ -151,11 +158,12 @@ func TestWebSocketReceiveMessage(t *testing.T) {
 	// Set read deadline to avoid hanging
 	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
 	
-	var msg map[string]string
+	var msg map[string]interface{}
 	err = ws.ReadJSON(&msg)
 	assert.NoError(t, err)
 	assert.Equal(t, "message", msg["type"])
 	assert.Equal(t, "Reply from admin", msg["content"])
+	assert.Equal(t, float64(1), msg["seq"])
 }
 
 func TestWebSocketInvalidToken(t *testing.T) {
@@ -327,4 +335,341 @@ func TestWebSocketMessageHistory(t *testing.T) {
 	err = json.Unmarshal(w.Body.Bytes(), &historyResp)
 	assert.NoError(t, err)
 	assert.Len(t, historyResp["messages"], 2)
-}
\ No newline at end of file
+}
+// startManagerServer serves WebSockets straight into the manager, using the
+// "user" query parameter as the user ID. With "admin" set the connection is
+// an admin dashboard.
+func startManagerServer(t *testing.T, manager *ws.Manager) *httptest.Server {
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := strconv.Atoi(r.URL.Query().Get("user"))
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		if r.URL.Query().Get("admin") != "" {
+			manager.AddAdminClient(userID, conn)
+		} else {
+			manager.AddClient(userID, conn)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWebSocketReconnectClosesOldConnection(t *testing.T) {
+	manager := ws.NewManager()
+	server := startManagerServer(t, manager)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	
+	old, _, err := websocket.DefaultDialer.Dial(wsURL+"?user=1", nil)
+	require.NoError(t, err)
+	defer old.Close()
+	old.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := old.ReadMessage()
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "connected")
+	
+	current, _, err := websocket.DefaultDialer.Dial(wsURL+"?user=1", nil)
+	require.NoError(t, err)
+	defer current.Close()
+	current.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err = current.ReadMessage()
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "connected")
+	
+	// The replaced connection is closed rather than left open
+	old.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = old.ReadMessage()
+	require.Error(t, err)
+	var netErr net.Error
+	assert.False(t, errors.As(err, &netErr) && netErr.Timeout(), "old connection left open")
+	assert.Equal(t, 1, manager.GetClientCount())
+	
+	// Messages go to the new connection
+	manager.SendToUser(1, []byte(`{"type":"ping"}`))
+	_, data, err = current.ReadMessage()
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "ping")
+}
+
+func TestWebSocketIdleReaping(t *testing.T) {
+	manager := ws.NewManager()
+	manager.SetIdleTimeout(200 * time.Millisecond)
+	server := startManagerServer(t, manager)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	
+	silent, _, err := websocket.DefaultDialer.Dial(wsURL+"?user=1", nil)
+	require.NoError(t, err)
+	defer silent.Close()
+	active, _, err := websocket.DefaultDialer.Dial(wsURL+"?user=2", nil)
+	require.NoError(t, err)
+	defer active.Close()
+	
+	require.Eventually(t, func() bool { return manager.GetClientCount() == 2 }, time.Second, 10*time.Millisecond)
+	
+	// Nothing is idle yet
+	assert.Equal(t, 0, manager.ReapIdle())
+	
+	// Only the active client keeps talking past the threshold
+	for i := 0; i < 6; i++ {
+		time.Sleep(50 * time.Millisecond)
+		require.NoError(t, active.WriteMessage(websocket.TextMessage, []byte(`{"type":"typing"}`)))
+	}
+	time.Sleep(50 * time.Millisecond)
+	
+	assert.Equal(t, 1, manager.ReapIdle())
+	assert.Equal(t, 1, manager.GetClientCount())
+	assert.Equal(t, 1, manager.GetReapedCount())
+	
+	// The silent client is told the connection is closing
+	silent.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err = silent.ReadMessage(); err != nil {
+			break
+		}
+	}
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNoStatusReceived, websocket.CloseNormalClosure) ||
+		strings.Contains(err.Error(), "close"), err.Error())
+	
+	// The active client is still served
+	manager.SendToUser(2, []byte(`{"type":"ping"}`))
+	active.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := active.ReadMessage()
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "connected")
+}
+
+func TestWebSocketSendsDuringReaping(t *testing.T) {
+	manager := ws.NewManager()
+	manager.SetIdleTimeout(time.Nanosecond)
+	server := startManagerServer(t, manager)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	
+	// Reaping closes clients while other goroutines are sending to them,
+	// which must never send on a closed channel
+	for round := 0; round < 50; round++ {
+		for user := 1; user <= 20; user++ {
+			for _, query := range []string{"?user=%d", "?admin=1&user=%d"} {
+				conn, _, err := websocket.DefaultDialer.Dial(wsURL+fmt.Sprintf(query, user), nil)
+				require.NoError(t, err)
+				defer conn.Close()
+			}
+		}
+		require.Eventually(t, func() bool {
+			return manager.GetClientCount() == 20 && manager.GetAdminCount() == 20
+		}, time.Second, time.Millisecond)
+		
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					for user := 1; user <= 20; user++ {
+						manager.SendToUser(user, []byte(`{"type":"message"}`))
+					}
+					manager.BroadcastToAdmins([]byte(`{"type":"new_message"}`))
+				}
+			}()
+		}
+		
+		for manager.GetClientCount() > 0 || manager.GetAdminCount() > 0 {
+			manager.ReapIdle()
+		}
+		close(stop)
+		wg.Wait()
+	}
+}
+
+func TestWebSocketReapingDisabledByDefault(t *testing.T) {
+	manager := ws.NewManager()
+	server := startManagerServer(t, manager)
+	
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?user=1", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return manager.GetClientCount() == 1 }, time.Second, 10*time.Millisecond)
+	
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, manager.ReapIdle())
+	assert.Equal(t, 1, manager.GetClientCount())
+}
+
+
+func TestWebSocketOfflineQueueFlushesInBatches(t *testing.T) {
+	manager := ws.NewManager()
+	manager.SetOfflineQueue(500, 10, 30*time.Millisecond)
+	server := startManagerServer(t, manager)
+	
+	// Everything sent while the user is away is kept
+	const queued = 95
+	for i := 0; i < queued; i++ {
+		manager.SendToUser(1, []byte(fmt.Sprintf(`{"type":"message","n":%d}`, i)))
+	}
+	require.Equal(t, queued, manager.GetQueuedCount(1))
+	
+	start := time.Now()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?user=1", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	
+	// Sent mid-flush, so it must wait behind the queue
+	require.Eventually(t, func() bool { return manager.GetClientCount() == 1 }, time.Second, time.Millisecond)
+	manager.SendToUser(1, []byte(`{"type":"message","n":-1}`))
+	
+	var order []int
+	for len(order) < queued+1 {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		
+		// A frame may carry several messages, but never more than a batch
+		inFrame := 0
+		for _, line := range strings.Split(string(data), "\n") {
+			var msg struct {
+				Type string `json:"type"`
+				N    int    `json:"n"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(line), &msg))
+			if msg.Type == "message" {
+				order = append(order, msg.N)
+				inFrame++
+			}
+		}
+		assert.LessOrEqual(t, inFrame, 10)
+	}
+	
+	// Ten batches with a pause between each
+	assert.GreaterOrEqual(t, time.Since(start), 9*30*time.Millisecond)
+	for i := 0; i < queued; i++ {
+		require.Equal(t, i, order[i])
+	}
+	assert.Equal(t, -1, order[queued])
+	assert.Equal(t, 0, manager.GetQueuedCount(1))
+}
+
+func TestWebSocketOfflineQueueLimit(t *testing.T) {
+	manager := ws.NewManager()
+	
+	// Disabled by default: messages for absent users are dropped
+	manager.SendToUser(1, []byte(`{"type":"message"}`))
+	assert.Equal(t, 0, manager.GetQueuedCount(1))
+	
+	// The oldest messages make way for new ones
+	manager.SetOfflineQueue(3, 0, 0)
+	for i := 0; i < 5; i++ {
+		manager.SendToUser(1, []byte(fmt.Sprintf(`{"type":"message","n":%d}`, i)))
+	}
+	assert.Equal(t, 3, manager.GetQueuedCount(1))
+	
+	server := startManagerServer(t, manager)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?user=1", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	
+	var received string
+	for strings.Count(received, `"n"`) < 3 {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		received += string(data) + "\n"
+	}
+	assert.NotContains(t, received, `"n":1}`)
+	assert.Regexp(t, `"n":2}(.|\n)*"n":3}(.|\n)*"n":4}`, received)
+}
+// readFrames reads from conn until it has seen n "message" frames and returns
+// their message IDs in order
+func readFrames(t *testing.T, conn *websocket.Conn, n int) []int {
+	var ids []int
+	for len(ids) < n {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		for _, line := range strings.Split(string(data), "\n") {
+			var msg struct {
+				Type      string `json:"type"`
+				MessageID int    `json:"message_id"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(line), &msg))
+			if msg.Type == "message" {
+				ids = append(ids, msg.MessageID)
+			}
+		}
+	}
+	return ids
+}
+
+func TestWebSocketDeliveryReceiptClearsQueue(t *testing.T) {
+	manager := ws.NewManager()
+	manager.SetOfflineQueue(10, 0, 0)
+	delivered := make(chan [2]int, 1)
+	manager.SetDeliveryReceipts(func(userID, messageID int) error {
+		delivered <- [2]int{userID, messageID}
+		return nil
+	})
+	server := startManagerServer(t, manager)
+	
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?user=1", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return manager.GetClientCount() == 1 }, time.Second, time.Millisecond)
+	
+	// Sent straight away, but kept until the client confirms it
+	manager.SendToUser(1, []byte(`{"type":"message","message_id":7}`))
+	assert.Equal(t, 1, manager.GetQueuedCount(1))
+	
+	// Events about messages aren't acknowledged, so they aren't kept
+	manager.SendToUser(1, []byte(`{"type":"edited","message_id":3}`))
+	assert.Equal(t, 1, manager.GetQueuedCount(1))
+	
+	assert.Equal(t, []int{7}, readFrames(t, conn, 1))
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"delivered","message_id":7}`)))
+	
+	select {
+	case receipt := <-delivered:
+		assert.Equal(t, [2]int{1, 7}, receipt)
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery receipt was not handled")
+	}
+	assert.Equal(t, 0, manager.GetQueuedCount(1))
+}
+
+func TestWebSocketUnacknowledgedMessagesAreResent(t *testing.T) {
+	manager := ws.NewManager()
+	manager.SetOfflineQueue(10, 0, 0)
+	manager.SetDeliveryReceipts(func(userID, messageID int) error { return nil })
+	server := startManagerServer(t, manager)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "?user=1"
+	
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return manager.GetClientCount() == 1 }, time.Second, time.Millisecond)
+	
+	manager.SendToUser(1, []byte(`{"type":"message","message_id":1}`))
+	manager.SendToUser(1, []byte(`{"type":"message","message_id":2}`))
+	assert.Equal(t, []int{1, 2}, readFrames(t, conn, 2))
+	
+	// Only the first is confirmed before the connection drops
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"delivered","message_id":1}`)))
+	require.Eventually(t, func() bool { return manager.GetQueuedCount(1) == 1 }, time.Second, time.Millisecond)
+	conn.Close()
+	require.Eventually(t, func() bool { return manager.GetClientCount() == 0 }, time.Second, time.Millisecond)
+	
+	// The next connection gets the unconfirmed one again, and it stays
+	// queued until that connection confirms it too
+	conn, _, err = websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, []int{2}, readFrames(t, conn, 1))
+	assert.Equal(t, 1, manager.GetQueuedCount(1))
+	
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"delivered","message_id":2}`)))
+	require.Eventually(t, func() bool { return manager.GetQueuedCount(1) == 0 }, time.Second, time.Millisecond)
+}
@@ -69,7 +69,7 @@ func TestWebUserToXMPPBridge(t *testing.T) {
 	testMessage := fmt.Sprintf("BRIDGE TEST: This message is from web user %s sent at %s. If you receive this in your XMPP client, the bridge is working!", 
 		userEmail, time.Now().Format("15:04:05"))
 	
-	err = chatService.SendMessage(user.ID, testMessage)
+	err = chatService.SendMessage(context.Background(), user.ID, testMessage)
 	require.NoError(t, err)
 	
 	t.Log("✅ Message sent through chat service")
@@ -152,7 +152,7 @@ func TestMultipleWebUsersToXMPP(t *testing.T) {
 			i+1, userEmail, time.Now().Format("15:04:05"))
 		
 		t.Logf("📤 User %d sending message...", i+1)
-		err = chatService.SendMessage(user.ID, message)
+		err = chatService.SendMessage(context.Background(), user.ID, message)
 		require.NoError(t, err)
 		
 		// Small delay between messages
@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/ngenohkevin/veilsupport/internal/xmpp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXMPPTLSDefaults(t *testing.T) {
+	opts, err := xmpp.NewTLSOptions("", "")
+	require.NoError(t, err)
+	
+	config := opts.Config("xmpp.example.com")
+	assert.Equal(t, "xmpp.example.com", config.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+	assert.Empty(t, config.CipherSuites)
+}
+
+func TestXMPPTLSMinVersion(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected uint16
+	}{
+		{"1.2", tls.VersionTLS12},
+		{"1.3", tls.VersionTLS13},
+		{"TLS1.3", tls.VersionTLS13},
+	}
+	
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			opts, err := xmpp.NewTLSOptions(tc.input, "")
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, opts.Config("xmpp.example.com").MinVersion)
+		})
+	}
+	
+	_, err := xmpp.NewTLSOptions("2.0", "")
+	assert.Error(t, err)
+	
+	// Parseable but insecure versions are refused
+	for _, insecure := range []string{"1.0", "1.1", "TLS1.1"} {
+		_, err := xmpp.NewTLSOptions(insecure, "")
+		assert.Error(t, err, insecure)
+	}
+}
+
+func TestXMPPTLSCipherSuites(t *testing.T) {
+	opts, err := xmpp.NewTLSOptions("1.2", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384")
+	require.NoError(t, err)
+	
+	config := opts.Config("xmpp.example.com")
+	assert.Equal(t, []uint16{
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	}, config.CipherSuites)
+	
+	// Unknown or insecure suites are rejected
+	_, err = xmpp.NewTLSOptions("1.2", "TLS_RSA_WITH_RC4_128_SHA")
+	assert.Error(t, err)
+}